@@ -0,0 +1,163 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+var (
+	// ErrBodyNotReplayable is returned when a request must be sent again, e.g., to retry it, but its body has already been consumed
+	// and cannot be rewound.
+	ErrBodyNotReplayable = &RestError{ErrorString: "request body has already been consumed and cannot be rewound"}
+)
+
+// The replayableBody interface is implemented by request bodies which may or may not support being sent more than once.
+// Bodies which do not implement it are assumed to be replayable.
+type replayableBody interface {
+	replayable() bool
+}
+
+// A BytesRequestBody sends a fixed slice of bytes, labelled with a caller-chosen MIME type.
+// If Type is empty, the body is sent as application/octet-stream.
+type BytesRequestBody struct {
+	Data []byte
+	Type string
+}
+
+func (b *BytesRequestBody) ContentType() (string, error) {
+	if b.Type == "" {
+		return "application/octet-stream", nil
+	}
+	return b.Type, nil
+}
+
+func (b *BytesRequestBody) ContentLength() (int64, error) {
+	return int64(len(b.Data)), nil
+}
+
+func (b *BytesRequestBody) Body() (io.Reader, error) {
+	return bytes.NewReader(b.Data), nil
+}
+
+func (b *BytesRequestBody) bytes() ([]byte, error) {
+	return b.Data, nil
+}
+
+// A ReaderRequestBody streams its content from an io.Reader, such as a file or a pipe, without buffering it in memory.
+//
+// If the length of the content is not known in advance, set Length to -1; the body will then be sent using chunked transfer encoding.
+// If Type is empty, the body is sent as application/octet-stream.
+//
+// A ReaderRequestBody can be sent more than once (to retry a failed request, for example) only if its Reader is also an io.Seeker;
+// it is rewound to the offset at which it was first read.
+// Otherwise, attempting to send it a second time fails with ErrBodyNotReplayable, and retry policies will decline to retry it.
+//
+// The RestClient never closes the Reader; that remains the responsibility of the caller.
+type ReaderRequestBody struct {
+	Reader io.Reader
+	Type   string
+	Length int64
+
+	read   bool
+	offset int64
+}
+
+// NewReaderRequestBody() creates a streaming request body of the given type and length.
+// Pass -1 for the length if it is not known.
+func NewReaderRequestBody(r io.Reader, contentType string, length int64) *ReaderRequestBody {
+	return &ReaderRequestBody{
+		Reader: r,
+		Type:   contentType,
+		Length: length,
+	}
+}
+
+// NewFileRequestBody() creates a streaming request body which sends the remainder of the file from its current offset.
+// Since files may be rewound, the resulting body may be retried safely.
+func NewFileRequestBody(f *os.File, contentType string) (*ReaderRequestBody, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReaderRequestBody(f, contentType, info.Size()-offset), nil
+}
+
+func (b *ReaderRequestBody) ContentType() (string, error) {
+	if b.Type == "" {
+		return "application/octet-stream", nil
+	}
+	return b.Type, nil
+}
+
+func (b *ReaderRequestBody) ContentLength() (int64, error) {
+	return b.Length, nil
+}
+
+func (b *ReaderRequestBody) Body() (io.Reader, error) {
+	seeker, canSeek := b.Reader.(io.Seeker)
+
+	if !b.read {
+		b.read = true
+		if canSeek {
+			offset, err := seeker.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			b.offset = offset
+		}
+	} else {
+		if !canSeek {
+			return nil, ErrBodyNotReplayable
+		}
+		if _, err := seeker.Seek(b.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	// Hide any Close method the reader may have, so that net/http does not close it on the caller's behalf.
+	return struct{ io.Reader }{b.Reader}, nil
+}
+
+func (b *ReaderRequestBody) replayable() bool {
+	if !b.read {
+		return true
+	}
+	_, canSeek := b.Reader.(io.Seeker)
+	return canSeek
+}
+
+// Stream() yields the response body for incremental reading, for responses too large to hold in memory.
+// The caller must close the stream once done with it.
+func (r *RestResponse) Stream() io.ReadCloser {
+	return r.Body
+}
+
+// WriteTo() copies the response body to w as it arrives, then closes the body.
+// It returns the number of bytes copied.
+func (r *RestResponse) WriteTo(w io.Writer) (int64, error) {
+	defer r.Body.Close()
+	return io.Copy(w, r.Body)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bodyCapture is a transport which records the bodies it is sent, then fails with the configured status codes.
+type bodyCapture struct {
+	testTransport
+	bodies []string
+	chunks []bool
+}
+
+func (t *bodyCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	data, _ := ioutil.ReadAll(req.Body)
+	t.bodies = append(t.bodies, string(data))
+	t.chunks = append(t.chunks, req.ContentLength < 0)
+	return t.testTransport.RoundTrip(req)
+}
+
+func TestReaderBodyOfUnknownLengthIsChunked(t *testing.T) {
+	transport := &bodyCapture{}
+	c := MakeRestClient("http://example.com")
+	c.client = &http.Client{Transport: transport}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("streamed "))
+		pw.Write([]byte("content"))
+		pw.Close()
+	}()
+
+	body := NewReaderRequestBody(pr, "text/plain", -1)
+	_, err := c.PerformRequest(&RestRequest{Method: "PUT", Path: "/container/object", Body: body})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !transport.chunks[0] {
+		t.Error("Expected a body of unknown length to be sent chunked")
+		return
+	}
+	if transport.bodies[0] != "streamed content" {
+		t.Error("Expected the streamed content to arrive intact; got", transport.bodies[0])
+		return
+	}
+}
+
+func TestSeekableReaderBodyIsRetried(t *testing.T) {
+	transport := &bodyCapture{testTransport: testTransport{statuses: []int{503, 201}}}
+	c := MakeRestClient("http://example.com")
+	c.client = &http.Client{Transport: transport}
+	c.SetRetryPolicy(&RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	body := NewReaderRequestBody(strings.NewReader("image bits"), "application/octet-stream", 10)
+	_, err := c.PerformRequest(&RestRequest{Method: "PUT", Path: "/images/x/file", Body: body, ExpectedStatusCodes: []int{201}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(transport.bodies) != 2 || transport.bodies[1] != "image bits" {
+		t.Error("Expected the rewound body to be sent again in full; got", transport.bodies)
+		return
+	}
+}
+
+func TestUnseekableReaderBodyIsNotRetried(t *testing.T) {
+	transport := &bodyCapture{testTransport: testTransport{statuses: []int{503, 201}}}
+	c := MakeRestClient("http://example.com")
+	c.client = &http.Client{Transport: transport}
+	c.SetRetryPolicy(&RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	body := NewReaderRequestBody(io.MultiReader(strings.NewReader("one-shot")), "", -1)
+	_, err := c.PerformRequest(&RestRequest{Method: "PUT", Path: "/objects/x", Body: body, ExpectedStatusCodes: []int{201}})
+	if AsAPIError(err) == nil || AsAPIError(err).StatusCode != 503 {
+		t.Error("Expected the original 503 to be reported; got", err)
+		return
+	}
+	if transport.called != 1 {
+		t.Error("Expected a single attempt; got", transport.called)
+		return
+	}
+}
+
+func TestResponseWriteTo(t *testing.T) {
+	transport := &testTransport{response: "large download"}
+	c := withTestClient(transport)
+
+	resp, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/objects/x"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := resp.WriteTo(buf)
+	if err != nil || n != int64(len("large download")) || buf.String() != "large download" {
+		t.Error("Expected the body to be copied in full; got", n, err, buf.String())
+		return
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CacheStatusHeader is added to responses served from a Cache.
+// Its value is "hit" if the server was not consulted at all, or "revalidated" if the server confirmed the cached response was still current.
+const CacheStatusHeader = "X-Gorax-Cache"
+
+// maxCachedBodySize bounds the size of any one response body a Cache will retain.
+const maxCachedBodySize = 8 * 1024 * 1024
+
+// A CachedResponse holds a response retained by a Cache, along with the time it was stored or last revalidated.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Stored     time.Time
+}
+
+// size() approximates the memory a cached response occupies, for the benefit of size-bounded stores.
+func (r *CachedResponse) size() int64 {
+	n := int64(len(r.Body))
+	for name, values := range r.Header {
+		for _, value := range values {
+			n += int64(len(name) + len(value))
+		}
+	}
+	return n
+}
+
+// The CacheStore interface holds the responses retained by a Cache.
+// Implementations must bound the space they occupy, evicting entries as needed, and must be safe for concurrent use.
+// See NewMemoryCacheStore() and NewDiskCacheStore().
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, response *CachedResponse)
+	Delete(key string)
+}
+
+// A Cache retains GET responses which carry an ETag or Last-Modified header, and revalidates them with conditional requests,
+// so that resources which rarely change cost the server, and the client, as little as possible.
+//
+// When a GET request finds a cached response, the cache sends the request with If-None-Match and If-Modified-Since headers;
+// should the server answer 304 Not Modified, the cached response is returned in its stead, as though the server had sent it again.
+// If MaxAge is positive, cached responses younger than MaxAge are returned without consulting the server at all.
+//
+// Responses are keyed by Namespace, and by the request's URL and Accept header.
+// The URL identifies the tenant: Keystone-authenticated clients address resources beneath the tenant's ID, and service catalog endpoints embed it.
+// Clients acting for different users of the same tenant should nonetheless use distinct namespaces if their views of a resource may differ.
+//
+// Responses marked no-store are never retained, and requests marked no-cache or no-store bypass the cache.
+// A successful PUT, POST, PATCH or DELETE discards any response cached for the same URL.
+type Cache struct {
+	Store     CacheStore
+	Namespace string
+	MaxAge    time.Duration
+}
+
+// NewCache() creates a cache which retains responses in the given store, and always revalidates them before use.
+func NewCache(store CacheStore) *Cache {
+	return &Cache{Store: store}
+}
+
+// RoundTripper() yields an http.RoundTripper which consults the cache before passing requests to next.
+// It exists for clients which do not use RestClient to perform their requests; RestClient users should call SetCache() instead.
+func (c *Cache) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &cacheTransport{c, next}
+}
+
+type cacheTransport struct {
+	cache *Cache
+	next  http.RoundTripper
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.cache.do(req, t.next.RoundTrip)
+}
+
+func (c *Cache) key(req *http.Request) string {
+	return c.Namespace + " " + req.Header.Get("Accept") + " " + req.URL.String()
+}
+
+// do() answers the request from the cache where possible, and otherwise sends it along, retaining the response if it may be reused.
+func (c *Cache) do(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if req.Method != "GET" {
+		resp, err := send(req)
+		if err == nil && isUnsafe(req.Method) && resp.StatusCode < 300 {
+			c.Store.Delete(c.key(req))
+		}
+		return resp, err
+	}
+	if cacheControl(req.Header, "no-cache") || cacheControl(req.Header, "no-store") {
+		return send(req)
+	}
+
+	key := c.key(req)
+	cached, ok := c.Store.Get(key)
+	if ok && c.MaxAge > 0 && time.Since(cached.Stored) < c.MaxAge {
+		return cached.response(req, "hit"), nil
+	}
+
+	outgoing := req
+	if ok {
+		outgoing = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			outgoing.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.Header.Get("Last-Modified"); modified != "" {
+			outgoing.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := send(outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		revalidated := *cached
+		revalidated.Header = cached.Header.Clone()
+		for _, name := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified"} {
+			if value := resp.Header.Get(name); value != "" {
+				revalidated.Header.Set(name, value)
+			}
+		}
+		revalidated.Stored = time.Now()
+		c.Store.Set(key, &revalidated)
+		return revalidated.response(req, "revalidated"), nil
+	}
+
+	if resp.StatusCode != http.StatusOK || cacheControl(resp.Header, "no-store") ||
+		(resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return resp, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCachedBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(data) > maxCachedBodySize {
+		resp.Body = &replayedBody{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	c.Store.Set(key, &CachedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       data,
+		Stored:     time.Now(),
+	})
+	return resp, nil
+}
+
+// response() synthesizes an http.Response from the cached one, marked with the given cache status.
+func (r *CachedResponse) response(req *http.Request, status string) *http.Response {
+	header := r.Header.Clone()
+	header.Set(CacheStatusHeader, status)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// cacheControl() reports whether the header's Cache-Control directives include the given one.
+func cacheControl(header http.Header, directive string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, d := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isUnsafe(method string) bool {
+	switch method {
+	case "PUT", "POST", "PATCH", "DELETE":
+		return true
+	}
+	return false
+}
+
+// The SetCache() function makes the client retain and revalidate GET responses in the given cache; see the Cache type.
+// Pass nil to stop caching, which is the default.
+func (c *RestClient) SetCache(cache *Cache) {
+	c.Cache = cache
+}
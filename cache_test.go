@@ -0,0 +1,218 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withETagServer serves a JSON document whose ETag changes whenever it is PUT, counting the full responses it sends.
+func withETagServer(f func(c *RestClient, full *int32)) {
+	var version, full int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "PUT" {
+			atomic.AddInt32(&version, 1)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		etag := fmt.Sprintf(`"v%d"`, atomic.LoadInt32(&version))
+		w.Header().Set("ETag", etag)
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"version":%d}`, atomic.LoadInt32(&version))
+	}))
+	defer server.Close()
+
+	f(MakeRestClient(server.URL), &full)
+}
+
+func getVersion(c *RestClient) (int, string, error) {
+	resp, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/entities/en1", ExpectedStatusCodes: []int{200}})
+	if err != nil {
+		return 0, "", err
+	}
+	doc := struct{ Version int }{}
+	err = resp.DeserializeBody(&doc)
+	return doc.Version, resp.Header.Get(CacheStatusHeader), err
+}
+
+func TestCacheRevalidates(t *testing.T) {
+	withETagServer(func(c *RestClient, full *int32) {
+		c.SetCache(NewCache(NewMemoryCacheStore(1 << 20)))
+
+		for i, expected := range []string{"", "revalidated", "revalidated"} {
+			version, status, err := getVersion(c)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if version != 0 || status != expected {
+				t.Error("Request", i, "expected version 0 with cache status", expected, "; got", version, status)
+				return
+			}
+		}
+		if *full != 1 {
+			t.Error("Expected the document to be sent once; got", *full)
+			return
+		}
+
+		_, err := c.PerformRequest(&RestRequest{Method: "PUT", Path: "/entities/en1", ExpectedStatusCodes: []int{204}})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		version, status, err := getVersion(c)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if version != 1 || status != "" || *full != 2 {
+			t.Error("Expected a fresh copy after the update; got", version, status, *full)
+			return
+		}
+	})
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	withETagServer(func(c *RestClient, full *int32) {
+		cache := NewCache(NewMemoryCacheStore(1 << 20))
+		cache.MaxAge = time.Hour
+		c.SetCache(cache)
+
+		getVersion(c)
+		_, status, err := getVersion(c)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if status != "hit" {
+			t.Error("Expected a fresh response to be served without revalidation; got", status)
+			return
+		}
+	})
+}
+
+func TestCacheIgnoresUncacheableResponses(t *testing.T) {
+	transport := &testTransport{
+		header:   http.Header{"Content-Type": {"application/json"}, "Etag": {`"x"`}, "Cache-Control": {"private, no-store"}},
+		response: "{}",
+	}
+	c := withTestClient(transport)
+	store := NewMemoryCacheStore(1 << 20)
+	c.SetCache(NewCache(store))
+
+	c.PerformRequest(&RestRequest{Method: "GET", Path: "/a"})
+	if store.Size() != 0 {
+		t.Error("Expected a no-store response not to be cached")
+		return
+	}
+
+	transport.header = http.Header{"Content-Type": {"application/json"}}
+	c.PerformRequest(&RestRequest{Method: "GET", Path: "/a"})
+	if store.Size() != 0 {
+		t.Error("Expected a response without validators not to be cached")
+		return
+	}
+}
+
+func cachedResponse(body string) *CachedResponse {
+	return &CachedResponse{StatusCode: 200, Header: http.Header{}, Body: []byte(body), Stored: time.Now()}
+}
+
+func TestMemoryCacheStoreEvicts(t *testing.T) {
+	s := NewMemoryCacheStore(30)
+	s.Set("a", cachedResponse("0123456789"))
+	s.Set("b", cachedResponse("0123456789"))
+	s.Get("a")
+	s.Set("c", cachedResponse("0123456789"))
+
+	if _, ok := s.Get("b"); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+		return
+	}
+	if _, ok := s.Get("a"); !ok {
+		t.Error("Expected a recently used entry to survive")
+		return
+	}
+	if s.Size() > 30 {
+		t.Error("Expected the store to respect its bound; got", s.Size())
+		return
+	}
+
+	s.Set("d", cachedResponse("this body is far too large for the store"))
+	if _, ok := s.Get("d"); ok {
+		t.Error("Expected an oversized entry to be refused")
+		return
+	}
+}
+
+func TestDiskCacheStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorax-cache")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewDiskCacheStore(dir, 1<<20)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.Set("key", cachedResponse("persisted"))
+
+	s, err = NewDiskCacheStore(dir, 1<<20)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r, ok := s.Get("key")
+	if !ok || string(r.Body) != "persisted" {
+		t.Error("Expected the response to survive reopening the store; got", r, ok)
+		return
+	}
+	size := s.Size()
+
+	s.Set("other", cachedResponse("persisted"))
+	s, err = NewDiskCacheStore(dir, size)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if s.Size() > size {
+		t.Error("Expected reopening with a smaller bound to evict; got", s.Size())
+		return
+	}
+
+	s.Delete("other")
+	s.Delete("key")
+	if s.Size() != 0 {
+		t.Error("Expected deleted entries to be removed; got", s.Size())
+		return
+	}
+}
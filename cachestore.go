@@ -0,0 +1,269 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A MemoryCacheStore holds cached responses in memory, evicting the least recently used once its size bound is reached.
+type MemoryCacheStore struct {
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	order    *list.List
+	lock     sync.Mutex
+}
+
+type memoryEntry struct {
+	key      string
+	response *CachedResponse
+	size     int64
+}
+
+// NewMemoryCacheStore() creates an in-memory store holding at most maxBytes of response headers and bodies.
+func NewMemoryCacheStore(maxBytes int64) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		maxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+// Get implements the CacheStore interface.
+func (s *MemoryCacheStore) Get(key string) (*CachedResponse, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(e)
+	return e.Value.(*memoryEntry).response, true
+}
+
+// Set implements the CacheStore interface.  Responses larger than the store's bound are not retained.
+func (s *MemoryCacheStore) Set(key string, response *CachedResponse) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.remove(key)
+
+	size := response.size() + int64(len(key))
+	if size > s.maxBytes {
+		return
+	}
+	for s.size+size > s.maxBytes {
+		s.remove(s.order.Back().Value.(*memoryEntry).key)
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryEntry{key, response, size})
+	s.size += size
+}
+
+// Delete implements the CacheStore interface.
+func (s *MemoryCacheStore) Delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.remove(key)
+}
+
+// Size() reports the number of bytes the store currently holds.
+func (s *MemoryCacheStore) Size() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+func (s *MemoryCacheStore) remove(key string) {
+	if e, ok := s.entries[key]; ok {
+		s.size -= e.Value.(*memoryEntry).size
+		s.order.Remove(e)
+		delete(s.entries, key)
+	}
+}
+
+// A DiskCacheStore holds cached responses as files within a directory, so that they survive the process.
+// Once its size bound is reached, it evicts the least recently used files.
+//
+// The directory should be dedicated to the store; files in it which the store did not write are ignored, but may be mistaken for its own if named alike.
+// Only one process should use a given directory at a time.
+type DiskCacheStore struct {
+	dir      string
+	maxBytes int64
+	size     int64
+	files    map[string]*diskEntry
+	lock     sync.Mutex
+}
+
+type diskEntry struct {
+	size int64
+	used time.Time
+}
+
+type diskRecord struct {
+	Key      string          `json:"key"`
+	Response *CachedResponse `json:"response"`
+}
+
+const diskCacheSuffix = ".cache.json"
+
+// NewDiskCacheStore() creates a store which keeps at most maxBytes of files within dir, creating the directory if necessary.
+// Responses cached in dir by an earlier store are retained, subject to the new bound.
+func NewDiskCacheStore(dir string, maxBytes int64) (*DiskCacheStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &DiskCacheStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		files:    map[string]*diskEntry{},
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), diskCacheSuffix) {
+			s.files[info.Name()] = &diskEntry{info.Size(), info.ModTime()}
+			s.size += info.Size()
+		}
+	}
+	s.evict(0)
+
+	return s, nil
+}
+
+func (s *DiskCacheStore) name(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + diskCacheSuffix
+}
+
+// Get implements the CacheStore interface.
+func (s *DiskCacheStore) Get(key string) (*CachedResponse, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	name := s.name(key)
+	entry, ok := s.files[name]
+	if !ok {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		s.remove(name)
+		return nil, false
+	}
+	record := &diskRecord{}
+	if err := json.Unmarshal(data, record); err != nil || record.Key != key || record.Response == nil {
+		return nil, false
+	}
+
+	entry.used = time.Now()
+	os.Chtimes(filepath.Join(s.dir, name), entry.used, entry.used)
+	return record.Response, true
+}
+
+// Set implements the CacheStore interface.  Responses which cannot be written, or are larger than the store's bound, are not retained.
+func (s *DiskCacheStore) Set(key string, response *CachedResponse) {
+	data, err := json.Marshal(&diskRecord{key, response})
+	if err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	name := s.name(key)
+	s.remove(name)
+
+	size := int64(len(data))
+	if size > s.maxBytes {
+		return
+	}
+	s.evict(size)
+
+	path := filepath.Join(s.dir, name)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return
+	}
+
+	s.files[name] = &diskEntry{size, time.Now()}
+	s.size += size
+}
+
+// Delete implements the CacheStore interface.
+func (s *DiskCacheStore) Delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.remove(s.name(key))
+}
+
+// Size() reports the number of bytes the store's files currently occupy.
+func (s *DiskCacheStore) Size() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+func (s *DiskCacheStore) remove(name string) {
+	if entry, ok := s.files[name]; ok {
+		os.Remove(filepath.Join(s.dir, name))
+		s.size -= entry.size
+		delete(s.files, name)
+	}
+}
+
+// evict() removes the least recently used files until another n bytes would fit within the store's bound.
+func (s *DiskCacheStore) evict(n int64) {
+	if s.size+n <= s.maxBytes {
+		return
+	}
+
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return s.files[names[i]].used.Before(s.files[names[j]].used)
+	})
+
+	for _, name := range names {
+		if s.size+n <= s.maxBytes {
+			return
+		}
+		s.remove(name)
+	}
+}
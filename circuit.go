@@ -0,0 +1,284 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A CircuitState describes whether a circuit breaker is letting requests through.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through, counting failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails every request immediately, until the cool-down period has passed.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through, to learn whether the endpoint has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// ErrCircuitOpen is matched, by errors.Is(), by every error a circuit breaker returns in place of performing a request.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// A CircuitOpenError reports a request refused by a circuit breaker without being attempted.
+// Circuit names the circuit concerned, and RetryAt the earliest time at which the breaker will let a probe request through.
+type CircuitOpenError struct {
+	Circuit string
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open for %s until %s", e.Circuit, e.RetryAt.Format(time.RFC3339))
+}
+
+// Is lets errors.Is() match a CircuitOpenError against ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// A CircuitBreaker stops requests from reaching an endpoint which keeps failing, so that callers fail fast instead of piling up behind it.
+//
+// Requests are grouped into circuits by endpoint and operation; an endpoint is a RestClient's BaseUrl, and an operation is the request's
+// Operation field, or failing that its method.
+// Each circuit starts closed.
+// Once FailureThreshold consecutive requests fail, it opens, and requests fail at once with a *CircuitOpenError for the CoolDown period.
+// The circuit then becomes half-open, letting up to HalfOpenProbes requests through at a time:
+// a successful probe closes the circuit again, while a failed one reopens it for another cool-down period.
+//
+// By default, a request fails if it times out, if no response arrives, or if the server answers with a 5xx status other than 501.
+// Requests abandoned because their context was cancelled count neither way.
+// Set IsFailure to change what counts as a failure.
+//
+// If OnStateChange is set, it is called whenever a circuit changes state.  It must not block, and must not use the breaker.
+//
+// A single breaker may be shared among many RestClients, since their circuits are distinct; see RestClient.UseCircuitBreaker().
+type CircuitBreaker struct {
+	FailureThreshold int
+	CoolDown         time.Duration
+	HalfOpenProbes   int
+	IsFailure        func(resp *RestResponse, err error) bool
+	OnStateChange    func(circuit string, from, to CircuitState)
+
+	lock     sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	probes   int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker() creates a breaker which opens after five consecutive failures, for thirty seconds, and then admits a single probe.
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: 5,
+		CoolDown:         30 * time.Second,
+		HalfOpenProbes:   1,
+	}
+}
+
+// State() reports the current state of the named circuit, e.g., "https://monitoring.api.rackspacecloud.com/v1.0 monitoring.ListChecks".
+func (b *CircuitBreaker) State(name string) CircuitState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	c, ok := b.circuits[name]
+	if !ok {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.CoolDown {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// ForEndpoint() yields a round-trip middleware which applies the breaker to requests made to the given endpoint.
+func (b *CircuitBreaker) ForEndpoint(endpoint string) *EndpointCircuitBreaker {
+	return &EndpointCircuitBreaker{Breaker: b, Endpoint: endpoint}
+}
+
+// HandleRoundTrip applies the breaker to requests whose endpoint is unknown; their circuits are named by operation alone.
+func (b *CircuitBreaker) HandleRoundTrip(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error) {
+	return b.ForEndpoint("").HandleRoundTrip(ctx, req, next)
+}
+
+// An EndpointCircuitBreaker is a RoundTripMiddleware which applies a CircuitBreaker to the requests made to one endpoint.
+type EndpointCircuitBreaker struct {
+	Breaker  *CircuitBreaker
+	Endpoint string
+}
+
+// HandleRoundTrip performs the request through next, unless its circuit is open.
+func (e *EndpointCircuitBreaker) HandleRoundTrip(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error) {
+	operation := req.Operation
+	if operation == "" {
+		operation = req.Method
+	}
+	name := operation
+	if e.Endpoint != "" {
+		name = e.Endpoint + " " + operation
+	}
+
+	if err := e.Breaker.admit(name); err != nil {
+		return nil, err
+	}
+
+	resp, err := next(ctx, req)
+
+	switch {
+	case ctx.Err() == context.Canceled:
+		e.Breaker.release(name)
+	case e.Breaker.isFailure(resp, err):
+		e.Breaker.record(name, false)
+	default:
+		e.Breaker.record(name, true)
+	}
+	return resp, err
+}
+
+// admit() decides whether a request may proceed on the named circuit.
+func (b *CircuitBreaker) admit(name string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.circuits == nil {
+		b.circuits = map[string]*circuit{}
+	}
+	c, ok := b.circuits[name]
+	if !ok {
+		c = &circuit{}
+		b.circuits[name] = c
+	}
+
+	if c.state == CircuitOpen {
+		if time.Since(c.openedAt) < b.CoolDown {
+			return &CircuitOpenError{Circuit: name, RetryAt: c.openedAt.Add(b.CoolDown)}
+		}
+		b.transition(name, c, CircuitHalfOpen)
+	}
+
+	if c.state == CircuitHalfOpen {
+		probes := b.HalfOpenProbes
+		if probes < 1 {
+			probes = 1
+		}
+		if c.probes >= probes {
+			return &CircuitOpenError{Circuit: name, RetryAt: time.Now().Add(b.CoolDown)}
+		}
+		c.probes++
+	}
+	return nil
+}
+
+// release() returns a probe slot taken by a request which neither succeeded nor failed.
+func (b *CircuitBreaker) release(name string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if c := b.circuits[name]; c.state == CircuitHalfOpen && c.probes > 0 {
+		c.probes--
+	}
+}
+
+// record() notes the outcome of a request on the named circuit, tripping or resetting it as needed.
+func (b *CircuitBreaker) record(name string, success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	c := b.circuits[name]
+	if success {
+		c.failures = 0
+		if c.state == CircuitHalfOpen {
+			b.transition(name, c, CircuitClosed)
+		}
+		return
+	}
+
+	c.failures++
+	threshold := b.FailureThreshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	if c.state == CircuitHalfOpen || (c.state == CircuitClosed && c.failures >= threshold) {
+		c.openedAt = time.Now()
+		b.transition(name, c, CircuitOpen)
+	}
+}
+
+func (b *CircuitBreaker) transition(name string, c *circuit, to CircuitState) {
+	from := c.state
+	c.state = to
+	c.probes = 0
+	if to == CircuitClosed {
+		c.failures = 0
+	}
+	if b.OnStateChange != nil && from != to {
+		b.OnStateChange(name, from, to)
+	}
+}
+
+func (b *CircuitBreaker) isFailure(resp *RestResponse, err error) bool {
+	if b.IsFailure != nil {
+		return b.IsFailure(resp, err)
+	}
+
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	} else if apiErr := AsAPIError(err); apiErr != nil {
+		status = apiErr.StatusCode
+	} else if err != nil {
+		return true
+	}
+	return status >= 500 && status != http.StatusNotImplemented
+}
+
+// UseCircuitBreaker() protects the client's endpoint with the given breaker, which may be shared with other clients; see CircuitBreaker.
+// The breaker is installed ahead of the client's other round-trip middlewares, so that refused requests cost nothing further.
+// Pass nil to remove a previously installed breaker.
+func (c *RestClient) UseCircuitBreaker(breaker *CircuitBreaker) {
+	middlewares := []RoundTripMiddleware{}
+	for _, middleware := range c.RoundTripMiddlewares {
+		if _, ok := middleware.(*EndpointCircuitBreaker); !ok {
+			middlewares = append(middlewares, middleware)
+		}
+	}
+	if breaker != nil {
+		middlewares = append([]RoundTripMiddleware{breaker.ForEndpoint(c.BaseUrl)}, middlewares...)
+	}
+	c.RoundTripMiddlewares = middlewares
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTripsAndRecovers(t *testing.T) {
+	transport := &testTransport{statuses: []int{503, 503, 503, 200}, response: "{}"}
+	c := withTestClient(transport)
+
+	var changes []string
+	breaker := NewCircuitBreaker()
+	breaker.FailureThreshold = 2
+	breaker.CoolDown = 20 * time.Millisecond
+	breaker.OnStateChange = func(circuit string, from, to CircuitState) {
+		changes = append(changes, fmt.Sprintf("%s %s->%s", circuit, from, to))
+	}
+	c.UseCircuitBreaker(breaker)
+	c.UseCircuitBreaker(breaker)
+	if len(c.RoundTripMiddlewares) != 1 {
+		t.Error("Expected reinstalling the breaker to replace it; got", len(c.RoundTripMiddlewares))
+		return
+	}
+
+	get := func() error {
+		_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/entities", Operation: "monitoring.ListEntities", ExpectedStatusCodes: []int{200}})
+		return err
+	}
+	circuit := "http://example.com/v1.0 monitoring.ListEntities"
+
+	get()
+	get()
+	if breaker.State(circuit) != CircuitOpen {
+		t.Error("Expected the circuit to open after two failures; got", breaker.State(circuit))
+		return
+	}
+
+	err := get()
+	if !errors.Is(err, ErrCircuitOpen) || transport.called != 2 {
+		t.Error("Expected an open circuit to fail fast; got", err, transport.called)
+		return
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if err := get(); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Error("Expected a failing probe to reach the server; got", err)
+		return
+	}
+	if breaker.State(circuit) != CircuitOpen {
+		t.Error("Expected a failed probe to reopen the circuit; got", breaker.State(circuit))
+		return
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if err := get(); err != nil {
+		t.Error(err)
+		return
+	}
+	if breaker.State(circuit) != CircuitClosed {
+		t.Error("Expected a successful probe to close the circuit; got", breaker.State(circuit))
+		return
+	}
+
+	expected := []string{"open", "half-open", "open", "half-open", "closed"}
+	if len(changes) != len(expected) {
+		t.Error("Expected state changes", expected, "; got", changes)
+		return
+	}
+	for i, to := range expected {
+		if changes[i][len(changes[i])-len(to):] != to {
+			t.Error("Expected state changes", expected, "; got", changes)
+			return
+		}
+	}
+}
+
+func TestCircuitBreakerSeparatesCircuits(t *testing.T) {
+	transport := &testTransport{status: 404, response: ITEM_NOT_FOUND_FAULT}
+	c := withTestClient(transport)
+	breaker := &CircuitBreaker{FailureThreshold: 1, CoolDown: time.Minute}
+	c.UseCircuitBreaker(breaker)
+
+	for i := 0; i < 3; i++ {
+		_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/x", ExpectedStatusCodes: []int{200}})
+		if !IsNotFound(err) {
+			t.Error("Expected client errors not to trip the breaker; got", err)
+			return
+		}
+	}
+
+	transport.status = 500
+	c.PerformRequest(&RestRequest{Method: "GET", Path: "/x", ExpectedStatusCodes: []int{200}})
+	if _, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/x"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Error("Expected the GET circuit to be open; got", err)
+		return
+	}
+	if _, err := c.PerformRequest(&RestRequest{Method: "DELETE", Path: "/x"}); errors.Is(err, ErrCircuitOpen) {
+		t.Error("Expected the DELETE circuit to be unaffected")
+		return
+	}
+
+	other := withTestClient(&testTransport{response: "{}"})
+	other.BaseUrl = "http://example.com/v2.0"
+	other.UseCircuitBreaker(breaker)
+	if _, err := other.PerformRequest(&RestRequest{Method: "GET", Path: "/x"}); err != nil {
+		t.Error("Expected another endpoint's circuit to be unaffected; got", err)
+		return
+	}
+}
+
+func TestCircuitBreakerIgnoresCancellation(t *testing.T) {
+	c := withTestClient(&testTransport{block: true})
+	breaker := &CircuitBreaker{FailureThreshold: 1, CoolDown: time.Minute}
+	c.UseCircuitBreaker(breaker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+	}()
+	c.PerformRequestWithContext(ctx, &RestRequest{Method: "GET", Path: "/"})
+	if breaker.State("http://example.com/v1.0 GET") != CircuitClosed {
+		t.Error("Expected a cancelled request not to count as a failure")
+		return
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	c.PerformRequestWithContext(ctx, &RestRequest{Method: "GET", Path: "/"})
+	if breaker.State("http://example.com/v1.0 GET") != CircuitOpen {
+		t.Error("Expected a timed-out request to count as a failure")
+		return
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+// CorrelationIdHeader names the request header through which a CorrelationMiddleware announces each request's correlation ID.
+const CorrelationIdHeader = "X-Request-Id"
+
+type correlationKey struct{}
+
+// WithCorrelationId() yields a context carrying a caller-supplied correlation ID.
+// Requests made under the returned context, through a client with a CorrelationMiddleware, carry that ID instead of a generated one.
+// This lets one ID follow a piece of work through several requests, or through several systems.
+func WithCorrelationId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationIdFrom() yields the correlation ID carried by ctx, or "" if there is none.
+func CorrelationIdFrom(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// NewCorrelationId() generates a random (version 4) UUID, suitable for use as a correlation ID.
+func NewCorrelationId() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// A CorrelationMiddleware stamps every request with a correlation ID, sent in the CorrelationIdHeader.
+//
+// A request which already carries the header keeps it.
+// Otherwise, the request takes the ID carried by its context, if any (see WithCorrelationId()), or else a freshly generated one.
+// Retried attempts at a request share its ID, since request middlewares run only once per request.
+//
+// Generate, if set, replaces NewCorrelationId() as the source of fresh IDs.
+type CorrelationMiddleware struct {
+	Generate func() string
+}
+
+// NewCorrelationMiddleware() creates a middleware which generates random UUIDs for requests which have no correlation ID of their own.
+func NewCorrelationMiddleware() *CorrelationMiddleware {
+	return &CorrelationMiddleware{}
+}
+
+// HandleRequest stamps the request with a correlation ID, generating one if the request has none.
+func (m *CorrelationMiddleware) HandleRequest(req *RestRequest) (*RestRequest, error) {
+	return m.HandleRequestWithContext(context.Background(), req)
+}
+
+// HandleRequestWithContext stamps the request with a correlation ID, preferring one carried by ctx to a generated one.
+func (m *CorrelationMiddleware) HandleRequestWithContext(ctx context.Context, req *RestRequest) (*RestRequest, error) {
+	if req.Header.Get(CorrelationIdHeader) != "" {
+		return req, nil
+	}
+
+	id := CorrelationIdFrom(ctx)
+	if id == "" && m.Generate != nil {
+		id = m.Generate()
+	}
+	if id == "" {
+		id = NewCorrelationId()
+	}
+
+	req.Header.Set(CorrelationIdHeader, id)
+	return req, nil
+}
+
+// ResponseMetadata describes the final response to a request, for callers who don't otherwise see it.
+// Many higher-level methods, such as those of the monitoring client, return only the decoded result,
+// yet support staff will ask for the RequestId when investigating a problem.
+//
+// StatusCode and Header are those of the response; both are zero if no response arrived.
+// RequestId holds the first of the RequestIdHeaders found in the response, and CorrelationId the ID the request was sent with, if any.
+type ResponseMetadata struct {
+	StatusCode    int
+	Header        http.Header
+	RequestId     string
+	CorrelationId string
+}
+
+type metadataKey struct{}
+
+// WithResponseMetadata() yields a context which captures response metadata into md.
+// Every request made through a RestClient under the returned context, whether it succeeds or fails, overwrites md
+// with the particulars of its final response.
+// Thus, after an operation which makes several requests, md describes the last of them.
+func WithResponseMetadata(ctx context.Context, md *ResponseMetadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// captureMetadata() fills in the ResponseMetadata requested by ctx, if any.
+func captureMetadata(ctx context.Context, restReq *RestRequest, resp *RestResponse, err error) {
+	md, ok := ctx.Value(metadataKey{}).(*ResponseMetadata)
+	if !ok {
+		return
+	}
+
+	*md = ResponseMetadata{CorrelationId: restReq.Header.Get(CorrelationIdHeader)}
+	if resp != nil {
+		md.StatusCode = resp.StatusCode
+		md.Header = resp.Header
+		md.RequestId = resp.RequestId()
+	} else if apiErr := AsAPIError(err); apiErr != nil {
+		md.StatusCode = apiErr.StatusCode
+		md.Header = apiErr.Header
+		md.RequestId = apiErr.RequestId
+	}
+}
+
+// RequestId() yields the first of the RequestIdHeaders found in the response, or "" if the server sent none.
+func (r *RestResponse) RequestId() string {
+	return requestIdFrom(r.Header)
+}
+
+// CorrelationId() yields the correlation ID the request was sent with, or "" if it had none; see CorrelationMiddleware.
+func (r *RestResponse) CorrelationId() string {
+	if r.Request == nil {
+		return ""
+	}
+	return r.Request.Header.Get(CorrelationIdHeader)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestCorrelationMiddleware(t *testing.T) {
+	transport := &testTransport{statuses: []int{503, 200}, response: "{}"}
+	c := withTestClient(transport)
+	c.SetRetryPolicy(testRetryPolicy())
+	c.RequestMiddlewares = append(c.RequestMiddlewares, NewCorrelationMiddleware())
+
+	resp, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/a"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	id := transport.requests[0].Header.Get(CorrelationIdHeader)
+	if !uuidPattern.MatchString(id) {
+		t.Error("Expected a generated UUID; got", id)
+		return
+	}
+	if transport.requests[1].Header.Get(CorrelationIdHeader) != id {
+		t.Error("Expected retries to share the correlation ID")
+		return
+	}
+	if resp.CorrelationId() != id {
+		t.Error("Expected the response to report", id, "; got", resp.CorrelationId())
+		return
+	}
+
+	_, err = c.PerformRequestWithContext(WithCorrelationId(context.Background(), "from-context"), &RestRequest{Method: "GET", Path: "/b"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got := transport.requests[2].Header.Get(CorrelationIdHeader); got != "from-context" {
+		t.Error("Expected the context's correlation ID; got", got)
+		return
+	}
+
+	_, err = c.PerformRequest(&RestRequest{Method: "GET", Path: "/c", Header: http.Header{CorrelationIdHeader: {"explicit"}}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got := transport.requests[3].Header.Get(CorrelationIdHeader); got != "explicit" {
+		t.Error("Expected the request's own correlation ID to be kept; got", got)
+		return
+	}
+}
+
+func TestResponseMetadataCapture(t *testing.T) {
+	transport := &testTransport{
+		header:   http.Header{"Content-Type": {"application/json"}, "X-Response-Id": {"resp-1"}},
+		response: "{}",
+	}
+	c := withTestClient(transport)
+	c.RequestMiddlewares = append(c.RequestMiddlewares, &CorrelationMiddleware{Generate: func() string { return "corr-1" }})
+
+	md := &ResponseMetadata{}
+	ctx := WithResponseMetadata(context.Background(), md)
+	resp, err := c.PerformRequestWithContext(ctx, &RestRequest{Method: "GET", Path: "/a"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.RequestId() != "resp-1" || md.RequestId != "resp-1" || md.CorrelationId != "corr-1" || md.StatusCode != 200 {
+		t.Error("Expected request and correlation IDs to be captured; got", resp.RequestId(), md)
+		return
+	}
+
+	transport.status = 404
+	transport.header = http.Header{"X-Compute-Request-Id": {"req-2"}}
+	transport.response = ITEM_NOT_FOUND_FAULT
+	_, err = c.PerformRequestWithContext(ctx, &RestRequest{Method: "GET", Path: "/b", ExpectedStatusCodes: []int{200}})
+	apiErr := AsAPIError(err)
+	if apiErr == nil {
+		t.Error("Expected an APIError; got", err)
+		return
+	}
+	if apiErr.RequestId != "req-2" || apiErr.CorrelationId != "corr-1" {
+		t.Error("Expected the error to carry both IDs; got", apiErr.RequestId, apiErr.CorrelationId)
+		return
+	}
+	if !strings.Contains(err.Error(), "[request req-2] [correlation corr-1]") {
+		t.Error("Expected the error message to quote both IDs; got", err.Error())
+		return
+	}
+	if md.StatusCode != 404 || md.RequestId != "req-2" {
+		t.Error("Expected metadata to describe the failed request; got", md)
+		return
+	}
+}
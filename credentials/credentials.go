@@ -0,0 +1,726 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package credentials finds the account a program should act as, from the same places OpenStack and Rackspace tools look,
+// so that gorax programs may share their configuration with Python tooling rather than each inventing its own flags.
+//
+// Credentials are taken whole from the first of these sources which supplies any, so that one source's user name is never paired with another's password:
+//
+//  1. command-line flags, if the program registered them with RegisterFlags() and the user gave any;
+//  2. the clouds.yaml cloud named by Resolver.Cloud, the -os-cloud flag, or $OS_CLOUD;
+//  3. environment variables: OS_USERNAME, OS_PASSWORD, OS_API_KEY, OS_AUTH_URL, OS_REGION_NAME and the like,
+//     or else their Rackspace equivalents RAX_USERNAME, RAX_PASSWORD, RAX_API_KEY, RAX_AUTH_URL and RAX_REGION;
+//  4. clouds.yaml, if it describes exactly one cloud;
+//  5. pyrax's configuration, ~/.pyrax.cfg, and its credential file, ~/.rackspace_cloud_credentials.
+//
+// A region given by the -r flag overrides that of whichever source applies.
+//
+// clouds.yaml is read from $OS_CLIENT_CONFIG_FILE, or else the first of ./clouds.yaml, ~/.config/openstack/clouds.yaml, and /etc/openstack/clouds.yaml to exist.
+// Its secrets may live apart, in a secure.yaml alongside it, or in $OS_CLIENT_SECURE_FILE; the two are merged, secure.yaml prevailing.
+//
+// If no source supplies credentials, or the one which does leaves something out, Resolve() returns a *MissingError saying what is missing and where it looked.
+package credentials
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/racker/gorax"
+	gidentity "github.com/racker/gorax/identity"
+	"github.com/racker/gorax/provider"
+	"github.com/racker/gorax/v2.0/identity"
+	"gopkg.in/yaml.v2"
+)
+
+// Names by which Credentials.Source identifies where credentials came from.
+const (
+	FlagsSource       = "command-line flags"
+	EnvironmentSource = "environment"
+)
+
+// ErrV3Unsupported is returned by KeystoneMiddleware() for Keystone v3 credentials, which the KeystoneAuthMiddleware cannot use.
+// Build an Identity() and pass it to identity.MakeKeystoneIdentityMiddleware() instead.
+var ErrV3Unsupported = errors.New("KeystoneAuthMiddleware supports Identity v2.0 only")
+
+// Credentials describe an account, how to authenticate as it, and where.
+// Fields which don't apply to the Identity API version in use are ignored; the project and domain fields, for instance, matter to Keystone v3 alone.
+type Credentials struct {
+	// AuthURL locates the identity service's API, e.g., "https://identity.api.rackspacecloud.com/v2.0".
+	// If "", Rackspace's Identity service applies: its London endpoint for the LON region, and its US endpoint otherwise.
+	AuthURL string
+
+	// IdentityAPIVersion is "2.0" or "3".
+	// If "", the version is inferred from AuthURL, which for Keystone v3 ends in "/v3".
+	IdentityAPIVersion string
+
+	Username, UserId, UserDomainName, UserDomainId                                  string
+	Password, APIKey                                                                string
+	ApplicationCredentialId, ApplicationCredentialName, ApplicationCredentialSecret string
+	ProjectName, ProjectId, ProjectDomainName, ProjectDomainId                      string
+	DomainName, DomainId                                                            string
+
+	// Region names the account's home region, in which clients operate unless told otherwise.
+	Region string
+
+	// Interface is the catalog interface through which services are reached: identity.PublicInterface, InternalInterface, or AdminInterface.
+	// If "", the public interface applies.
+	Interface string
+
+	// Source describes where the credentials came from, e.g., "environment", or "cloud \"prod\" of /home/me/.config/openstack/clouds.yaml".
+	Source string
+}
+
+// MissingError reports that credentials are incomplete, or that none were found at all.
+type MissingError struct {
+	// Source is the source of the incomplete credentials, or "" if none was found.
+	Source string
+
+	// Missing lists what the credentials lack, e.g., "username" or "password, API key or application credential secret".
+	Missing []string
+
+	// Searched lists the sources consulted, in order of precedence.
+	Searched []string
+}
+
+func (e *MissingError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("credentials: none found; looked in %s", strings.Join(e.Searched, ", "))
+	}
+	return fmt.Sprintf("credentials from %s lack %s", e.Source, strings.Join(e.Missing, "; "))
+}
+
+// Flags hold the credentials given on the command line; see RegisterFlags().
+type Flags struct {
+	Username, Password, APIKey, Region, AuthURL, Cloud string
+}
+
+// RegisterFlags defines the flags by which users may give their credentials on the command line:
+// -u for the username, -p for the password, -api-key, -r for the region, -auth-url, and -os-cloud, to name a cloud in clouds.yaml.
+// Pass the result to Resolver.Flags, after parsing the command line.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Username, "u", "", "account username")
+	fs.StringVar(&f.Password, "p", "", "account password")
+	fs.StringVar(&f.APIKey, "api-key", "", "account API key")
+	fs.StringVar(&f.Region, "r", "", "region, overriding the configured region")
+	fs.StringVar(&f.AuthURL, "auth-url", "", "identity service URL")
+	fs.StringVar(&f.Cloud, "os-cloud", "", "name of a cloud in clouds.yaml")
+	return f
+}
+
+// A Resolver finds credentials; see the package documentation for where it looks.
+// The zero value looks in all the customary places.
+type Resolver struct {
+	// Flags, if not nil, holds credentials given on the command line.
+	Flags *Flags
+
+	// Cloud names the cloud in clouds.yaml to use, overriding $OS_CLOUD.
+	Cloud string
+
+	// CloudsFile and SecureFile locate clouds.yaml and secure.yaml, overriding the usual search.
+	CloudsFile, SecureFile string
+
+	// PyraxFile and PyraxCredentialsFile locate pyrax's configuration and credential files,
+	// if not ~/.pyrax.cfg and ~/.rackspace_cloud_credentials respectively.
+	PyraxFile, PyraxCredentialsFile string
+
+	// PyraxEnvironment names the section of the pyrax configuration to use, if not "default".
+	PyraxEnvironment string
+
+	// Getenv, if not nil, replaces os.Getenv.
+	Getenv func(string) string
+}
+
+// Resolve finds credentials with a zero Resolver.
+func Resolve() (*Credentials, error) {
+	return (&Resolver{}).Resolve()
+}
+
+// Resolve finds credentials in the first source to supply any, in the order of precedence the package documentation gives.
+// It returns a *MissingError if the credentials are incomplete, or absent altogether.
+// Other errors report unreadable or malformed configuration files.
+func (r *Resolver) Resolve() (*Credentials, error) {
+	var searched []string
+	c, err := r.resolve(&searched)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, &MissingError{Searched: searched}
+	}
+
+	if r.Flags != nil && r.Flags.Region != "" {
+		c.Region = r.Flags.Region
+	}
+	if missing := c.missing(); len(missing) > 0 {
+		return nil, &MissingError{Source: c.Source, Missing: missing, Searched: searched}
+	}
+	return c, nil
+}
+
+func (r *Resolver) resolve(searched *[]string) (*Credentials, error) {
+	if r.Flags != nil {
+		*searched = append(*searched, FlagsSource)
+		if c := r.fromFlags(); c.present() {
+			return c, nil
+		}
+	}
+
+	// clouds.yaml is read only once it's needed, so that a broken one can't spoil credentials given in the environment.
+	if name := r.cloudName(); name != "" {
+		clouds, err := r.loadClouds()
+		if err != nil {
+			return nil, err
+		}
+		if clouds == nil {
+			return nil, fmt.Errorf("credentials: cloud %q requested, but no clouds.yaml found", name)
+		}
+		return clouds.credentials(name)
+	}
+
+	*searched = append(*searched, EnvironmentSource+" (OS_* and RAX_* variables)")
+	if c := r.fromEnvironment(); c.present() {
+		return c, nil
+	}
+
+	clouds, err := r.loadClouds()
+	if err != nil {
+		return nil, err
+	}
+	if clouds != nil {
+		names := clouds.names()
+		if len(names) == 1 {
+			return clouds.credentials(names[0])
+		}
+		if len(names) > 1 {
+			*searched = append(*searched, fmt.Sprintf("%s (set OS_CLOUD to one of: %s)", clouds.path, strings.Join(names, ", ")))
+		} else {
+			*searched = append(*searched, clouds.path)
+		}
+	}
+
+	pyraxFile, credsFile := r.pyraxFiles()
+	*searched = append(*searched, pyraxFile, credsFile)
+	c, err := r.fromPyrax(pyraxFile, credsFile)
+	if err != nil || !c.present() {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *Resolver) getenv(key string) string {
+	if r.Getenv != nil {
+		return r.Getenv(key)
+	}
+	return os.Getenv(key)
+}
+
+// firstEnv yields the value of the first of the given environment variables to be set.
+func (r *Resolver) firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if v := r.getenv(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (r *Resolver) home() string {
+	if h := r.getenv("HOME"); h != "" {
+		return h
+	}
+	h, _ := os.UserHomeDir()
+	return h
+}
+
+func (r *Resolver) cloudName() string {
+	if r.Flags != nil && r.Flags.Cloud != "" {
+		return r.Flags.Cloud
+	}
+	if r.Cloud != "" {
+		return r.Cloud
+	}
+	return r.getenv("OS_CLOUD")
+}
+
+func (r *Resolver) fromFlags() *Credentials {
+	return &Credentials{
+		Source:   FlagsSource,
+		Username: r.Flags.Username,
+		Password: r.Flags.Password,
+		APIKey:   r.Flags.APIKey,
+		AuthURL:  r.Flags.AuthURL,
+		Region:   r.Flags.Region,
+	}
+}
+
+func (r *Resolver) fromEnvironment() *Credentials {
+	return &Credentials{
+		Source:                      EnvironmentSource,
+		AuthURL:                     r.firstEnv("OS_AUTH_URL", "RAX_AUTH_URL"),
+		IdentityAPIVersion:          r.getenv("OS_IDENTITY_API_VERSION"),
+		Username:                    r.firstEnv("OS_USERNAME", "RAX_USERNAME"),
+		UserId:                      r.getenv("OS_USER_ID"),
+		UserDomainName:              r.getenv("OS_USER_DOMAIN_NAME"),
+		UserDomainId:                r.getenv("OS_USER_DOMAIN_ID"),
+		Password:                    r.firstEnv("OS_PASSWORD", "RAX_PASSWORD"),
+		APIKey:                      r.firstEnv("OS_API_KEY", "RAX_API_KEY"),
+		ApplicationCredentialId:     r.getenv("OS_APPLICATION_CREDENTIAL_ID"),
+		ApplicationCredentialName:   r.getenv("OS_APPLICATION_CREDENTIAL_NAME"),
+		ApplicationCredentialSecret: r.getenv("OS_APPLICATION_CREDENTIAL_SECRET"),
+		ProjectName:                 r.firstEnv("OS_PROJECT_NAME", "OS_TENANT_NAME"),
+		ProjectId:                   r.firstEnv("OS_PROJECT_ID", "OS_TENANT_ID"),
+		ProjectDomainName:           r.getenv("OS_PROJECT_DOMAIN_NAME"),
+		ProjectDomainId:             r.getenv("OS_PROJECT_DOMAIN_ID"),
+		DomainName:                  r.getenv("OS_DOMAIN_NAME"),
+		DomainId:                    r.getenv("OS_DOMAIN_ID"),
+		Region:                      r.firstEnv("OS_REGION_NAME", "RAX_REGION"),
+		Interface:                   r.getenv("OS_INTERFACE"),
+	}
+}
+
+// cloudsFile holds a parsed clouds.yaml, merged with its secure.yaml.
+type cloudsFile struct {
+	path   string
+	clouds map[string]interface{}
+}
+
+// loadClouds reads clouds.yaml and secure.yaml, yielding nil if there is no clouds.yaml.
+func (r *Resolver) loadClouds() (*cloudsFile, error) {
+	dirs := []string{".", filepath.Join(r.home(), ".config", "openstack"), "/etc/openstack"}
+	if xdg := r.getenv("XDG_CONFIG_HOME"); xdg != "" {
+		dirs[1] = filepath.Join(xdg, "openstack")
+	}
+
+	path := r.CloudsFile
+	if path == "" {
+		path = r.getenv("OS_CLIENT_CONFIG_FILE")
+	}
+	if path == "" {
+		path = findFile(dirs, "clouds.yaml", "clouds.yml")
+	}
+	doc, err := readYAML(path)
+	if doc == nil || err != nil {
+		return nil, err
+	}
+
+	securePath := r.SecureFile
+	if securePath == "" {
+		securePath = r.getenv("OS_CLIENT_SECURE_FILE")
+	}
+	if securePath == "" {
+		securePath = findFile(dirs, "secure.yaml", "secure.yml")
+	}
+	secure, err := readYAML(securePath)
+	if err != nil {
+		return nil, err
+	}
+	if secure != nil {
+		doc = merge(doc, secure)
+	}
+
+	clouds, _ := doc["clouds"].(map[string]interface{})
+	return &cloudsFile{path, clouds}, nil
+}
+
+// findFile yields the path of the first of the given files to exist, in the first directory to hold any, or "" if none does.
+func findFile(dirs []string, names ...string) string {
+	for _, dir := range dirs {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// readYAML parses the given YAML file, yielding nil if path is "".
+func readYAML(path string) (map[string]interface{}, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("credentials: %v", err)
+	}
+	doc, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("credentials: %s: %v", path, err)
+	}
+	return doc, nil
+}
+
+// parseYAML decodes a YAML document whose top level is a mapping.
+// Mappings are yielded as map[string]interface{}, sequences as []interface{}, and every scalar as a string, null being "".
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return map[string]interface{}{}, nil
+	}
+	m, ok := normalizeYAML(doc).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("document is not a mapping")
+	}
+	return m, nil
+}
+
+// normalizeYAML converts a value decoded by the yaml package into the forms parseYAML promises.
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			s[i] = normalizeYAML(value)
+		}
+		return s
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// merge overlays one YAML mapping upon another, recursively, yielding a new mapping.
+func merge(base, overlay map[string]interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	for k, v := range base {
+		m[k] = v
+	}
+	for k, v := range overlay {
+		baseMap, ok1 := m[k].(map[string]interface{})
+		overlayMap, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			m[k] = merge(baseMap, overlayMap)
+		} else {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func (f *cloudsFile) names() []string {
+	var names []string
+	for name := range f.clouds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// credentials yields the credentials of the named cloud.
+func (f *cloudsFile) credentials(name string) (*Credentials, error) {
+	cloud, ok := f.clouds[name].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("credentials: no cloud %q in %s; it has %s", name, f.path, strings.Join(f.names(), ", "))
+	}
+	auth, _ := cloud["auth"].(map[string]interface{})
+	get := func(m map[string]interface{}, keys ...string) string {
+		for _, key := range keys {
+			if s, ok := m[key].(string); ok && s != "" {
+				return s
+			}
+		}
+		return ""
+	}
+
+	c := &Credentials{
+		Source:                      fmt.Sprintf("cloud %q of %s", name, f.path),
+		AuthURL:                     get(auth, "auth_url"),
+		IdentityAPIVersion:          get(cloud, "identity_api_version"),
+		Username:                    get(auth, "username"),
+		UserId:                      get(auth, "user_id"),
+		UserDomainName:              get(auth, "user_domain_name"),
+		UserDomainId:                get(auth, "user_domain_id"),
+		Password:                    get(auth, "password"),
+		APIKey:                      get(auth, "api_key"),
+		ApplicationCredentialId:     get(auth, "application_credential_id"),
+		ApplicationCredentialName:   get(auth, "application_credential_name"),
+		ApplicationCredentialSecret: get(auth, "application_credential_secret"),
+		ProjectName:                 get(auth, "project_name", "tenant_name"),
+		ProjectId:                   get(auth, "project_id", "tenant_id"),
+		ProjectDomainName:           get(auth, "project_domain_name"),
+		ProjectDomainId:             get(auth, "project_domain_id"),
+		DomainName:                  get(auth, "domain_name"),
+		DomainId:                    get(auth, "domain_id"),
+		Region:                      get(cloud, "region_name"),
+		Interface:                   get(cloud, "interface"),
+	}
+	if c.Region == "" {
+		// A cloud may list its regions instead, the first being its default.
+		if regions, ok := cloud["regions"].([]interface{}); ok && len(regions) > 0 {
+			switch region := regions[0].(type) {
+			case string:
+				c.Region = region
+			case map[string]interface{}:
+				c.Region = get(region, "name")
+			}
+		}
+	}
+	if c.IdentityAPIVersion == "" && strings.HasPrefix(get(cloud, "auth_type"), "v3") {
+		c.IdentityAPIVersion = "3"
+	}
+	return c, nil
+}
+
+func (r *Resolver) pyraxFiles() (string, string) {
+	pyraxFile, credsFile := r.PyraxFile, r.PyraxCredentialsFile
+	if pyraxFile == "" {
+		pyraxFile = filepath.Join(r.home(), ".pyrax.cfg")
+	}
+	if credsFile == "" {
+		credsFile = filepath.Join(r.home(), ".rackspace_cloud_credentials")
+	}
+	return pyraxFile, credsFile
+}
+
+// fromPyrax reads the given pyrax configuration and credential files, either of which may be absent.
+// The configuration's environment section, or its legacy "settings" section, supplies the identity type, region and endpoint;
+// its "keyring_username", or else the credential file's "rackspace_cloud" section, the username, and the latter, the API key or password.
+func (r *Resolver) fromPyrax(pyraxFile, credsFile string) (*Credentials, error) {
+	cfg, err := readINI(pyraxFile)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := readINI(credsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	env := r.PyraxEnvironment
+	if env == "" {
+		env = "default"
+	}
+	settings := cfg[env]
+	if settings == nil {
+		settings = cfg["settings"]
+	}
+	if settings == nil {
+		settings = map[string]string{}
+	}
+	account := creds["rackspace_cloud"]
+	if account == nil {
+		account = map[string]string{}
+	}
+
+	c := &Credentials{
+		Source:      fmt.Sprintf("%s [%s]", pyraxFile, env),
+		AuthURL:     settings["auth_endpoint"],
+		Username:    settings["keyring_username"],
+		Region:      settings["region"],
+		ProjectName: settings["tenant_name"],
+		ProjectId:   settings["tenant_id"],
+	}
+	if account["username"] != "" {
+		c.Source = credsFile
+		c.Username = account["username"]
+		c.APIKey = account["api_key"]
+		c.Password = account["password"]
+	}
+	if settings["identity_type"] == "keystone" && c.IdentityAPIVersion == "" && strings.HasSuffix(strings.TrimSuffix(c.AuthURL, "/"), "/v3") {
+		c.IdentityAPIVersion = "3"
+	}
+	switch strings.ToLower(settings["use_servicenet"]) {
+	case "true", "yes", "on", "1":
+		c.Interface = identity.InternalInterface
+	}
+	return c, nil
+}
+
+// readINI parses the given INI file, yielding no sections if it doesn't exist.
+func readINI(path string) (map[string]map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("credentials: %v", err)
+	}
+	sections, err := parseINI(data)
+	if err != nil {
+		return nil, fmt.Errorf("credentials: %s: %v", path, err)
+	}
+	return sections, nil
+}
+
+// present reports whether the credentials identify a user, or hold a secret; if neither, their source supplied none.
+func (c *Credentials) present() bool {
+	return c.Username != "" || c.UserId != "" || c.Password != "" || c.APIKey != "" ||
+		c.ApplicationCredentialId != "" || c.ApplicationCredentialSecret != ""
+}
+
+// IsV3 reports whether the credentials are for Keystone v3, by IdentityAPIVersion, or failing that, AuthURL.
+func (c *Credentials) IsV3() bool {
+	if c.IdentityAPIVersion != "" {
+		return strings.HasPrefix(strings.TrimPrefix(c.IdentityAPIVersion, "v"), "3")
+	}
+	return strings.HasSuffix(strings.TrimSuffix(c.AuthURL, "/"), "/v3")
+}
+
+// missing lists what the credentials lack.
+func (c *Credentials) missing() []string {
+	var missing []string
+	if !c.IsV3() {
+		if c.Username == "" {
+			missing = append(missing, "username")
+		}
+		if c.Password == "" && c.APIKey == "" {
+			missing = append(missing, "password or API key")
+		}
+		return missing
+	}
+
+	if c.AuthURL == "" {
+		missing = append(missing, "auth URL")
+	}
+	if c.ApplicationCredentialSecret != "" {
+		if c.ApplicationCredentialId == "" && c.ApplicationCredentialName == "" {
+			missing = append(missing, "application credential ID or name")
+		}
+		if c.ApplicationCredentialId == "" && c.UserId == "" && c.Username == "" {
+			missing = append(missing, "username or user ID")
+		}
+		return missing
+	}
+
+	if c.UserId == "" && c.Username == "" {
+		missing = append(missing, "username or user ID")
+	}
+	if c.UserId == "" && c.UserDomainName == "" && c.UserDomainId == "" {
+		missing = append(missing, "user domain name or ID")
+	}
+	if c.Password == "" {
+		missing = append(missing, "password or application credential secret")
+	}
+	if c.ProjectName != "" && c.ProjectId == "" && c.ProjectDomainName == "" && c.ProjectDomainId == "" {
+		missing = append(missing, "project domain name or ID")
+	}
+	return missing
+}
+
+// authURL yields the URL of the identity service the credentials authenticate against.
+func (c *Credentials) authURL() string {
+	if c.AuthURL != "" {
+		return strings.TrimSuffix(c.AuthURL, "/")
+	}
+	if strings.EqualFold(c.Region, "LON") {
+		return gidentity.UKIdentityService
+	}
+	return gidentity.USIdentityService
+}
+
+// versionSegment matches the final segment of a versioned identity service URL, e.g., "v3" or "v2.0".
+var versionSegment = regexp.MustCompile(`^v[0-9]+(\.[0-9]+)?$`)
+
+// v3AuthURL yields the URL of the Keystone v3 API the credentials authenticate against.
+// Clouds often give the service's unversioned root, e.g., "https://keystone.example.com:5000", to which "/v3" is appended.
+func (c *Credentials) v3AuthURL() string {
+	url := strings.TrimSuffix(c.AuthURL, "/")
+	if !versionSegment.MatchString(url[strings.LastIndex(url, "/")+1:]) {
+		url += "/v3"
+	}
+	return url
+}
+
+// Identity builds an identity from the credentials, for use with the v2.0 packages, or with provider.New().
+// Keystone v3 credentials yield a Keystone v3 identity; others, an Identity v2.0 identity, authenticating with the API key in preference to the password.
+// A Keystone v3 auth URL naming no API version, such as "https://keystone.example.com:5000", is taken to be the service's root, beneath which "/v3" lies.
+// Any options given configure how the identity reaches the identity service; see gorax.ClientOption.
+func (c *Credentials) Identity(opts ...gorax.ClientOption) (identity.Identity, error) {
+	if missing := c.missing(); len(missing) > 0 {
+		return nil, &MissingError{Source: c.Source, Missing: missing}
+	}
+
+	if c.IsV3() {
+		id := identity.NewV3Identity(c.v3AuthURL(), identity.V3Credentials{
+			UserId:                      c.UserId,
+			Username:                    c.Username,
+			UserDomainId:                c.UserDomainId,
+			UserDomainName:              c.UserDomainName,
+			Password:                    c.Password,
+			ApplicationCredentialId:     c.ApplicationCredentialId,
+			ApplicationCredentialName:   c.ApplicationCredentialName,
+			ApplicationCredentialSecret: c.ApplicationCredentialSecret,
+			ProjectId:                   c.ProjectId,
+			ProjectName:                 c.ProjectName,
+			ProjectDomainId:             c.ProjectDomainId,
+			ProjectDomainName:           c.ProjectDomainName,
+			DomainId:                    c.DomainId,
+			DomainName:                  c.DomainName,
+		}, opts...)
+		id.SetRegion(c.Region)
+		return id, nil
+	}
+
+	id := identity.NewIdentity(c.Username, c.Password, c.Region)
+	if c.APIKey != "" {
+		id = identity.NewIdentityWithAPIKey(c.Username, c.APIKey, c.Region)
+	}
+	if c.AuthURL != "" {
+		id.SetAuthEndpoint(c.authURL())
+	}
+	if len(opts) > 0 {
+		id.UseClient(gorax.NewHTTPClient(opts...))
+	}
+	return id, nil
+}
+
+// KeystoneMiddleware builds a KeystoneAuthMiddleware from the credentials, for use with the monitoring package.
+// It authenticates with the API key in preference to the password.
+// Keystone v3 credentials yield ErrV3Unsupported.
+// Any options given configure how the middleware reaches Keystone; see gorax.ClientOption.
+func (c *Credentials) KeystoneMiddleware(opts ...gorax.ClientOption) (*gidentity.KeystoneAuthMiddleware, error) {
+	if c.IsV3() {
+		return nil, ErrV3Unsupported
+	}
+	if missing := c.missing(); len(missing) > 0 {
+		return nil, &MissingError{Source: c.Source, Missing: missing}
+	}
+	if c.APIKey != "" {
+		return gidentity.MakeKeystoneAPIKeyMiddleware(c.authURL(), c.Username, c.APIKey, opts...), nil
+	}
+	return gidentity.MakeKeystonePasswordMiddleware(c.authURL(), c.Username, c.Password, opts...), nil
+}
+
+// Provider builds a provider acting as the credentials' identity, reaching services through the credentials' interface.
+// Any options given configure how the provider reaches the network; see provider.New().
+func (c *Credentials) Provider(opts ...gorax.ClientOption) (*provider.Provider, error) {
+	id, err := c.Identity()
+	if err != nil {
+		return nil, err
+	}
+	p := provider.New(id, opts...)
+	p.UseServiceNet(c.Interface == identity.InternalInterface)
+	return p, nil
+}
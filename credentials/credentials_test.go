@@ -0,0 +1,392 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentials
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/racker/gorax/v2.0/identity"
+)
+
+const cloudsYAML = `
+# Shared with the Python tools.
+clouds:
+  prod:
+    auth:
+      auth_url: "https://keystone.example.com:5000/v3"
+      username: ann
+      user_domain_name: Default
+      project_name: 'ann''s project'
+      project_domain_name: Default
+    region_name: RegionOne
+    identity_api_version: 3
+    interface: internal
+  rackspace:
+    auth:
+      username: joe
+    regions:
+      - name: LON
+        values: {}
+      - DFW
+`
+
+const secureYAML = `
+clouds:
+  prod:
+    auth:
+      password: s3cret # not the real one
+  rackspace:
+    auth:
+      api_key: abc#123
+`
+
+// makeEnv yields a Getenv function which sees only the given variables.
+func makeEnv(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+// writeFiles writes the given files into a new temporary directory, yielding its path.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "gorax-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParseYAML(t *testing.T) {
+	doc, err := parseYAML([]byte(cloudsYAML))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := map[string]interface{}{
+		"clouds": map[string]interface{}{
+			"prod": map[string]interface{}{
+				"auth": map[string]interface{}{
+					"auth_url":            "https://keystone.example.com:5000/v3",
+					"username":            "ann",
+					"user_domain_name":    "Default",
+					"project_name":        "ann's project",
+					"project_domain_name": "Default",
+				},
+				"region_name":          "RegionOne",
+				"identity_api_version": "3",
+				"interface":            "internal",
+			},
+			"rackspace": map[string]interface{}{
+				"auth": map[string]interface{}{"username": "joe"},
+				"regions": []interface{}{
+					map[string]interface{}{"name": "LON", "values": map[string]interface{}{}},
+					"DFW",
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("Unexpected document: %#v", doc)
+		return
+	}
+
+	for _, bad := range []string{"a:\n  b: c\n d: e", "\ta: b", "just a string", "a: [b"} {
+		if _, err := parseYAML([]byte(bad)); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+			return
+		}
+	}
+}
+
+func TestParseYAMLQuotedScalars(t *testing.T) {
+	for _, c := range []struct {
+		line  string
+		key   string
+		value string
+	}{
+		{`password: "a #b"`, "password", "a #b"},
+		{`password: "a #b" # comment`, "password", "a #b"},
+		{`password: 'a #b' # comment`, "password", "a #b"},
+		{`password: a#b # comment`, "password", "a#b"},
+		{`password: "a\" #x"`, "password", `a" #x`},
+		{`password: "a\\" # comment`, "password", `a\`},
+		{`password: 'it''s # here'`, "password", "it's # here"},
+		{`password: "a: b"`, "password", "a: b"},
+		{`url: https://keystone.example.com:5000/v3`, "url", "https://keystone.example.com:5000/v3"},
+		{`"key: with colon": value`, "key: with colon", "value"},
+		{`"key \": with colon": value`, `key ": with colon`, "value"},
+		{`'key: with colon': value # comment`, "key: with colon", "value"},
+		{`password: "caf\u00e9 \x41"`, "password", "café A"},
+		{`identity_api_version: 3`, "identity_api_version", "3"},
+		{`region_name: ~`, "region_name", ""},
+		{"password: |\n  multi\n  line\n", "password", "multi\nline\n"},
+	} {
+		doc, err := parseYAML([]byte(c.line))
+		if err != nil {
+			t.Errorf("%s: %v", c.line, err)
+			return
+		}
+		if value, ok := doc[c.key]; !ok || value != c.value {
+			t.Errorf("%s: expected %q: %q; got %#v", c.line, c.key, c.value, doc)
+			return
+		}
+	}
+
+	if _, err := parseYAML([]byte(`password: "a\"`)); err == nil {
+		t.Error("Expected a string whose closing quote is escaped to be rejected")
+		return
+	}
+
+	doc, err := parseYAML([]byte(`regions: ["a,b", c]`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(doc["regions"], []interface{}{"a,b", "c"}) {
+		t.Errorf("Expected a flow sequence with a quoted comma; got %#v", doc)
+		return
+	}
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"clouds.yaml":  cloudsYAML,
+		"secure.yaml":  secureYAML,
+		".pyrax.cfg":   "[default]\nidentity_type = rackspace\nregion = ORD\nuse_servicenet = True\n",
+		"rackspace.id": "[rackspace_cloud]\nusername = pyrax-user\napi_key = pyrax-key\n",
+	})
+	defer os.RemoveAll(dir)
+
+	vars := map[string]string{
+		"HOME":                  dir,
+		"OS_CLIENT_CONFIG_FILE": filepath.Join(dir, "clouds.yaml"),
+		"OS_CLIENT_SECURE_FILE": filepath.Join(dir, "secure.yaml"),
+		"RAX_USERNAME":          "env-user",
+		"RAX_API_KEY":           "env-key",
+		"OS_REGION_NAME":        "iad",
+	}
+	r := &Resolver{Getenv: makeEnv(vars), PyraxCredentialsFile: filepath.Join(dir, "rackspace.id")}
+
+	// With clouds.yaml holding two clouds, the environment applies, OS_* and RAX_* variables combining.
+	c, err := r.Resolve()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if c.Source != EnvironmentSource || c.Username != "env-user" || c.APIKey != "env-key" || c.Region != "iad" {
+		t.Errorf("Expected the environment's credentials; got %+v", c)
+		return
+	}
+
+	// A named cloud prevails over the environment, and gains its secrets from secure.yaml.
+	vars["OS_CLOUD"] = "prod"
+	c, err = r.Resolve()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(c.Source, `"prod"`) || c.Username != "ann" || c.Password != "s3cret" || c.ProjectName != "ann's project" || !c.IsV3() || c.Interface != identity.InternalInterface {
+		t.Errorf("Expected the prod cloud's credentials; got %+v", c)
+		return
+	}
+
+	// Flags prevail over everything, and their region over every source's.
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	r.Flags = RegisterFlags(fs)
+	if err := fs.Parse([]string{"-r", "syd"}); err != nil {
+		t.Error(err)
+		return
+	}
+	c, err = r.Resolve()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if c.Username != "ann" || c.Region != "syd" {
+		t.Errorf("Expected the prod cloud's credentials in the flag's region; got %+v", c)
+		return
+	}
+	if err := fs.Parse([]string{"-u", "flag-user", "-p", "flag-pass"}); err != nil {
+		t.Error(err)
+		return
+	}
+	c, err = r.Resolve()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if c.Source != FlagsSource || c.Username != "flag-user" || c.Password != "flag-pass" || c.APIKey != "" || c.Region != "syd" {
+		t.Errorf("Expected the flags' credentials; got %+v", c)
+		return
+	}
+	r.Flags = nil
+
+	// A cloud named by the resolver may be any in clouds.yaml.
+	r.Cloud = "rackspace"
+	c, err = r.Resolve()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if c.Username != "joe" || c.APIKey != "abc#123" || c.Region != "LON" || c.IsV3() {
+		t.Errorf("Expected the rackspace cloud's credentials; got %+v", c)
+		return
+	}
+	r.Cloud = "nowhere"
+	if _, err = r.Resolve(); err == nil || !strings.Contains(err.Error(), "prod, rackspace") {
+		t.Error("Expected an error listing the clouds; got", err)
+		return
+	}
+	r.Cloud = ""
+
+	// Without the environment, pyrax's configuration applies.
+	delete(vars, "OS_CLOUD")
+	delete(vars, "RAX_USERNAME")
+	delete(vars, "RAX_API_KEY")
+	c, err = r.Resolve()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if c.Username != "pyrax-user" || c.APIKey != "pyrax-key" || c.Region != "ORD" || c.Interface != identity.InternalInterface {
+		t.Errorf("Expected pyrax's credentials; got %+v", c)
+		return
+	}
+}
+
+func TestResolveIgnoresBrokenCloudsWhenEnvironmentSuffices(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"clouds.yaml": "clouds: [unterminated\n"})
+	defer os.RemoveAll(dir)
+
+	vars := map[string]string{
+		"HOME":                  dir,
+		"OS_CLIENT_CONFIG_FILE": filepath.Join(dir, "clouds.yaml"),
+		"RAX_USERNAME":          "env-user",
+		"RAX_API_KEY":           "env-key",
+	}
+	r := &Resolver{Getenv: makeEnv(vars)}
+	c, err := r.Resolve()
+	if err != nil {
+		t.Error("Expected the environment's credentials despite a malformed clouds.yaml; got", err)
+		return
+	}
+	if c.Source != EnvironmentSource {
+		t.Errorf("Expected the environment's credentials; got %+v", c)
+		return
+	}
+
+	vars["OS_CLOUD"] = "prod"
+	if _, err := r.Resolve(); err == nil {
+		t.Error("Expected a malformed clouds.yaml to be reported when a cloud is named")
+		return
+	}
+}
+
+func TestResolveReportsWhatIsMissing(t *testing.T) {
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+
+	r := &Resolver{Getenv: makeEnv(map[string]string{"HOME": dir, "OS_CLIENT_CONFIG_FILE": ""})}
+	_, err := r.Resolve()
+	missing, ok := err.(*MissingError)
+	if !ok || missing.Source != "" || !strings.Contains(err.Error(), ".pyrax.cfg") || !strings.Contains(err.Error(), "OS_* and RAX_*") {
+		t.Error("Expected a MissingError listing where credentials were sought; got", err)
+		return
+	}
+
+	r.Getenv = makeEnv(map[string]string{"HOME": dir, "OS_USERNAME": "ann", "OS_AUTH_URL": "https://keystone.example.com/v3"})
+	_, err = r.Resolve()
+	missing, ok = err.(*MissingError)
+	if !ok || missing.Source != EnvironmentSource || !reflect.DeepEqual(missing.Missing, []string{"user domain name or ID", "password or application credential secret"}) {
+		t.Error("Expected a MissingError listing what the environment lacks; got", err)
+		return
+	}
+}
+
+func TestCredentialsBuildIdentities(t *testing.T) {
+	c := &Credentials{Username: "joe", APIKey: "key", Region: "lon"}
+	id, err := c.Identity()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if id.APIKey() != "key" || id.Region() != "LON" || id.AuthEndpoint() != identity.UK_ENDPOINT {
+		t.Error("Expected an API key identity for London; got", id.APIKey(), id.Region(), id.AuthEndpoint())
+		return
+	}
+	m, err := c.KeystoneMiddleware()
+	if err != nil || m == nil {
+		t.Error("Expected a Keystone middleware; got", err)
+		return
+	}
+
+	c = &Credentials{Username: "joe", Password: "pw", AuthURL: "https://keystone.example.com/v2.0/"}
+	id, err = c.Identity()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if id.AuthEndpoint() != "https://keystone.example.com/v2.0/tokens" {
+		t.Error("Expected the given auth URL to be used; got", id.AuthEndpoint())
+		return
+	}
+
+	c = &Credentials{UserId: "u1", Password: "pw", AuthURL: "https://keystone.example.com/v3", Region: "RegionOne"}
+	id, err = c.Identity()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if id.AuthEndpoint() != "https://keystone.example.com/v3/auth/tokens" || id.Region() != "REGIONONE" {
+		t.Error("Expected a Keystone v3 identity; got", id.AuthEndpoint(), id.Region())
+		return
+	}
+	if _, err := c.KeystoneMiddleware(); err != ErrV3Unsupported {
+		t.Error("Expected ErrV3Unsupported; got", err)
+		return
+	}
+
+	for authURL, endpoint := range map[string]string{
+		"https://keystone.example.com:5000":      "https://keystone.example.com:5000/v3/auth/tokens",
+		"https://keystone.example.com:5000/":     "https://keystone.example.com:5000/v3/auth/tokens",
+		"https://cloud.example.com/identity":     "https://cloud.example.com/identity/v3/auth/tokens",
+		"https://cloud.example.com/identity/v3/": "https://cloud.example.com/identity/v3/auth/tokens",
+		"https://keystone.example.com:5000/v3.0": "https://keystone.example.com:5000/v3.0/auth/tokens",
+	} {
+		c = &Credentials{UserId: "u1", Password: "pw", AuthURL: authURL, IdentityAPIVersion: "3"}
+		id, err = c.Identity()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if id.AuthEndpoint() != endpoint {
+			t.Error("Expected", authURL, "to authenticate at", endpoint, "; got", id.AuthEndpoint())
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentials
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseINI decodes an INI file, such as pyrax's configuration, into sections of keys and values.
+// Keys are separated from values by "=" or ":", and lines beginning with "#" or ";" are comments.
+// Section names are case-sensitive, as in Python's ConfigParser; key names are folded to lower case, as ConfigParser folds them.
+// Keys preceding any section header belong to the section named "".
+func parseINI(data []byte) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{"": {}}
+	section := ""
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: malformed section header", n+1)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if sections[section] == nil {
+				sections[section] = map[string]string{}
+			}
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected \"key = value\"", n+1)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		sections[section][key] = strings.TrimSpace(line[i+1:])
+	}
+	return sections, nil
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// A Decoder recreates an object graph from a response body of the media type it was registered for.
+type Decoder func(data []byte, target interface{}) error
+
+var (
+	decoders = map[string]Decoder{
+		"application/json": json.Unmarshal,
+		"text/json":        json.Unmarshal,
+		"application/xml":  xml.Unmarshal,
+		"text/xml":         xml.Unmarshal,
+		"text/plain":       DecodeText,
+		"text/html":        DecodeText,
+	}
+	decodersLock sync.RWMutex
+)
+
+// RegisterDecoder() makes DeserializeBody() decode responses of the given media type, e.g., "application/atom+xml", with the given decoder.
+// It replaces any decoder previously registered for the type, including the built-in ones; pass a nil decoder to remove one.
+//
+// Out of the box, JSON and XML documents are decoded with the encoding/json and encoding/xml packages,
+// as are other media types with a +json or +xml suffix, while plain text and HTML are handled by DecodeText().
+func RegisterDecoder(mediaType string, decoder Decoder) {
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+
+	mediaType = strings.ToLower(mediaType)
+	if decoder == nil {
+		delete(decoders, mediaType)
+	} else {
+		decoders[mediaType] = decoder
+	}
+}
+
+// decoderFor() yields the decoder registered for the media type, falling back on its structured syntax suffix, if any.
+func decoderFor(mediaType string) (Decoder, bool) {
+	decodersLock.RLock()
+	defer decodersLock.RUnlock()
+
+	if d, ok := decoders[mediaType]; ok {
+		return d, true
+	}
+	switch {
+	case strings.HasSuffix(mediaType, "+json"):
+		return decoderForSuffix("application/json")
+	case strings.HasSuffix(mediaType, "+xml"):
+		return decoderForSuffix("application/xml")
+	}
+	return nil, false
+}
+
+func decoderForSuffix(mediaType string) (Decoder, bool) {
+	d, ok := decoders[mediaType]
+	return d, ok
+}
+
+// DecodeText() is the Decoder for text/plain and text/html responses.
+// The target must be a *string, a *[]byte or an io.Writer, which receives the body verbatim.
+func DecodeText(data []byte, target interface{}) error {
+	switch t := target.(type) {
+	case *string:
+		*t = string(data)
+	case *[]byte:
+		*t = append([]byte(nil), data...)
+	case io.Writer:
+		_, err := t.Write(data)
+		return err
+	default:
+		return fmt.Errorf("cannot decode text into %T", target)
+	}
+	return nil
+}
+
+// An XMLRequestBody sends its Object encoded as an XML document, for endpoints which require XML.
+// See http://godoc.org/encoding/xml for how Go values are encoded.
+type XMLRequestBody struct {
+	Object interface{}
+	data   []byte
+}
+
+func (b *XMLRequestBody) marshal() error {
+	if b.data != nil {
+		return nil
+	}
+
+	data, err := xml.Marshal(b.Object)
+	if err == nil {
+		b.data = append([]byte(xml.Header), data...)
+	}
+
+	return err
+}
+
+func (b *XMLRequestBody) ContentType() (string, error) {
+	return "application/xml", nil
+}
+
+func (b *XMLRequestBody) ContentLength() (int64, error) {
+	err := b.marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(b.data)), nil
+}
+
+func (b *XMLRequestBody) Body() (io.Reader, error) {
+	err := b.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(b.data), nil
+}
+
+func (b *XMLRequestBody) bytes() ([]byte, error) {
+	err := b.marshal()
+	return b.data, err
+}
+
+// A TextRequestBody sends its Text verbatim, as text/plain unless Type says otherwise.
+type TextRequestBody struct {
+	Text string
+	Type string
+}
+
+func (b *TextRequestBody) ContentType() (string, error) {
+	if b.Type == "" {
+		return "text/plain; charset=utf-8", nil
+	}
+	return b.Type, nil
+}
+
+func (b *TextRequestBody) ContentLength() (int64, error) {
+	return int64(len(b.Text)), nil
+}
+
+func (b *TextRequestBody) Body() (io.Reader, error) {
+	return strings.NewReader(b.Text), nil
+}
+
+func (b *TextRequestBody) bytes() ([]byte, error) {
+	return []byte(b.Text), nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func withContentType(contentType, response string) *RestClient {
+	return withTestClient(&testTransport{header: http.Header{"Content-Type": {contentType}}, response: response})
+}
+
+func TestDeserializeXML(t *testing.T) {
+	c := withContentType("application/xml; charset=UTF-8", `<?xml version="1.0"?><flavor id="2" name="512MB Standard Instance"><ram>512</ram></flavor>`)
+	resp, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/flavors/2"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	flavor := struct {
+		Id   string `xml:"id,attr"`
+		Name string `xml:"name,attr"`
+		Ram  int    `xml:"ram"`
+	}{}
+	if err := resp.DeserializeBody(&flavor); err != nil {
+		t.Error(err)
+		return
+	}
+	if flavor.Id != "2" || flavor.Ram != 512 {
+		t.Error("Expected flavor 2 with 512MB of RAM; got", flavor)
+		return
+	}
+}
+
+func TestDeserializeText(t *testing.T) {
+	for _, contentType := range []string{"text/plain", "text/html; charset=utf-8"} {
+		c := withContentType(contentType, "container1\ncontainer2\n")
+		resp, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var listing string
+		if err := resp.DeserializeBody(&listing); err != nil {
+			t.Error(err)
+			return
+		}
+		if listing != "container1\ncontainer2\n" {
+			t.Error("Expected the listing verbatim; got", listing)
+			return
+		}
+	}
+
+	if err := DecodeText([]byte("x"), &struct{}{}); err == nil {
+		t.Error("Expected text not to decode into a struct")
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err := DecodeText([]byte("x"), buf); err != nil || buf.String() != "x" {
+		t.Error("Expected text to be written to a writer; got", buf.String(), err)
+		return
+	}
+}
+
+func TestDeserializeRequiresContentType(t *testing.T) {
+	c := withTestClient(&testTransport{header: http.Header{}, response: `{"ok": true}`})
+	resp, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var doc map[string]interface{}
+	if err := resp.DeserializeBody(&doc); err == nil {
+		t.Error("Expected a response without a content-type to be refused; got", doc)
+		return
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	defer RegisterDecoder("application/x-shouting", nil)
+	RegisterDecoder("application/x-shouting", func(data []byte, target interface{}) error {
+		*target.(*string) = strings.ToUpper(string(data))
+		return nil
+	})
+
+	c := withContentType("application/x-shouting", "hello")
+	resp, _ := c.PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+	var s string
+	if err := resp.DeserializeBody(&s); err != nil || s != "HELLO" {
+		t.Error("Expected the registered decoder to be used; got", s, err)
+		return
+	}
+
+	c = withContentType("application/vnd.rackspace+json", `{"a":1}`)
+	resp, _ = c.PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+	doc := map[string]int{}
+	if err := resp.DeserializeBody(&doc); err != nil || doc["a"] != 1 {
+		t.Error("Expected +json types to decode as JSON; got", doc, err)
+		return
+	}
+
+	c = withContentType("application/octet-stream", "??")
+	resp, _ = c.PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+	if err := resp.DeserializeBody(&s); err == nil {
+		t.Error("Expected an unregistered type to be refused")
+		return
+	}
+}
+
+func TestXMLRequestBody(t *testing.T) {
+	transport := &testTransport{response: "{}"}
+	c := withTestClient(transport)
+
+	type resize struct {
+		XMLName   xml.Name `xml:"resize"`
+		FlavorRef string   `xml:"flavorRef,attr"`
+	}
+	_, err := c.PerformRequest(&RestRequest{Method: "POST", Path: "/servers/1/action", Body: &XMLRequestBody{Object: resize{FlavorRef: "3"}}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	req := transport.requests[0]
+	data, _ := ioutil.ReadAll(req.Body)
+	if req.Header.Get("Content-Type") != "application/xml" || !strings.HasSuffix(string(data), `<resize flavorRef="3"></resize>`) {
+		t.Error("Expected an XML document; got", req.Header.Get("Content-Type"), string(data))
+		return
+	}
+	if req.ContentLength != int64(len(data)) {
+		t.Error("Expected the content length to match the document; got", req.ContentLength)
+		return
+	}
+}
+
+func TestXMLFault(t *testing.T) {
+	c := withTestClient(&testTransport{
+		status:   404,
+		header:   http.Header{"Content-Type": {"application/xml"}},
+		response: `<?xml version="1.0" encoding="UTF-8"?><itemNotFound code="404" xmlns="http://docs.openstack.org/compute/api/v1.1"><message>Instance could not be found</message></itemNotFound>`,
+	})
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/servers/x", ExpectedStatusCodes: []int{200}})
+	apiErr := AsAPIError(err)
+	if apiErr == nil || apiErr.Fault == nil {
+		t.Error("Expected an XML fault to be decoded; got", err)
+		return
+	}
+	if apiErr.Fault.Type != "itemNotFound" || apiErr.Fault.Code != 404 || apiErr.Fault.Message != "Instance could not be found" {
+		t.Error("Expected the fault's particulars; got", apiErr.Fault)
+		return
+	}
+
+	c = withTestClient(&testTransport{status: 502, header: http.Header{"Content-Type": {"text/html"}}, response: "<html><body>Bad Gateway</body></html>"})
+	_, err = c.PerformRequest(&RestRequest{Method: "GET", Path: "/", ExpectedStatusCodes: []int{200}})
+	if apiErr := AsAPIError(err); apiErr == nil || apiErr.Fault != nil || apiErr.StatusCode != 502 {
+		t.Error("Expected a gateway error page to yield no fault; got", err)
+		return
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxFaultBodySize bounds how much of an error response's body is retained by an APIError.
+const maxFaultBodySize = 64 * 1024
+
+// RequestIdHeaders lists, in order of preference, the response headers Rackspace and OpenStack services use to identify a request.
+// Support staff will ask for the value of one of these when investigating a problem.
+var RequestIdHeaders = []string{
+	"X-Compute-Request-Id",
+	"X-Openstack-Request-Id",
+	"X-Response-Id",
+	"X-Trans-Id",
+	"X-Request-Id",
+}
+
+// A Fault describes the error document an API returns along with a failing status code.
+//
+// OpenStack and most Rackspace services wrap their faults in an object keyed by the fault's type, e.g.,
+// {"itemNotFound": {"code": 404, "message": "..."}}.
+// Cloud Monitoring instead returns a flat {"type": ..., "code": ..., "message": ..., "details": ...} document.
+// Both forms decode into the same Fault structure; the Type field holds either the wrapping key or the monitoring type.
+// Services answering in XML express the OpenStack form with the fault's type as the root element, e.g.,
+// <itemNotFound code="404"><message>...</message></itemNotFound>, which decodes likewise.
+//
+// RetryAfter holds the server's advice verbatim, for faults of any type carrying a retryAfter field, such as overLimit and rateLimitError faults; it is "" otherwise.
+type Fault struct {
+	Type       string
+	Code       int
+	Message    string
+	Details    string
+	RetryAfter string
+}
+
+// An APIError reports a response whose status code the client did not anticipate.
+//
+// StatusCode, Method and URL identify the failed exchange.
+// RequestId holds the first of the RequestIdHeaders found in the response, if any; Header holds the response's headers in full.
+// CorrelationId holds the ID the request was sent with, if any; see CorrelationMiddleware.
+// Body holds (a bounded prefix of) the raw response body, and Fault its decoded form, if the body could be recognized as a fault document.
+type APIError struct {
+	StatusCode    int
+	Method        string
+	URL           string
+	RequestId     string
+	CorrelationId string
+	Header        http.Header
+	Body          []byte
+	Fault         *Fault
+}
+
+// NewAPIError() builds an APIError from the particulars of a failed exchange, decoding the body as a fault document where possible.
+// It exists so that packages which do not use RestClient to perform their requests may still report failures consistently.
+func NewAPIError(method, url string, statusCode int, header http.Header, body []byte) *APIError {
+	if header == nil {
+		header = http.Header{}
+	}
+
+	e := &APIError{
+		StatusCode: statusCode,
+		Method:     method,
+		URL:        url,
+		Header:     header,
+		Body:       body,
+		Fault:      parseFault(body),
+		RequestId:  requestIdFrom(header),
+	}
+
+	return e
+}
+
+// requestIdFrom() yields the first of the RequestIdHeaders found in header, or "" if there are none.
+func requestIdFrom(header http.Header) string {
+	for _, name := range RequestIdHeaders {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// newAPIErrorFromResponse() consumes and closes the response body while building its APIError.
+func newAPIErrorFromResponse(resp *http.Response) *APIError {
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxFaultBodySize))
+
+	method, url, correlationId := "", "", ""
+	if resp.Request != nil {
+		method = resp.Request.Method
+		url = resp.Request.URL.String()
+		correlationId = resp.Request.Header.Get(CorrelationIdHeader)
+	}
+
+	e := NewAPIError(method, url, resp.StatusCode, resp.Header, body)
+	e.CorrelationId = correlationId
+	return e
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("unexpected HTTP status code: %d (%s %s)", e.StatusCode, e.Method, e.URL)
+	if e.Fault != nil {
+		msg += fmt.Sprintf(": %s: %s", e.Fault.Type, e.Fault.Message)
+		if e.Fault.Details != "" {
+			msg += " (" + e.Fault.Details + ")"
+		}
+	}
+	if e.RequestId != "" {
+		msg += " [request " + e.RequestId + "]"
+	}
+	if e.CorrelationId != "" {
+		msg += " [correlation " + e.CorrelationId + "]"
+	}
+	return msg
+}
+
+type faultBody struct {
+	Type       string `json:"type"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	Details    string `json:"details"`
+	RetryAfter string `json:"retryAfter"`
+}
+
+type xmlFaultBody struct {
+	XMLName    xml.Name
+	Code       int    `xml:"code,attr"`
+	Message    string `xml:"message"`
+	Details    string `xml:"details"`
+	RetryAfter string `xml:"retryAfter"`
+}
+
+// parseFault() recognizes both the OpenStack and the Cloud Monitoring fault document layouts, in JSON, and the OpenStack layout in XML.
+// It yields nil if the body is none of these.
+func parseFault(body []byte) *Fault {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '<' {
+		x := xmlFaultBody{}
+		if err := xml.Unmarshal(trimmed, &x); err != nil || x.Message == "" {
+			return nil
+		}
+		return &Fault{Type: x.XMLName.Local, Code: x.Code, Message: strings.TrimSpace(x.Message), Details: x.Details, RetryAfter: x.RetryAfter}
+	}
+
+	flat := faultBody{}
+	if err := json.Unmarshal(body, &flat); err == nil && flat.Type != "" && flat.Message != "" {
+		return &Fault{Type: flat.Type, Code: flat.Code, Message: flat.Message, Details: flat.Details, RetryAfter: flat.RetryAfter}
+	}
+
+	wrapped := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &wrapped); err != nil || len(wrapped) != 1 {
+		return nil
+	}
+
+	for key, raw := range wrapped {
+		inner := faultBody{}
+		if err := json.Unmarshal(raw, &inner); err != nil || (inner.Message == "" && inner.Code == 0) {
+			return nil
+		}
+		return &Fault{Type: key, Code: inner.Code, Message: inner.Message, Details: inner.Details, RetryAfter: inner.RetryAfter}
+	}
+
+	return nil
+}
+
+// AsAPIError() yields the APIError wrapped by err, or nil if err does not describe an API fault.
+func AsAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return nil
+}
+
+func hasStatusOrFault(err error, status int, faultTypes ...string) bool {
+	e := AsAPIError(err)
+	if e == nil {
+		return false
+	}
+	if e.StatusCode == status {
+		return true
+	}
+	if e.Fault != nil {
+		for _, t := range faultTypes {
+			if e.Fault.Type == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsNotFound() reports whether err indicates the requested resource does not exist.
+func IsNotFound(err error) bool {
+	return hasStatusOrFault(err, http.StatusNotFound, "itemNotFound", "notFoundError")
+}
+
+// IsConflict() reports whether err indicates the resource is in a state which forbids the request, e.g., confirming a resize that is not pending.
+func IsConflict(err error) bool {
+	return hasStatusOrFault(err, http.StatusConflict, "conflictingRequest", "buildInProgress")
+}
+
+// IsBadRequest() reports whether err indicates the server rejected the request as malformed or invalid.
+func IsBadRequest(err error) bool {
+	return hasStatusOrFault(err, http.StatusBadRequest, "badRequest", "validationError", "invalidJSONError")
+}
+
+// IsUnauthorized() reports whether err indicates the request's credentials were missing, invalid or expired.
+func IsUnauthorized(err error) bool {
+	return hasStatusOrFault(err, http.StatusUnauthorized, "unauthorized")
+}
+
+// IsRateLimited() reports whether err indicates the account exceeded one of its rate limits.
+// Rackspace reports this either as 429 Too Many Requests, or as 413 with an overLimit fault.
+func IsRateLimited(err error) bool {
+	if hasStatusOrFault(err, http.StatusTooManyRequests, "overLimit", "overLimitError", "rateLimitError") {
+		return true
+	}
+	e := AsAPIError(err)
+	return e != nil && e.StatusCode == http.StatusRequestEntityTooLarge && e.Header.Get("Retry-After") != ""
+}
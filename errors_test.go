@@ -0,0 +1,110 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+const (
+	ITEM_NOT_FOUND_FAULT = `{"itemNotFound": {"message": "Instance could not be found", "code": 404}}`
+	CONFLICT_FAULT       = `{"conflictingRequest": {"message": "Cannot 'confirmResize' while instance is in vm_state active", "code": 409}}`
+	OVER_LIMIT_FAULT     = `{"overLimit": {"code": 413, "message": "OverLimit Retry...", "details": "Error Details...", "retryAfter": "2013-10-01T19:45:00Z"}}`
+	MONITORING_FAULT     = `{"type": "notFoundError", "code": 404, "message": "Object does not exist", "details": "Object \"Entity\" with key \"enXXXX\" does not exist", "txnId": ".rh-abcd"}`
+)
+
+func TestPerformRequestYieldsAPIError(t *testing.T) {
+	transport := &testTransport{
+		status:   http.StatusConflict,
+		header:   http.Header{"Content-Type": []string{"application/json"}, "X-Compute-Request-Id": []string{"req-1234"}},
+		response: CONFLICT_FAULT,
+	}
+	c := withTestClient(transport)
+
+	_, err := c.PerformRequest(&RestRequest{Method: "POST", Path: "/servers/abc/action", ExpectedStatusCodes: []int{http.StatusNoContent}})
+	apiErr := AsAPIError(err)
+	if apiErr == nil {
+		t.Error("Expected an APIError; got", err)
+		return
+	}
+	if apiErr.StatusCode != http.StatusConflict || apiErr.Method != "POST" || apiErr.URL != "http://example.com/v1.0/servers/abc/action" {
+		t.Error("Misreported exchange:", apiErr.StatusCode, apiErr.Method, apiErr.URL)
+		return
+	}
+	if apiErr.RequestId != "req-1234" {
+		t.Error("Expected request ID req-1234; got", apiErr.RequestId)
+		return
+	}
+	if apiErr.Fault == nil || apiErr.Fault.Type != "conflictingRequest" {
+		t.Error("Expected a conflictingRequest fault; got", apiErr.Fault)
+		return
+	}
+	if !IsConflict(err) || IsNotFound(err) {
+		t.Error("Expected IsConflict() alone to hold")
+		return
+	}
+}
+
+func TestFaultLayouts(t *testing.T) {
+	e := NewAPIError("GET", "http://example.com/", 404, nil, []byte(ITEM_NOT_FOUND_FAULT))
+	if e.Fault == nil || e.Fault.Type != "itemNotFound" || e.Fault.Code != 404 {
+		t.Error("Misparsed OpenStack fault:", e.Fault)
+		return
+	}
+
+	e = NewAPIError("GET", "http://example.com/", 404, nil, []byte(MONITORING_FAULT))
+	if e.Fault == nil || e.Fault.Type != "notFoundError" || e.Fault.Details == "" {
+		t.Error("Misparsed monitoring fault:", e.Fault)
+		return
+	}
+
+	e = NewAPIError("GET", "http://example.com/", 413, nil, []byte(OVER_LIMIT_FAULT))
+	if e.Fault == nil || e.Fault.RetryAfter != "2013-10-01T19:45:00Z" {
+		t.Error("Misparsed overLimit fault:", e.Fault)
+		return
+	}
+	if !IsRateLimited(e) {
+		t.Error("Expected an overLimit fault to count as rate limiting")
+		return
+	}
+
+	e = NewAPIError("GET", "http://example.com/", 429, nil, []byte(`{"type": "rateLimitError", "code": 429, "message": "Slow down", "retryAfter": "2013-10-01T19:45:00Z"}`))
+	if e.Fault == nil || e.Fault.Type != "rateLimitError" || e.Fault.RetryAfter != "2013-10-01T19:45:00Z" {
+		t.Error("Misparsed flat fault:", e.Fault)
+		return
+	}
+
+	e = NewAPIError("GET", "http://example.com/", 502, nil, []byte("<html>Bad Gateway</html>"))
+	if e.Fault != nil {
+		t.Error("Expected no fault from an HTML error page; got", e.Fault)
+		return
+	}
+}
+
+func TestHelpersUnwrap(t *testing.T) {
+	err := fmt.Errorf("deleting entity: %w", NewAPIError("DELETE", "http://example.com/", 404, nil, nil))
+	if !IsNotFound(err) {
+		t.Error("Expected IsNotFound() to see through wrapped errors")
+		return
+	}
+	if IsNotFound(fmt.Errorf("plain error")) {
+		t.Error("Expected IsNotFound() to reject non-API errors")
+		return
+	}
+}
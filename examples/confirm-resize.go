@@ -5,6 +5,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/racker/gorax"
 	"github.com/racker/gorax/v2.0/cloud/servers"
 	"github.com/racker/gorax/v2.0/identity"
 	"log"
@@ -18,7 +19,6 @@ var serverId = flag.String("i", "", "ID of server to resize (required)")
 var wait = flag.Bool("w", false, "Wait for server to become ready for confirmation first")
 var revert = flag.Bool("revert", false, "Specify this flag if you wish to revert the resize.")
 
-
 func waitForServer(region servers.Region, id string) error {
 	ok := map[string]bool{
 		"VERIFY_RESIZE": true,
@@ -86,6 +86,9 @@ func main() {
 	} else {
 		err = region.ConfirmResizeServer(*serverId)
 	}
+	if gorax.IsConflict(err) {
+		log.Fatal("The server is not awaiting resize confirmation yet; try again shortly, or pass -w.")
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -15,9 +15,9 @@ func main() {
 		log.Fatal("Usage: I need both username and API key on CLI, in that order.")
 	}
 	username := os.Args[1]
-	password := os.Args[2]
+	apiKey := os.Args[2]
 
-	id := identity.NewIdentity(username, password, "")
+	id := identity.NewIdentityWithAPIKey(username, apiKey, "")
 	err := id.Authenticate()
 	if err != nil {
 		log.Fatal(err)
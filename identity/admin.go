@@ -0,0 +1,384 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/racker/gorax"
+	v2identity "github.com/racker/gorax/v2.0/identity"
+)
+
+// Role encapsulates a permission that a user can rely on.
+type Role = v2identity.Role
+
+// APIKeyCredentialsType and PasswordCredentialsType name the kinds of Credential a user may hold.
+const (
+	APIKeyCredentialsType   = "RAX-KSKEY:apiKeyCredentials"
+	PasswordCredentialsType = "passwordCredentials"
+)
+
+// A User describes an account of the Rackspace Identity service, as its admin API presents it.
+// Password is set only in the result of CreateUser(), and only if the service generated it.
+type User struct {
+	Id            string `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	Enabled       bool   `json:"enabled"`
+	DefaultRegion string `json:"RAX-AUTH:defaultRegion"`
+	DomainId      string `json:"RAX-AUTH:domainId"`
+	Password      string `json:"OS-KSADM:password"`
+	Created       string `json:"created"`
+	Updated       string `json:"updated"`
+}
+
+// UserOpts describe a user to create, or the changes to make to one.
+// Fields left empty, or nil, are left for the service to choose, or unchanged.
+// Leave Password empty at creation for the service to generate one.
+type UserOpts struct {
+	Username      string `json:"username,omitempty"`
+	Email         string `json:"email,omitempty"`
+	Enabled       *bool  `json:"enabled,omitempty"`
+	DefaultRegion string `json:"RAX-AUTH:defaultRegion,omitempty"`
+	Password      string `json:"OS-KSADM:password,omitempty"`
+}
+
+// A Credential is one means by which a user may authenticate: an API key, or a password.
+// Type is APIKeyCredentialsType or PasswordCredentialsType; APIKey is set for the former alone.
+// The service never reveals passwords.
+type Credential struct {
+	Type     string
+	Username string
+	APIKey   string
+}
+
+// An AdminClient manages the users of a Rackspace Identity v2.0 account, their roles, and their API keys,
+// through the service's admin API.
+// Most operations require the identity to be the account's user-admin, or to hold an admin role, and affect only the account's sub-users.
+//
+// Its requests carry the identity's token, renewed as IdentityMiddleware does.
+type AdminClient struct {
+	client *gorax.RestClient
+	auth   *IdentityMiddleware
+}
+
+// ErrAdminRequiresV2 is returned by MakeAdminClient() for a Keystone v3 identity.
+// The admin API is that of Rackspace Identity v2.0; Keystone v3 manages users through an API of its own.
+var ErrAdminRequiresV2 = errors.New("the identity admin API requires an Identity v2.0 identity")
+
+// MakeAdminClient creates a client for the admin API of the Identity v2.0 service the given identity authenticates against.
+// The identity need not be authenticated yet, but it must be an Identity v2.0 identity; Keystone v3 identities yield ErrAdminRequiresV2.
+// Any options given configure how the client reaches the service; see gorax.ClientOption.
+func MakeAdminClient(id v2identity.Identity, opts ...gorax.ClientOption) (*AdminClient, error) {
+	if _, ok := id.(interface {
+		Details() (*v2identity.V3Token, error)
+	}); ok {
+		return nil, ErrAdminRequiresV2
+	}
+
+	c := makeIdentityRestClient(strings.TrimSuffix(id.AuthEndpoint(), "/tokens"), opts)
+	auth := MakeKeystoneIdentityMiddleware(id)
+	auth.SkipTenantPath = true
+	c.RequestMiddlewares = append(c.RequestMiddlewares, auth)
+	c.RoundTripMiddlewares = append(c.RoundTripMiddlewares, auth)
+	return &AdminClient{client: c, auth: auth}, nil
+}
+
+// UseClient() configures the admin client, and its identity, to use a specific net/http client.
+func (a *AdminClient) UseClient(client *http.Client) {
+	a.client.UseClient(client)
+	a.auth.UseClient(client)
+}
+
+// SetLogger() reports every exchange made by the admin client to the given logger, at the given level of detail.
+func (a *AdminClient) SetLogger(logger gorax.Logger, level gorax.LogLevel) {
+	a.client.SetLogger(logger, level)
+}
+
+// AddObserver() reports every request made by the admin client to the given observer; see gorax.Observer.
+// Operations are named after the client's methods, e.g., "identity.ListUsers".
+func (a *AdminClient) AddObserver(observer gorax.Observer) {
+	a.client.AddObserver(observer)
+}
+
+// SetRetryPolicy() configures how the admin client re-attempts requests that fail for transient reasons.
+// See gorax.RetryPolicy for details; pass nil to disable retries.
+func (a *AdminClient) SetRetryPolicy(policy *gorax.RetryPolicy) {
+	a.client.SetRetryPolicy(policy)
+}
+
+// SetTimeout() bounds how long any single request made through the admin client may take.
+func (a *AdminClient) SetTimeout(timeout time.Duration) {
+	a.client.SetTimeout(timeout)
+}
+
+// perform issues a request on behalf of the named operation.
+// The body, if not nil, is sent as JSON; the response, if results is not nil, is decoded into it.
+func (a *AdminClient) perform(ctx context.Context, operation, method, path string, body interface{}, results interface{}, expected int) error {
+	restReq := &gorax.RestRequest{
+		Method:              method,
+		Path:                path,
+		ExpectedStatusCodes: []int{expected},
+		Operation:           operation,
+	}
+	if body != nil {
+		restReq.Body = &gorax.JSONRequestBody{Object: body}
+	}
+
+	resp, err := a.client.PerformRequestWithContext(ctx, restReq)
+	if err != nil {
+		return err
+	}
+	if results == nil {
+		resp.Body.Close()
+		return nil
+	}
+	return resp.DeserializeBody(results)
+}
+
+// ListUsers() yields the users the identity may manage: for a user-admin, the account's users, itself included.
+func (a *AdminClient) ListUsers() ([]User, error) {
+	return a.ListUsersWithContext(context.Background())
+}
+
+// ListUsersWithContext is like ListUsers, but issues its requests under the given context.
+func (a *AdminClient) ListUsersWithContext(ctx context.Context) ([]User, error) {
+	users := make([]User, 0)
+	err := a.perform(ctx, "identity.ListUsers", "GET", "/users", nil, &struct{ Users *[]User }{&users}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetUser() retrieves the user with the given ID.
+func (a *AdminClient) GetUser(userId string) (*User, error) {
+	return a.GetUserWithContext(context.Background(), userId)
+}
+
+// GetUserWithContext is like GetUser, but issues its requests under the given context.
+func (a *AdminClient) GetUserWithContext(ctx context.Context, userId string) (*User, error) {
+	user := &User{}
+	err := a.perform(ctx, "identity.GetUser", "GET", "/users/"+url.PathEscape(userId), nil, &struct{ User *User }{user}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUserByName() retrieves the user with the given username.
+func (a *AdminClient) GetUserByName(username string) (*User, error) {
+	return a.GetUserByNameWithContext(context.Background(), username)
+}
+
+// GetUserByNameWithContext is like GetUserByName, but issues its requests under the given context.
+func (a *AdminClient) GetUserByNameWithContext(ctx context.Context, username string) (*User, error) {
+	user := &User{}
+	err := a.perform(ctx, "identity.GetUserByName", "GET", "/users?name="+url.QueryEscape(username), nil, &struct{ User *User }{user}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateUser() creates a sub-user of the identity's account, yielding the new user.
+// If opts give no password, the service generates one, which appears in the result's Password field, and nowhere else, ever.
+func (a *AdminClient) CreateUser(opts UserOpts) (*User, error) {
+	return a.CreateUserWithContext(context.Background(), opts)
+}
+
+// CreateUserWithContext is like CreateUser, but issues its requests under the given context.
+func (a *AdminClient) CreateUserWithContext(ctx context.Context, opts UserOpts) (*User, error) {
+	user := &User{}
+	err := a.perform(ctx, "identity.CreateUser", "POST", "/users", &struct {
+		User UserOpts `json:"user"`
+	}{opts}, &struct{ User *User }{user}, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateUser() changes the user with the given ID as opts describe, yielding the updated user.
+func (a *AdminClient) UpdateUser(userId string, opts UserOpts) (*User, error) {
+	return a.UpdateUserWithContext(context.Background(), userId, opts)
+}
+
+// UpdateUserWithContext is like UpdateUser, but issues its requests under the given context.
+func (a *AdminClient) UpdateUserWithContext(ctx context.Context, userId string, opts UserOpts) (*User, error) {
+	type userUpdate struct {
+		Id string `json:"id"`
+		UserOpts
+	}
+
+	user := &User{}
+	err := a.perform(ctx, "identity.UpdateUser", "POST", "/users/"+url.PathEscape(userId), &struct {
+		User userUpdate `json:"user"`
+	}{userUpdate{userId, opts}}, &struct{ User *User }{user}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetUserEnabled() enables or disables the user with the given ID.
+// Disabled users cannot authenticate, and their tokens are revoked.
+func (a *AdminClient) SetUserEnabled(userId string, enabled bool) error {
+	return a.SetUserEnabledWithContext(context.Background(), userId, enabled)
+}
+
+// SetUserEnabledWithContext is like SetUserEnabled, but issues its requests under the given context.
+func (a *AdminClient) SetUserEnabledWithContext(ctx context.Context, userId string, enabled bool) error {
+	_, err := a.UpdateUserWithContext(ctx, userId, UserOpts{Enabled: &enabled})
+	return err
+}
+
+// DeleteUser() deletes the user with the given ID.
+func (a *AdminClient) DeleteUser(userId string) error {
+	return a.DeleteUserWithContext(context.Background(), userId)
+}
+
+// DeleteUserWithContext is like DeleteUser, but issues its requests under the given context.
+func (a *AdminClient) DeleteUserWithContext(ctx context.Context, userId string) error {
+	return a.perform(ctx, "identity.DeleteUser", "DELETE", "/users/"+url.PathEscape(userId), nil, nil, http.StatusNoContent)
+}
+
+// ListRoles() yields every role the service defines, whether or not the identity may grant it.
+func (a *AdminClient) ListRoles() ([]Role, error) {
+	return a.ListRolesWithContext(context.Background())
+}
+
+// ListRolesWithContext is like ListRoles, but issues its requests under the given context.
+func (a *AdminClient) ListRolesWithContext(ctx context.Context) ([]Role, error) {
+	roles := make([]Role, 0)
+	err := a.perform(ctx, "identity.ListRoles", "GET", "/OS-KSADM/roles", nil, &struct{ Roles *[]Role }{&roles}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// ListUserRoles() yields the roles granted to the user with the given ID.
+func (a *AdminClient) ListUserRoles(userId string) ([]Role, error) {
+	return a.ListUserRolesWithContext(context.Background(), userId)
+}
+
+// ListUserRolesWithContext is like ListUserRoles, but issues its requests under the given context.
+func (a *AdminClient) ListUserRolesWithContext(ctx context.Context, userId string) ([]Role, error) {
+	roles := make([]Role, 0)
+	err := a.perform(ctx, "identity.ListUserRoles", "GET", "/users/"+url.PathEscape(userId)+"/roles", nil, &struct{ Roles *[]Role }{&roles}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GrantRole() grants the role with the given ID to the user with the given ID.
+func (a *AdminClient) GrantRole(userId, roleId string) error {
+	return a.GrantRoleWithContext(context.Background(), userId, roleId)
+}
+
+// GrantRoleWithContext is like GrantRole, but issues its requests under the given context.
+func (a *AdminClient) GrantRoleWithContext(ctx context.Context, userId, roleId string) error {
+	return a.perform(ctx, "identity.GrantRole", "PUT", userRolePath(userId, roleId), nil, nil, http.StatusOK)
+}
+
+// RevokeRole() revokes the role with the given ID from the user with the given ID.
+func (a *AdminClient) RevokeRole(userId, roleId string) error {
+	return a.RevokeRoleWithContext(context.Background(), userId, roleId)
+}
+
+// RevokeRoleWithContext is like RevokeRole, but issues its requests under the given context.
+func (a *AdminClient) RevokeRoleWithContext(ctx context.Context, userId, roleId string) error {
+	return a.perform(ctx, "identity.RevokeRole", "DELETE", userRolePath(userId, roleId), nil, nil, http.StatusNoContent)
+}
+
+func userRolePath(userId, roleId string) string {
+	return fmt.Sprintf("/users/%s/roles/OS-KSADM/%s", url.PathEscape(userId), url.PathEscape(roleId))
+}
+
+// ListCredentials() yields the means by which the user with the given ID may authenticate.
+func (a *AdminClient) ListCredentials(userId string) ([]Credential, error) {
+	return a.ListCredentialsWithContext(context.Background(), userId)
+}
+
+// ListCredentialsWithContext is like ListCredentials, but issues its requests under the given context.
+func (a *AdminClient) ListCredentialsWithContext(ctx context.Context, userId string) ([]Credential, error) {
+	// Each credential is an object with one member, named for its type.
+	var raw []map[string]json.RawMessage
+	err := a.perform(ctx, "identity.ListCredentials", "GET", "/users/"+url.PathEscape(userId)+"/OS-KSADM/credentials", nil, &struct {
+		Credentials *[]map[string]json.RawMessage
+	}{&raw}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]Credential, 0, len(raw))
+	for _, c := range raw {
+		for kind, body := range c {
+			var fields struct{ Username, APIKey string }
+			if err := json.Unmarshal(body, &fields); err != nil {
+				return nil, err
+			}
+			credentials = append(credentials, Credential{Type: kind, Username: fields.Username, APIKey: fields.APIKey})
+		}
+	}
+	return credentials, nil
+}
+
+// GetAPIKey() yields the API key of the user with the given ID.
+func (a *AdminClient) GetAPIKey(userId string) (string, error) {
+	return a.GetAPIKeyWithContext(context.Background(), userId)
+}
+
+// GetAPIKeyWithContext is like GetAPIKey, but issues its requests under the given context.
+func (a *AdminClient) GetAPIKeyWithContext(ctx context.Context, userId string) (string, error) {
+	return a.apiKey(ctx, "identity.GetAPIKey", "GET", apiKeyPath(userId))
+}
+
+// ResetAPIKey() replaces the API key of the user with the given ID with a newly generated one, which it yields.
+// The old key stops working at once, though tokens already obtained with it remain valid.
+func (a *AdminClient) ResetAPIKey(userId string) (string, error) {
+	return a.ResetAPIKeyWithContext(context.Background(), userId)
+}
+
+// ResetAPIKeyWithContext is like ResetAPIKey, but issues its requests under the given context.
+func (a *AdminClient) ResetAPIKeyWithContext(ctx context.Context, userId string) (string, error) {
+	return a.apiKey(ctx, "identity.ResetAPIKey", "POST", apiKeyPath(userId)+"/RAX-AUTH/reset")
+}
+
+func apiKeyPath(userId string) string {
+	return "/users/" + url.PathEscape(userId) + "/OS-KSADM/credentials/" + APIKeyCredentialsType
+}
+
+// apiKey issues a request whose response holds a user's API key credentials, yielding the key.
+func (a *AdminClient) apiKey(ctx context.Context, operation, method, path string) (string, error) {
+	var result struct {
+		Credentials v2identity.APIKeyCredentials `json:"RAX-KSKEY:apiKeyCredentials"`
+	}
+	if err := a.perform(ctx, operation, method, path, nil, &result, http.StatusOK); err != nil {
+		return "", err
+	}
+	return result.Credentials.APIKey, nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	v2identity "github.com/racker/gorax/v2.0/identity"
+)
+
+// adminTransport plays the Identity v2.0 service, answering each admin request from its canned responses,
+// keyed by method and request URI, and recording each request's URI, and each body sent.
+type adminTransport struct {
+	lock      sync.Mutex
+	responses map[string]string
+	requests  []string
+	bodies    []string
+}
+
+func (t *adminTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	status, body := http.StatusOK, `{"access": {"token": {"id": "admin-token", "expires": "2099-01-01T00:00:00.000Z"}}}`
+	if req.URL.Path != "/v2.0/tokens" {
+		key := req.Method + " " + req.URL.RequestURI()
+		t.requests = append(t.requests, key)
+		if req.Body != nil {
+			if data, _ := ioutil.ReadAll(req.Body); len(data) > 0 {
+				t.bodies = append(t.bodies, string(data))
+			}
+		}
+
+		response, ok := t.responses[key]
+		switch {
+		case req.Header.Get("X-Auth-Token") != "admin-token":
+			status, body = http.StatusUnauthorized, `{"unauthorized": {"code": 401}}`
+		case !ok:
+			status, body = http.StatusNotFound, `{"itemNotFound": {"code": 404}}`
+		case response == "":
+			status, body = http.StatusNoContent, ""
+		case req.Method == "POST" && req.URL.Path == "/v2.0/users":
+			status, body = http.StatusCreated, response
+		default:
+			body = response
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func makeAdminClient(transport *adminTransport) *AdminClient {
+	id := v2identity.NewIdentityWithAPIKey("admin", "key", "")
+	id.SetAuthEndpoint("https://identity.example.com/v2.0")
+	a, _ := MakeAdminClient(id)
+	a.UseClient(&http.Client{Transport: transport})
+	return a
+}
+
+func TestAdminRequiresV2Identity(t *testing.T) {
+	id := v2identity.NewV3Identity("https://keystone.example.com/v3", v2identity.V3Credentials{UserId: "u1", Password: "pw"})
+	if a, err := MakeAdminClient(id); err != ErrAdminRequiresV2 || a != nil {
+		t.Error("Expected a Keystone v3 identity to be refused; got", a, err)
+		return
+	}
+}
+
+func TestAdminManagesUsers(t *testing.T) {
+	transport := &adminTransport{responses: map[string]string{
+		"GET /v2.0/users":                        `{"users": [{"id": "1", "username": "admin", "enabled": true}, {"id": "2", "username": "backup", "enabled": false}]}`,
+		"GET /v2.0/users/2":                      `{"user": {"id": "2", "username": "backup", "RAX-AUTH:defaultRegion": "DFW"}}`,
+		"GET /v2.0/users?name=back+up":           `{"user": {"id": "3", "username": "back up"}}`,
+		"POST /v2.0/users":                       `{"user": {"id": "4", "username": "deployer", "enabled": true, "OS-KSADM:password": "generated"}}`,
+		"POST /v2.0/users/4":                     `{"user": {"id": "4", "username": "deployer", "enabled": false}}`,
+		"DELETE /v2.0/users/4":                   ``,
+		"GET /v2.0/OS-KSADM/roles":               `{"roles": [{"id": "10", "name": "identity:default"}, {"id": "11", "name": "object-store:admin"}]}`,
+		"GET /v2.0/users/4/roles":                `{"roles": [{"id": "10", "name": "identity:default", "description": "Default role"}]}`,
+		"PUT /v2.0/users/4/roles/OS-KSADM/11":    `{}`,
+		"DELETE /v2.0/users/4/roles/OS-KSADM/11": ``,
+	}}
+	a := makeAdminClient(transport)
+
+	users, err := a.ListUsers()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(users) != 2 || users[1].Username != "backup" || users[1].Enabled || !users[0].Enabled {
+		t.Error("Unexpected users:", users)
+		return
+	}
+	user, err := a.GetUser("2")
+	if err != nil || user.DefaultRegion != "DFW" {
+		t.Error("Expected the backup user in DFW; got", user, err)
+		return
+	}
+	user, err = a.GetUserByName("back up")
+	if err != nil || user.Id != "3" {
+		t.Error("Expected user 3; got", user, err)
+		return
+	}
+
+	user, err = a.CreateUser(UserOpts{Username: "deployer", Email: "deploy@example.com"})
+	if err != nil || user.Id != "4" || user.Password != "generated" {
+		t.Error("Expected a new user with a generated password; got", user, err)
+		return
+	}
+	if err := a.SetUserEnabled("4", false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	roles, err := a.ListRoles()
+	if err != nil || len(roles) != 2 {
+		t.Error("Expected two roles; got", roles, err)
+		return
+	}
+	roles, err = a.ListUserRoles("4")
+	if err != nil || !reflect.DeepEqual(roles, []Role{{Id: "10", Name: "identity:default", Description: "Default role"}}) {
+		t.Error("Expected the default role; got", roles, err)
+		return
+	}
+	if err := a.GrantRole("4", "11"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := a.RevokeRole("4", "11"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := a.DeleteUser("4"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := a.DeleteUser("5"); err == nil {
+		t.Error("Expected deleting a missing user to fail")
+		return
+	}
+
+	if len(transport.bodies) != 2 {
+		t.Error("Expected bodies for the creation and update alone; got", transport.bodies)
+		return
+	}
+	var created, updated map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(transport.bodies[0]), &created); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := json.Unmarshal([]byte(transport.bodies[1]), &updated); err != nil {
+		t.Error(err)
+		return
+	}
+	expectedCreated := map[string]interface{}{"username": "deployer", "email": "deploy@example.com"}
+	expectedUpdated := map[string]interface{}{"id": "4", "enabled": false}
+	if !reflect.DeepEqual(created["user"], expectedCreated) || !reflect.DeepEqual(updated["user"], expectedUpdated) {
+		t.Error("Unexpected request bodies:", transport.bodies[0], transport.bodies[1])
+		return
+	}
+}
+
+func TestAdminManagesAPIKeys(t *testing.T) {
+	transport := &adminTransport{responses: map[string]string{
+		"GET /v2.0/users/4/OS-KSADM/credentials": `{"credentials": [
+			{"RAX-KSKEY:apiKeyCredentials": {"username": "deployer", "apiKey": "old-key"}},
+			{"passwordCredentials": {"username": "deployer"}}]}`,
+		"GET /v2.0/users/4/OS-KSADM/credentials/RAX-KSKEY:apiKeyCredentials":                 `{"RAX-KSKEY:apiKeyCredentials": {"username": "deployer", "apiKey": "old-key"}}`,
+		"POST /v2.0/users/4/OS-KSADM/credentials/RAX-KSKEY:apiKeyCredentials/RAX-AUTH/reset": `{"RAX-KSKEY:apiKeyCredentials": {"username": "deployer", "apiKey": "new-key"}}`,
+	}}
+	a := makeAdminClient(transport)
+
+	credentials, err := a.ListCredentials("4")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []Credential{
+		{Type: APIKeyCredentialsType, Username: "deployer", APIKey: "old-key"},
+		{Type: PasswordCredentialsType, Username: "deployer"},
+	}
+	if !reflect.DeepEqual(credentials, expected) {
+		t.Error("Unexpected credentials:", credentials)
+		return
+	}
+
+	key, err := a.GetAPIKey("4")
+	if err != nil || key != "old-key" {
+		t.Error("Expected the old key; got", key, err)
+		return
+	}
+	key, err = a.ResetAPIKey("4")
+	if err != nil || key != "new-key" {
+		t.Error("Expected a new key; got", key, err)
+		return
+	}
+}
@@ -17,12 +17,14 @@ limitations under the License.
 package identity
 
 import (
+	"context"
 	"github.com/racker/gorax"
+	v2identity "github.com/racker/gorax/v2.0/identity"
 	"net/http"
 )
 
 var (
-	ErrMissingCredential = &gorax.RestError{"Either a username or an apiKey must be supplied"}
+	ErrMissingCredential = &gorax.RestError{ErrorString: "Either a username or an apiKey must be supplied"}
 )
 
 var (
@@ -48,18 +50,12 @@ type authenticateWithAPIKey struct {
 	} `json:"auth"`
 }
 
-type EntryEndpoint struct {
-	Region     string
-	TenantId   string
-	PublicURL  string
-	InternaURL string
-}
+// EntryEndpoint describes how to reach a service in one region; it is the same type as in the v2.0/identity package,
+// so that catalogs from either package may be searched with v2identity.Catalog.Resolve().
+type EntryEndpoint = v2identity.EntryEndpoint
 
-type CatalogEntry struct {
-	Name      string
-	Type      string
-	Endpoints []EntryEndpoint
-}
+// CatalogEntry describes a service in the service catalog.
+type CatalogEntry = v2identity.CatalogEntry
 
 type AuthResponse struct {
 	Access struct {
@@ -107,8 +103,29 @@ func (k *KeystoneClient) SetDebug(debug bool) {
 	k.client.SetDebug(debug)
 }
 
+// UseClient() configures the Keystone client to use a specific net/http client; see gorax.RestClient.UseClient().
+func (k *KeystoneClient) UseClient(client *http.Client) {
+	k.client.UseClient(client)
+}
+
+// SetLogger() reports each authentication exchange to the given logger.
+// Passwords, API keys and the tokens issued in response are redacted.
+func (k *KeystoneClient) SetLogger(logger gorax.Logger, level gorax.LogLevel) {
+	k.client.SetLogger(logger, level)
+}
+
+// AddObserver() reports each authentication request to the given observer; see gorax.Observer.
+func (k *KeystoneClient) AddObserver(observer gorax.Observer) {
+	k.client.AddObserver(observer)
+}
+
 // Authenticate() attempts to verify the principal making the current request actually has the privileges necessary to do so.
 func (k *KeystoneClient) Authenticate() (*AuthResponse, error) {
+	return k.AuthenticateWithContext(context.Background())
+}
+
+// AuthenticateWithContext() behaves like Authenticate(), but aborts the token request if the context is cancelled or expires first.
+func (k *KeystoneClient) AuthenticateWithContext(ctx context.Context) (*AuthResponse, error) {
 	creds, err := k.getCredentials()
 	if err != nil {
 		return nil, err
@@ -121,9 +138,10 @@ func (k *KeystoneClient) Authenticate() (*AuthResponse, error) {
 			Object: creds,
 		},
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "identity.Authenticate",
 	}
 
-	resp, err := k.client.PerformRequest(restReq)
+	resp, err := k.client.PerformRequestWithContext(ctx, restReq)
 
 	if err != nil {
 		return nil, err
@@ -135,18 +153,29 @@ func (k *KeystoneClient) Authenticate() (*AuthResponse, error) {
 	return authResponse, err
 }
 
-func MakePasswordKeystoneClient(url string, username string, password string) *KeystoneClient {
+// MakePasswordKeystoneClient creates a Keystone client which authenticates with a username and password.
+// Any options given configure how the client reaches Keystone; see gorax.ClientOption.
+func MakePasswordKeystoneClient(url string, username string, password string, opts ...gorax.ClientOption) *KeystoneClient {
 	return &KeystoneClient{
-		client:   gorax.MakeRestClient(url),
+		client:   makeIdentityRestClient(url, opts),
 		username: username,
 		password: password,
 	}
 }
 
-func MakeAPIKeyKeystoneClient(url string, username string, apiKey string) *KeystoneClient {
+// MakeAPIKeyKeystoneClient creates a Keystone client which authenticates with a username and API key.
+// Any options given configure how the client reaches Keystone; see gorax.ClientOption.
+func MakeAPIKeyKeystoneClient(url string, username string, apiKey string, opts ...gorax.ClientOption) *KeystoneClient {
 	return &KeystoneClient{
-		client:   gorax.MakeRestClient(url),
+		client:   makeIdentityRestClient(url, opts),
 		username: username,
 		apiKey:   apiKey,
 	}
 }
+
+func makeIdentityRestClient(url string, opts []gorax.ClientOption) *gorax.RestClient {
+	c := gorax.MakeRestClient(url, opts...)
+	c.Service = "identity"
+	c.RequestMiddlewares = append(c.RequestMiddlewares, gorax.NewCorrelationMiddleware())
+	return c
+}
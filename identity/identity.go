@@ -0,0 +1,144 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identity
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/racker/gorax"
+	v2identity "github.com/racker/gorax/v2.0/identity"
+)
+
+// The IdentityMiddleware object authenticates requests with any identity of the v2.0/identity package,
+// such as a Keystone v3 identity from v2identity.NewV3Identity().
+// It stamps each request with the identity's token, and redirects its path beneath the identity's tenant,
+// just as KeystoneAuthMiddleware does.
+//
+// If the identity is a v2identity.Reauthenticator, the middleware renews its token within ExpireDelta of expiry,
+// and replays once, with a renewed token, any request the server rejects as unauthorized.
+// Like KeystoneAuthMiddleware, it must appear among a client's RoundTripMiddlewares for the latter to work.
+//
+// Set SkipTenantPath for services whose catalog URLs already include the tenant, so that request paths are left alone.
+type IdentityMiddleware struct {
+	SkipTenantPath bool
+	id             v2identity.Identity
+	renewer        v2identity.Renewer
+}
+
+// MakeKeystoneIdentityMiddleware creates a middleware which authenticates requests with the given identity.
+// The identity need not be authenticated yet; the middleware authenticates it on first use.
+func MakeKeystoneIdentityMiddleware(id v2identity.Identity) *IdentityMiddleware {
+	return &IdentityMiddleware{id: id}
+}
+
+// Identity() yields the identity through which the middleware authenticates.
+func (m *IdentityMiddleware) Identity() v2identity.Identity {
+	return m.id
+}
+
+// UseClient() configures the identity to authenticate through a specific net/http client, if it supports doing so.
+func (m *IdentityMiddleware) UseClient(client *http.Client) {
+	if u, ok := m.id.(interface {
+		UseClient(*http.Client)
+	}); ok {
+		u.UseClient(client)
+	}
+}
+
+// HandleRequest adds the identity's X-Auth-Token header to the request, and prefixes its path with the identity's tenant ID.
+// The identity is authenticated first if it hasn't been, or re-authenticated if its token is about to expire.
+// A failed renewal is reported only if the old token has expired outright, and isn't retried until v2identity.RenewalBackoff has passed.
+func (m *IdentityMiddleware) HandleRequest(req *gorax.RestRequest) (*gorax.RestRequest, error) {
+	return m.HandleRequestWithContext(context.Background(), req)
+}
+
+// HandleRequestWithContext behaves like HandleRequest, but gives up on authenticating if the context ends first.
+// Identities which are not v2identity.Reauthenticators authenticate without regard to the context.
+func (m *IdentityMiddleware) HandleRequestWithContext(ctx context.Context, req *gorax.RestRequest) (*gorax.RestRequest, error) {
+	reauth, renewable := m.id.(v2identity.Reauthenticator)
+
+	if !m.id.IsAuthenticated() {
+		var err error
+		if renewable {
+			err = reauth.ReauthenticateWithContext(ctx, "")
+		} else {
+			err = m.id.Authenticate()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if renewable {
+		if err := m.renewer.Renew(ctx, reauth, ExpireDelta); err != nil {
+			return nil, err
+		}
+	}
+
+	token, tenantId, err := m.credentials()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", token)
+	if !m.SkipTenantPath {
+		req.Path = "/" + tenantId + req.Path
+	}
+	return req, nil
+}
+
+// HandleRoundTrip replays a request the server rejects as unauthorized, once, after renewing the identity's token.
+// Identities which cannot re-authenticate leave the rejection to stand.
+func (m *IdentityMiddleware) HandleRoundTrip(ctx context.Context, req *gorax.RestRequest, next gorax.RoundTripFunc) (*gorax.RestResponse, error) {
+	resp, err := next(ctx, req)
+	reauth, ok := m.id.(v2identity.Reauthenticator)
+	if !ok || !gorax.IsUnauthorized(err) {
+		return resp, err
+	}
+
+	rejected := req.Header.Get("X-Auth-Token")
+	_, oldTenantId, cerr := m.credentials()
+	if cerr != nil {
+		return resp, err
+	}
+	if rerr := reauth.ReauthenticateWithContext(ctx, rejected); rerr != nil {
+		return resp, err
+	}
+	token, tenantId, cerr := m.credentials()
+	if cerr != nil || token == rejected {
+		return resp, err
+	}
+
+	replay := *req
+	replay.Header = req.Header.Clone()
+	replay.Header.Set("X-Auth-Token", token)
+	if !m.SkipTenantPath {
+		replay.Path = "/" + tenantId + strings.TrimPrefix(req.Path, "/"+oldTenantId)
+	}
+	return next(ctx, &replay)
+}
+
+// credentials yields the identity's current token and tenant ID.
+func (m *IdentityMiddleware) credentials() (string, string, error) {
+	token, err := m.id.Token()
+	if err != nil {
+		return "", "", err
+	}
+	tenantId, err := m.id.TenantId()
+	return token, tenantId, err
+}
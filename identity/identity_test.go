@@ -0,0 +1,144 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/racker/gorax"
+	v2identity "github.com/racker/gorax/v2.0/identity"
+)
+
+// v3Transport plays both Keystone v3 and a service which accepts only the most recently issued token.
+// Tokens expire at the given time, or far in the future if none is given.
+// While down is set, Keystone fails with 503 Service Unavailable; attempts counts every request Keystone sees.
+type v3Transport struct {
+	lock     sync.Mutex
+	expires  string
+	down     bool
+	attempts int
+	issued   int
+	accepted string
+	paths    []string
+}
+
+func (t *v3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	status, body := http.StatusOK, `{"ok": true}`
+	if req.URL.Path == "/v3/auth/tokens" {
+		t.attempts++
+		if t.down {
+			status, body = http.StatusServiceUnavailable, `{"error": {"code": 503}}`
+		} else {
+			expires := t.expires
+			if expires == "" {
+				expires = "2099-01-01T00:00:00.000000Z"
+			}
+			t.issued++
+			t.accepted = fmt.Sprintf("token-%d", t.issued)
+			header.Set(v2identity.SubjectTokenHeader, t.accepted)
+			status, body = http.StatusCreated, fmt.Sprintf(`{"token": {"expires_at": %q, "project": {"id": "p1"}}}`, expires)
+		}
+	} else {
+		t.paths = append(t.paths, req.URL.Path)
+		if req.Header.Get("X-Auth-Token") != t.accepted {
+			status, body = http.StatusUnauthorized, `{"unauthorized": {"code": 401}}`
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestIdentityMiddlewareWithV3(t *testing.T) {
+	transport := &v3Transport{}
+	client := &http.Client{Transport: transport}
+	id := v2identity.NewV3Identity("https://keystone.example.com/v3", v2identity.V3Credentials{UserId: "u1", Password: "pw", ProjectId: "p1"})
+
+	auth := MakeKeystoneIdentityMiddleware(id)
+	auth.UseClient(client)
+	c := gorax.MakeRestClient("https://service.example.com/v1", gorax.WithHTTPClient(client))
+	c.RequestMiddlewares = []gorax.RequestMiddleware{auth}
+	c.RoundTripMiddlewares = []gorax.RoundTripMiddleware{auth}
+
+	if err := getThing(c); err != nil {
+		t.Error(err)
+		return
+	}
+	transport.lock.Lock()
+	transport.accepted = ""
+	transport.lock.Unlock()
+	if err := getThing(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if transport.issued != 2 {
+		t.Error("Expected one authentication, and one more after the token was rejected; got", transport.issued)
+		return
+	}
+	for _, path := range transport.paths {
+		if path != "/v1/p1/thing" {
+			t.Error("Expected requests beneath the project's path; got", path)
+			return
+		}
+	}
+}
+
+func TestIdentityMiddlewareBacksOffFailedRenewal(t *testing.T) {
+	transport := &v3Transport{expires: time.Now().Add(time.Minute).UTC().Format(time.RFC3339)}
+	client := &http.Client{Transport: transport}
+	id := v2identity.NewV3Identity("https://keystone.example.com/v3", v2identity.V3Credentials{UserId: "u1", Password: "pw", ProjectId: "p1"})
+
+	auth := MakeKeystoneIdentityMiddleware(id)
+	auth.UseClient(client)
+	c := gorax.MakeRestClient("https://service.example.com/v1", gorax.WithHTTPClient(client))
+	c.RequestMiddlewares = []gorax.RequestMiddleware{auth}
+	c.RoundTripMiddlewares = []gorax.RoundTripMiddleware{auth}
+
+	if err := getThing(c); err != nil {
+		t.Error(err)
+		return
+	}
+	transport.lock.Lock()
+	transport.down = true
+	attempts := transport.attempts
+	transport.lock.Unlock()
+
+	for n := 0; n < 3; n++ {
+		if err := getThing(c); err != nil {
+			t.Error("Expected the old token to remain in use; got", err)
+			return
+		}
+	}
+	if transport.attempts != attempts+1 {
+		t.Error("Expected a failed renewal not to be retried at once; Keystone saw", transport.attempts-attempts, "requests")
+		return
+	}
+}
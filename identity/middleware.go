@@ -17,11 +17,15 @@ limitations under the License.
 package identity
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"sync"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/racker/gorax"
+	v2identity "github.com/racker/gorax/v2.0/identity"
 )
 
 var (
@@ -37,18 +41,22 @@ type KeystoneAuthMiddleware struct {
 	tenantId       string
 	token          string
 	expires        time.Time
+	catalog        []CatalogEntry
+	defaultRegion  string
 	keystoneClient *KeystoneClient
-	refreshLock    sync.Mutex
+	tokenStore     gorax.TokenStore
+	refreshLock    chan struct{}
 }
 
 // MakeKeystonePasswordMiddleware creates a middleware request object to the API to use the Keystone authentication interface.
 // A side-effect of this function is the creation of a Keystone client interface object in debug-mode.
 // This procedure assumes username/password authentication.
-func MakeKeystonePasswordMiddleware(region string, username string, password string) *KeystoneAuthMiddleware {
+// Any options given configure how the middleware reaches Keystone; see gorax.ClientOption.
+func MakeKeystonePasswordMiddleware(region string, username string, password string, opts ...gorax.ClientOption) *KeystoneAuthMiddleware {
 	m := &KeystoneAuthMiddleware{
-		keystoneClient: MakePasswordKeystoneClient(region, username, password),
+		keystoneClient: MakePasswordKeystoneClient(region, username, password, opts...),
 		expires:        time.Time{},
-		refreshLock:    sync.Mutex{},
+		refreshLock:    make(chan struct{}, 1),
 	}
 	m.keystoneClient.SetDebug(false)
 	return m
@@ -57,16 +65,42 @@ func MakeKeystonePasswordMiddleware(region string, username string, password str
 // MakeKeystoneAPIKeyMiddleware creates a middleware request object to the API to use the Keystone authentication interface.
 // A side-effect of this function is the creation of a Keystone client interface object in debug-mode.
 // This procedure assumes you already have a valid API key for the principal making the requests.
-func MakeKeystoneAPIKeyMiddleware(region string, username string, apiKey string) *KeystoneAuthMiddleware {
+// Any options given configure how the middleware reaches Keystone; see gorax.ClientOption.
+func MakeKeystoneAPIKeyMiddleware(region string, username string, apiKey string, opts ...gorax.ClientOption) *KeystoneAuthMiddleware {
 	m := &KeystoneAuthMiddleware{
-		keystoneClient: MakeAPIKeyKeystoneClient(region, username, apiKey),
+		keystoneClient: MakeAPIKeyKeystoneClient(region, username, apiKey, opts...),
 		expires:        time.Time{},
-		refreshLock:    sync.Mutex{},
+		refreshLock:    make(chan struct{}, 1),
 	}
 	m.keystoneClient.SetDebug(false)
 	return m
 }
 
+// UseClient() configures the middleware to authenticate with Keystone through a specific net/http client.
+func (m *KeystoneAuthMiddleware) UseClient(client *http.Client) {
+	m.keystoneClient.UseClient(client)
+}
+
+// SetLogger() reports the middleware's own authentication exchanges with Keystone to the given logger.
+func (m *KeystoneAuthMiddleware) SetLogger(logger gorax.Logger, level gorax.LogLevel) {
+	m.keystoneClient.SetLogger(logger, level)
+}
+
+// AddObserver() reports the middleware's own authentication requests to the given observer.
+func (m *KeystoneAuthMiddleware) AddObserver(observer gorax.Observer) {
+	m.keystoneClient.AddObserver(observer)
+}
+
+// UseTokenStore() makes the middleware share its tokens through the given store; see gorax.TokenStore.
+// Before asking Keystone for a token, the middleware looks for one another client has stored for the same auth URL and username,
+// provided it remains valid for longer than ExpireDelta; tokens it obtains from Keystone are stored in turn.
+// A stored token the server rejects is deleted from the store.
+func (m *KeystoneAuthMiddleware) UseTokenStore(store gorax.TokenStore) {
+	m.refreshLock <- struct{}{}
+	defer func() { <-m.refreshLock }()
+	m.tokenStore = store
+}
+
 // This HandleRequest method performs user authentication against a Keystone REST API.
 //
 // If the request has timed out (e.g., as by exceeding its expiry timeout), it returns an error out of hand.  No attempt to use REST resources occurs.
@@ -74,33 +108,177 @@ func MakeKeystoneAPIKeyMiddleware(region string, username string, apiKey string)
 // the life of the request.  Additionally, the request receives an X-Auth-Token MIME header appropriate for the principal making the request, and the
 // resource path is "redirected" to that appropriate for the principal's unique set of resources.
 func (m *KeystoneAuthMiddleware) HandleRequest(req *gorax.RestRequest) (*gorax.RestRequest, error) {
-	m.refreshLock.Lock()
-	defer m.refreshLock.Unlock()
+	return m.HandleRequestWithContext(context.Background(), req)
+}
+
+// HandleRequestWithContext behaves like HandleRequest, but gives up if the context ends while waiting on the refresh lock or on Keystone itself.
+// Only one goroutine re-authenticates at a time; the others wait for it to finish, or for their own contexts to end, whichever comes first.
+func (m *KeystoneAuthMiddleware) HandleRequestWithContext(ctx context.Context, req *gorax.RestRequest) (*gorax.RestRequest, error) {
+	ctx = correlated(ctx, req)
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.unlock()
 
 	if time.Now().Add(ExpireDelta).After(m.expires) {
-		result, err := m.keystoneClient.Authenticate()
-		if err != nil {
+		if err := m.authenticate(ctx, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	req.Header.Set("X-Auth-Token", m.token)
+	req.Path = "/" + m.tenantId + req.Path
+
+	return req, nil
+}
+
+// HandleRoundTrip recovers from tokens which Keystone revokes, or which expire sooner than the middleware's clock suggests.
+// When the server rejects a request with 401 Unauthorized, the middleware discards the rejected token, re-authenticates, and replays the request once.
+// Should the replay be rejected as well, should re-authentication fail, or should Keystone hand back the very token just rejected,
+// the 401 error is returned as it stands.
+//
+// Requests rejected with the same token share a single re-authentication:
+// those which find the token already replaced by the time they acquire the refresh lock simply replay with the new one.
+// For this to work, the middleware must appear among the client's RoundTripMiddlewares as well as its RequestMiddlewares.
+func (m *KeystoneAuthMiddleware) HandleRoundTrip(ctx context.Context, req *gorax.RestRequest, next gorax.RoundTripFunc) (*gorax.RestResponse, error) {
+	resp, err := next(ctx, req)
+	if !gorax.IsUnauthorized(err) {
+		return resp, err
+	}
+
+	rejected := req.Header.Get("X-Auth-Token")
+	if rejected == "" {
+		return resp, err
+	}
+
+	ctx = correlated(ctx, req)
+	if lerr := m.lock(ctx); lerr != nil {
+		return resp, err
+	}
+	tenantId := m.tenantId
+	if m.token == rejected {
+		m.expires = time.Time{}
+		if aerr := m.authenticate(ctx, rejected); aerr != nil {
+			m.unlock()
+			return resp, err
+		}
+	}
+	token, path := m.token, "/"+m.tenantId+strings.TrimPrefix(req.Path, "/"+tenantId)
+	m.unlock()
+
+	if token == rejected {
+		return resp, err
+	}
+
+	replay := *req
+	replay.Header = req.Header.Clone()
+	replay.Header.Set("X-Auth-Token", token)
+	replay.Path = path
+	return next(ctx, &replay)
+}
+
+// ResolveEndpoint finds an endpoint in the authenticated user's service catalog, authenticating first if need be.
+// Unless opts names a DefaultRegion, the user's default region applies; see v2identity.Catalog.Resolve().
+func (m *KeystoneAuthMiddleware) ResolveEndpoint(ctx context.Context, opts v2identity.EndpointOpts) (*v2identity.ResolvedEndpoint, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.unlock()
+
+	if time.Now().Add(ExpireDelta).After(m.expires) {
+		if err := m.authenticate(ctx, ""); err != nil {
 			return nil, err
 		}
+	}
+	if opts.DefaultRegion == "" {
+		opts.DefaultRegion = m.defaultRegion
+	}
+	return v2identity.Catalog(m.catalog).Resolve(opts)
+}
+
+// correlated yields ctx carrying the request's correlation ID, if it has one, so that any token request it prompts carries the same ID.
+// For requests to have an ID by now, a gorax.CorrelationMiddleware must precede the Keystone middleware.
+func correlated(ctx context.Context, req *gorax.RestRequest) context.Context {
+	if id := req.Header.Get(gorax.CorrelationIdHeader); id != "" {
+		return gorax.WithCorrelationId(ctx, id)
+	}
+	return ctx
+}
+
+// lock acquires the middleware's refresh lock, unless the context ends first.
+func (m *KeystoneAuthMiddleware) lock(ctx context.Context) error {
+	select {
+	case m.refreshLock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unlock releases the middleware's refresh lock.
+func (m *KeystoneAuthMiddleware) unlock() {
+	<-m.refreshLock
+}
+
+// authenticate obtains a fresh token, from the token store if it holds one, or else from Keystone.
+// A stored token matching the rejected one is deleted rather than used.
+// The caller must hold the refresh lock.
+func (m *KeystoneAuthMiddleware) authenticate(ctx context.Context, rejected string) error {
+	result, expires := m.storedToken(rejected)
+	if result == nil {
+		var err error
 
-		var expires time.Time
+		result, err = m.keystoneClient.AuthenticateWithContext(ctx)
+		if err != nil {
+			return err
+		}
 
 		expires, err = time.Parse(USExpiresFormat, result.Access.Token.Expires)
 
 		if err != nil {
 			expires, err = time.Parse(UKExpiresFormat, result.Access.Token.Expires)
 			if err != nil {
-				return nil, fmt.Errorf("unable to parse token expiration time: %s", result.Access.Token.Expires)
+				return fmt.Errorf("unable to parse token expiration time: %s", result.Access.Token.Expires)
 			}
 		}
 
-		m.tenantId = result.Access.Token.Tenant.Id
-		m.token = result.Access.Token.Id
-		m.expires = expires
+		if m.tokenStore != nil {
+			if data, err := json.Marshal(result); err == nil {
+				m.tokenStore.Set(m.keystoneClient.client.BaseUrl, m.keystoneClient.username, &gorax.StoredToken{
+					Response: data,
+					Expires:  expires,
+				})
+			}
+		}
 	}
 
-	req.Header.Set("X-Auth-Token", m.token)
-	req.Path = "/" + m.tenantId + req.Path
+	m.tenantId = result.Access.Token.Tenant.Id
+	m.token = result.Access.Token.Id
+	m.expires = expires
+	m.catalog = result.Access.ServiceCatalog
+	m.defaultRegion = result.Access.User.ExRaxDefaultRegion
+	return nil
+}
 
-	return req, nil
+// storedToken yields the token store's response for the middleware's user, if it has one valid for longer than ExpireDelta.
+func (m *KeystoneAuthMiddleware) storedToken(rejected string) (*AuthResponse, time.Time) {
+	if m.tokenStore == nil {
+		return nil, time.Time{}
+	}
+
+	url, username := m.keystoneClient.client.BaseUrl, m.keystoneClient.username
+	stored, ok := m.tokenStore.Get(url, username)
+	if !ok || time.Now().Add(ExpireDelta).After(stored.Expires) {
+		return nil, time.Time{}
+	}
+
+	result := &AuthResponse{}
+	if err := json.Unmarshal(stored.Response, result); err != nil {
+		return nil, time.Time{}
+	}
+	if result.Access.Token.Id == rejected {
+		m.tokenStore.Delete(url, username)
+		return nil, time.Time{}
+	}
+	return result, stored.Expires
 }
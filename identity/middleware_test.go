@@ -0,0 +1,261 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identity
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/racker/gorax"
+	v2identity "github.com/racker/gorax/v2.0/identity"
+)
+
+// keystoneTransport plays both Keystone and a service which accepts only the most recently issued token.
+// Each authentication issues a new token; revoke() makes the service reject the current one.
+// The correlation ID of every request, whether of Keystone or the service, is recorded in turn.
+// While down is set, Keystone fails with 503 Service Unavailable.
+type keystoneTransport struct {
+	lock         sync.Mutex
+	down         bool
+	issued       int
+	accepted     string
+	paths        []string
+	correlations []string
+}
+
+func (t *keystoneTransport) revoke() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.accepted = ""
+}
+
+func (t *keystoneTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.correlations = append(t.correlations, req.Header.Get(gorax.CorrelationIdHeader))
+	status, body := http.StatusOK, `{"ok": true}`
+	if req.URL.Path == "/v2.0/tokens" && t.down {
+		status, body = http.StatusServiceUnavailable, `{"serviceUnavailable": {"code": 503, "message": "Down for maintenance"}}`
+	} else if req.URL.Path == "/v2.0/tokens" {
+		t.issued++
+		t.accepted = fmt.Sprintf("token-%d", t.issued)
+		body = fmt.Sprintf(`{"access": {"token": {"id": %q, "expires": "2099-01-01T00:00:00.000Z", "tenant": {"id": "12345"}}}}`, t.accepted)
+	} else {
+		t.paths = append(t.paths, req.URL.Path)
+		if req.Header.Get("X-Auth-Token") != t.accepted {
+			status, body = http.StatusUnauthorized, `{"unauthorized": {"code": 401, "message": "Token revoked"}}`
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func makeTestClient(transport *keystoneTransport) *gorax.RestClient {
+	client := &http.Client{Transport: transport}
+	keystone := MakeKeystonePasswordMiddleware("https://identity.example.com/v2.0", "user", "pass", gorax.WithHTTPClient(client))
+	c := gorax.MakeRestClient("https://service.example.com/v1", gorax.WithHTTPClient(client))
+	c.RequestMiddlewares = []gorax.RequestMiddleware{keystone}
+	c.RoundTripMiddlewares = []gorax.RoundTripMiddleware{keystone}
+	return c
+}
+
+func getThing(c *gorax.RestClient) error {
+	resp, err := c.PerformRequest(&gorax.RestRequest{
+		Method:              "GET",
+		Path:                "/thing",
+		ExpectedStatusCodes: []int{http.StatusOK},
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestKeystoneSharesCorrelationId(t *testing.T) {
+	transport := &keystoneTransport{}
+	c := makeTestClient(transport)
+	c.RequestMiddlewares = append([]gorax.RequestMiddleware{gorax.NewCorrelationMiddleware()}, c.RequestMiddlewares...)
+
+	if err := getThing(c); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(transport.correlations) != 2 || transport.correlations[0] == "" || transport.correlations[0] != transport.correlations[1] {
+		t.Error("Expected the token request to share the service request's correlation ID; got", transport.correlations)
+		return
+	}
+}
+
+func TestKeystoneReauthenticatesOnUnauthorized(t *testing.T) {
+	transport := &keystoneTransport{}
+	c := makeTestClient(transport)
+
+	if err := getThing(c); err != nil {
+		t.Error(err)
+		return
+	}
+	transport.revoke()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- getThing(c)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if transport.issued != 2 {
+		t.Error("Expected one re-authentication for all rejected requests; got", transport.issued-1)
+		return
+	}
+	for _, path := range transport.paths {
+		if path != "/v1/12345/thing" {
+			t.Error("Expected replayed requests to keep their tenant path; got", path)
+			return
+		}
+	}
+}
+
+func TestKeystoneReplaysOnlyOnce(t *testing.T) {
+	transport := &keystoneTransport{}
+	c := makeTestClient(transport)
+
+	c.RoundTripMiddlewares = append(c.RoundTripMiddlewares, gorax.RoundTripMiddlewareFunc(
+		func(ctx context.Context, req *gorax.RestRequest, next gorax.RoundTripFunc) (*gorax.RestResponse, error) {
+			transport.revoke()
+			return next(ctx, req)
+		}))
+
+	err := getThing(c)
+	if !gorax.IsUnauthorized(err) {
+		t.Error("Expected a persistently rejected request to yield 401; got", err)
+		return
+	}
+	if len(transport.paths) != 2 {
+		t.Error("Expected a single replay; server saw", len(transport.paths), "requests")
+		return
+	}
+}
+
+func TestKeystoneKeepsRejectionWhenReauthenticationFails(t *testing.T) {
+	transport := &keystoneTransport{}
+	c := makeTestClient(transport)
+
+	if err := getThing(c); err != nil {
+		t.Error(err)
+		return
+	}
+	transport.revoke()
+	transport.down = true
+
+	err := getThing(c)
+	if !gorax.IsUnauthorized(err) {
+		t.Error("Expected the server's 401 to stand when Keystone is down; got", err)
+		return
+	}
+}
+
+func TestKeystoneSharesTokensThroughStore(t *testing.T) {
+	transport := &keystoneTransport{}
+	store := gorax.NewMemoryTokenStore()
+	first, second := makeTestClient(transport), makeTestClient(transport)
+	first.RequestMiddlewares[0].(*KeystoneAuthMiddleware).UseTokenStore(store)
+	second.RequestMiddlewares[0].(*KeystoneAuthMiddleware).UseTokenStore(store)
+
+	if err := getThing(first); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := getThing(second); err != nil {
+		t.Error(err)
+		return
+	}
+	if transport.issued != 1 {
+		t.Error("Expected the second client to reuse the stored token; Keystone issued", transport.issued)
+		return
+	}
+
+	transport.revoke()
+	if err := getThing(second); err != nil {
+		t.Error(err)
+		return
+	}
+	if transport.issued != 2 {
+		t.Error("Expected a rejected stored token to be replaced; Keystone issued", transport.issued)
+		return
+	}
+	stored, ok := store.Get("https://identity.example.com/v2.0", "user")
+	if !ok || !strings.Contains(string(stored.Response), "token-2") {
+		t.Error("Expected the replacement token to be stored; got", stored, ok)
+		return
+	}
+}
+
+func TestKeystoneResolveEndpoint(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"access": {
+			"token": {"id": "t1", "expires": "2099-01-01T00:00:00.000Z", "tenant": {"id": "12345"}},
+			"user": {"RAX-AUTH:defaultRegion": "DFW"},
+			"serviceCatalog": [{"name": "cloudServersOpenStack", "type": "compute", "endpoints": [
+				{"region": "DFW", "publicURL": "https://dfw.servers.example.com/v2/12345", "internalURL": "https://snet-dfw.servers.example.com/v2/12345"},
+				{"region": "ORD", "publicURL": "https://ord.servers.example.com/v2/12345"}]}]}}`
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})}
+	m := MakeKeystoneAPIKeyMiddleware("https://identity.example.com/v2.0", "user", "key", gorax.WithHTTPClient(client))
+
+	ep, err := m.ResolveEndpoint(context.Background(), v2identity.EndpointOpts{Type: "compute", Interface: v2identity.InternalInterface})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if ep.URL != "https://snet-dfw.servers.example.com/v2/12345" {
+		t.Error("Expected the ServiceNet URL in the user's default region; got", ep.URL)
+		return
+	}
+}
+
+// roundTripFunc adapts a function into a net/http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
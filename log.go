@@ -0,0 +1,387 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A LogLevel selects how much detail a LoggingMiddleware records about each exchange.
+type LogLevel int
+
+const (
+	// LogNone disables logging altogether.
+	LogNone LogLevel = iota
+	// LogSummary records the method, URL, status, latency and sizes of each exchange.
+	LogSummary
+	// LogHeaders additionally records request and response headers.
+	LogHeaders
+	// LogBodies additionally records request and response bodies.
+	LogBodies
+)
+
+// maxLoggedBodySize bounds how much of any one body is recorded at LogBodies verbosity.
+const maxLoggedBodySize = 64 * 1024
+
+// Redacted replaces the value of every credential a LogRecord would otherwise reveal.
+const Redacted = "REDACTED"
+
+// A LogRecord describes a single exchange with a server.
+//
+// Method, URL and Status identify the exchange; Status is zero if no response arrived, in which case Err explains why.
+// Latency measures the time from sending the request until the response headers arrived.
+// RequestSize and ResponseSize hold the body lengths in bytes, or -1 where unknown.
+//
+// The header and body fields are only populated at the LogHeaders and LogBodies levels respectively.
+// Credentials, such as authentication tokens, passwords, API keys and administrative passwords, are always redacted
+// before the record reaches a Logger.
+type LogRecord struct {
+	Time           time.Time
+	Method         string
+	URL            string
+	Status         int
+	Latency        time.Duration
+	RequestSize    int64
+	ResponseSize   int64
+	RequestHeader  http.Header
+	ResponseHeader http.Header
+	RequestBody    string
+	ResponseBody   string
+	Err            error
+}
+
+// The Logger interface receives a record of every exchange a RestClient performs.
+// Implementations must be safe for concurrent use, since a RestClient may be shared among goroutines.
+type Logger interface {
+	LogExchange(*LogRecord)
+}
+
+// The LoggerFunc type adapts an ordinary function into a Logger.
+type LoggerFunc func(*LogRecord)
+
+// LogExchange calls f(record).
+func (f LoggerFunc) LogExchange(record *LogRecord) {
+	f(record)
+}
+
+// A LoggingMiddleware is a RoundTripMiddleware which reports each exchange passing through it to a Logger.
+// RestClient installs one innermost in its chain whenever its Logger field is set, so that every retried attempt is reported individually.
+type LoggingMiddleware struct {
+	Logger Logger
+	Level  LogLevel
+}
+
+// HandleRoundTrip records the exchange performed by next.
+func (m *LoggingMiddleware) HandleRoundTrip(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error) {
+	if m.Logger == nil || m.Level <= LogNone {
+		return next(ctx, req)
+	}
+
+	record := &LogRecord{
+		Time:         time.Now(),
+		Method:       req.Method,
+		URL:          req.Path,
+		RequestSize:  -1,
+		ResponseSize: -1,
+	}
+
+	if req.Body == nil {
+		record.RequestSize = 0
+	} else if n, err := req.Body.ContentLength(); err == nil {
+		record.RequestSize = n
+	}
+	if m.Level >= LogHeaders {
+		record.RequestHeader = RedactHeader(req.Header)
+	}
+	if m.Level >= LogBodies {
+		if b, ok := req.Body.(bufferedBody); ok {
+			if data, err := b.bytes(); err == nil {
+				contentType, _ := req.Body.ContentType()
+				record.RequestBody = redactBody(contentType, data)
+			}
+		}
+	}
+
+	resp, err := next(ctx, req)
+	record.Latency = time.Since(record.Time)
+	record.Err = err
+
+	if resp != nil {
+		if resp.Request != nil {
+			record.URL = resp.Request.URL.String()
+		}
+		record.Status = resp.StatusCode
+		record.ResponseSize = resp.ContentLength
+		if m.Level >= LogHeaders {
+			record.ResponseHeader = RedactHeader(resp.Header)
+		}
+		if m.Level >= LogBodies {
+			var data []byte
+			data, resp.Body = peekBody(resp.Body)
+			record.ResponseBody = redactBody(resp.Header.Get("Content-Type"), data)
+		}
+	} else if apiErr := AsAPIError(err); apiErr != nil {
+		record.URL = apiErr.URL
+		record.Status = apiErr.StatusCode
+		record.ResponseSize = int64(len(apiErr.Body))
+		if m.Level >= LogHeaders {
+			record.ResponseHeader = RedactHeader(apiErr.Header)
+		}
+		if m.Level >= LogBodies {
+			record.ResponseBody = redactBody(apiErr.Header.Get("Content-Type"), apiErr.Body)
+		}
+	}
+
+	m.Logger.LogExchange(record)
+	return resp, err
+}
+
+// The bufferedBody interface is implemented by request bodies held entirely in memory,
+// whose contents may therefore be logged without disturbing the request.
+type bufferedBody interface {
+	bytes() ([]byte, error)
+}
+
+func (b *JSONRequestBody) bytes() ([]byte, error) {
+	err := b.marshal()
+	return b.data, err
+}
+
+// peekBody() reads up to maxLoggedBodySize bytes from a response body, yielding them along with a replacement body which replays them.
+func peekBody(body io.ReadCloser) ([]byte, io.ReadCloser) {
+	data, _ := ioutil.ReadAll(io.LimitReader(body, maxLoggedBodySize))
+	return data, &replayedBody{io.MultiReader(bytes.NewReader(data), body), body}
+}
+
+type replayedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// sensitiveHeaders lists, in canonical form, headers whose values must never be logged.
+var sensitiveHeaders = map[string]bool{
+	"X-Auth-Token":        true,
+	"X-Subject-Token":     true,
+	"X-Auth-Key":          true,
+	"X-Storage-Token":     true,
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// sensitiveFields lists, in lower case, JSON object keys whose values must never be logged.
+var sensitiveFields = map[string]bool{
+	"password":      true,
+	"apikey":        true,
+	"adminpass":     true,
+	"secret":        true,
+	"token":         true,
+	"refresh_token": true,
+}
+
+// RedactHeader() yields a copy of the header with the values of credential-bearing fields, such as X-Auth-Token, replaced by Redacted.
+func RedactHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	r := h.Clone()
+	for name := range r {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			r[name] = []string{Redacted}
+		}
+	}
+	return r
+}
+
+// redactBody() renders a body for logging, scrubbing credentials from JSON documents.
+// Bodies of other types are reported only by size, as their credentials cannot be located reliably.
+func redactBody(contentType string, data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if (mediaType == "application/json" || mediaType == "") && json.Valid(data) {
+		return string(RedactJSON(data))
+	}
+	if mediaType == "text/plain" || mediaType == "text/html" {
+		return string(data)
+	}
+
+	return fmt.Sprintf("[%d bytes of %s]", len(data), contentType)
+}
+
+// RedactJSON() yields a copy of a JSON document with every credential, such as a password, API key or token, replaced by Redacted.
+// Documents holding no credentials, and data which is not valid JSON, are returned unaltered, byte for byte.
+// Numbers and markup characters in redacted documents are preserved as the server sent them, though object keys are sorted.
+func RedactJSON(data []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return data
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return data
+	}
+
+	doc, changed := redactValue(doc)
+	if !changed {
+		return data
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return data
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// redactValue() redacts credentials within a decoded JSON value, in place, reporting whether it found any.
+func redactValue(v interface{}) (interface{}, bool) {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if !sensitiveFields[strings.ToLower(key)] {
+				var c bool
+				v[key], c = redactValue(value)
+				changed = changed || c
+				continue
+			}
+			changed = true
+			if inner, ok := value.(map[string]interface{}); ok {
+				// Keystone nests the token proper inside a "token" object, as its "id".
+				if _, ok := inner["id"]; ok {
+					inner["id"] = Redacted
+				}
+				v[key], _ = redactValue(inner)
+			} else {
+				v[key] = Redacted
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			var c bool
+			v[i], c = redactValue(v[i])
+			changed = changed || c
+		}
+	}
+	return v, changed
+}
+
+// NewWriterLogger() yields a Logger which writes each record to w as human-readable text: one summary line,
+// followed by indented headers and bodies when present.
+func NewWriterLogger(w io.Writer) Logger {
+	var lock sync.Mutex
+
+	return LoggerFunc(func(r *LogRecord) {
+		buf := &bytes.Buffer{}
+		fmt.Fprintf(buf, "%s %s %s", r.Time.Format(time.RFC3339), r.Method, r.URL)
+		if r.Status != 0 {
+			fmt.Fprintf(buf, " -> %d", r.Status)
+		}
+		fmt.Fprintf(buf, " (%s, %d bytes out, %d bytes in)", r.Latency, r.RequestSize, r.ResponseSize)
+		if r.Err != nil && r.Status == 0 {
+			fmt.Fprintf(buf, ": %s", r.Err)
+		}
+		buf.WriteString("\n")
+		writeHeader(buf, "> ", r.RequestHeader)
+		writeBody(buf, "> ", r.RequestBody)
+		writeHeader(buf, "< ", r.ResponseHeader)
+		writeBody(buf, "< ", r.ResponseBody)
+
+		lock.Lock()
+		defer lock.Unlock()
+		w.Write(buf.Bytes())
+	})
+}
+
+// NewJSONLogger() yields a Logger which writes each record to w as a single line of JSON, suitable for log pipelines.
+func NewJSONLogger(w io.Writer) Logger {
+	var lock sync.Mutex
+
+	return LoggerFunc(func(r *LogRecord) {
+		entry := map[string]interface{}{
+			"time":          r.Time.Format(time.RFC3339Nano),
+			"method":        r.Method,
+			"url":           r.URL,
+			"status":        r.Status,
+			"latency_ms":    float64(r.Latency) / float64(time.Millisecond),
+			"request_size":  r.RequestSize,
+			"response_size": r.ResponseSize,
+		}
+		if r.RequestHeader != nil {
+			entry["request_header"] = r.RequestHeader
+		}
+		if r.ResponseHeader != nil {
+			entry["response_header"] = r.ResponseHeader
+		}
+		if r.RequestBody != "" {
+			entry["request_body"] = r.RequestBody
+		}
+		if r.ResponseBody != "" {
+			entry["response_body"] = r.ResponseBody
+		}
+		if r.Err != nil {
+			entry["error"] = r.Err.Error()
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		w.Write(append(line, '\n'))
+	})
+}
+
+func writeHeader(buf *bytes.Buffer, prefix string, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range h[name] {
+			fmt.Fprintf(buf, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+}
+
+func writeBody(buf *bytes.Buffer, prefix string, body string) {
+	if body != "" {
+		fmt.Fprintf(buf, "%s%s\n", prefix, body)
+	}
+}
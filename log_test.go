@@ -0,0 +1,126 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const AUTH_RESPONSE = `{"access": {"token": {"id": "aaaaa-bbbbb-ccccc-dddd", "expires": "2012-04-13T13:15:00.000-05:00"}, "user": {"name": "joe"}}}`
+
+func TestLoggingRedactsCredentials(t *testing.T) {
+	transport := &testTransport{
+		header:   http.Header{"Content-Type": []string{"application/json"}, "X-Subject-Token": []string{"secret-subject"}},
+		response: AUTH_RESPONSE,
+	}
+	c := withTestClient(transport)
+
+	var records []*LogRecord
+	c.SetLogger(LoggerFunc(func(r *LogRecord) { records = append(records, r) }), LogBodies)
+
+	creds := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"RAX-KSKEY:apiKeyCredentials": map[string]string{"username": "joe", "apiKey": "secret-key"},
+		},
+		"server": map[string]string{"name": "web01", "adminPass": "secret-pass"},
+	}
+	req := &RestRequest{
+		Method:              "POST",
+		Path:                "/tokens",
+		Header:              http.Header{"X-Auth-Token": []string{"secret-token"}},
+		Body:                &JSONRequestBody{Object: creds},
+		ExpectedStatusCodes: []int{http.StatusOK},
+	}
+
+	resp, err := c.PerformRequest(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(records) != 1 {
+		t.Error("Expected one log record; got", len(records))
+		return
+	}
+
+	r := records[0]
+	if r.Method != "POST" || r.URL != "http://example.com/v1.0/tokens" || r.Status != 200 {
+		t.Error("Misreported exchange:", r.Method, r.URL, r.Status)
+		return
+	}
+
+	everything := r.RequestBody + r.ResponseBody + r.RequestHeader.Get("X-Auth-Token") + r.ResponseHeader.Get("X-Subject-Token")
+	for _, secret := range []string{"secret-key", "secret-pass", "secret-token", "secret-subject", "aaaaa-bbbbb"} {
+		if strings.Contains(everything, secret) {
+			t.Error("Expected", secret, "to be redacted from", everything)
+			return
+		}
+	}
+	if !strings.Contains(r.RequestBody, "web01") {
+		t.Error("Expected non-sensitive fields to survive redaction:", r.RequestBody)
+		return
+	}
+
+	// Logging must not disturb the caller's view of the response.
+	target := map[string]interface{}{}
+	if err := resp.DeserializeBody(&target); err != nil || target["access"] == nil {
+		t.Error("Expected the response body to remain readable after logging:", err)
+		return
+	}
+}
+
+func TestLoggingSummaryOmitsDetail(t *testing.T) {
+	transport := &testTransport{status: http.StatusNotFound, response: ITEM_NOT_FOUND_FAULT}
+	c := withTestClient(transport)
+
+	buf := &bytes.Buffer{}
+	c.SetLogger(NewWriterLogger(buf), LogSummary)
+
+	c.PerformRequest(&RestRequest{Method: "GET", Path: "/servers/x", Header: http.Header{"X-Auth-Token": []string{"secret-token"}}, ExpectedStatusCodes: []int{http.StatusOK}})
+
+	out := buf.String()
+	if !strings.Contains(out, "GET http://example.com/v1.0/servers/x -> 404") {
+		t.Error("Expected a summary line for the failed request; got", out)
+		return
+	}
+	if strings.Contains(out, "X-Auth-Token") || strings.Contains(out, "itemNotFound") {
+		t.Error("Expected no headers or bodies at summary level; got", out)
+		return
+	}
+}
+
+func TestRedactJSONPreservesDocuments(t *testing.T) {
+	clean := `{"z":1,"id":12345678901234567890,"label":"a<b&c"}`
+	if got := string(RedactJSON([]byte(clean))); got != clean {
+		t.Error("Expected a document without credentials to be returned unaltered; got", got)
+		return
+	}
+
+	got := string(RedactJSON([]byte(`{"z":1,"id":12345678901234567890,"label":"a<b&c","password":"hunter2"}`)))
+	expected := `{"id":12345678901234567890,"label":"a<b&c","password":"` + Redacted + `","z":1}`
+	if got != expected {
+		t.Error("Expected numbers and markup to survive redaction; got", got)
+		return
+	}
+
+	if got := string(RedactJSON([]byte(`{"a":1} {"b":2}`))); got != `{"a":1} {"b":2}` {
+		t.Error("Expected trailing data to leave the input unaltered; got", got)
+		return
+	}
+}
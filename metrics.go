@@ -0,0 +1,220 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLatencyBuckets holds the upper bounds, in seconds, of the latency histogram kept by NewMetricsObserver().
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// A Histogram counts observations into buckets, in the manner of a Prometheus histogram.
+// Counts[i] holds the number of observations no greater than Buckets[i]; Count and Sum cover every observation.
+type Histogram struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+func (h *Histogram) observe(v float64) {
+	for i, bound := range h.Buckets {
+		if v <= bound {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += v
+}
+
+// OperationStats accumulates the metrics a MetricsObserver keeps for one combination of service, operation, region and method.
+//
+// Requests counts completed requests by status code; requests which received no response are counted under code zero.
+// Retries counts attempts beyond the first, and BytesSent and BytesReceived total those body lengths which were known.
+// Latency records, in seconds, the time each request took, retries included.
+type OperationStats struct {
+	Service       string
+	Operation     string
+	Region        string
+	Method        string
+	Requests      map[int]uint64
+	Retries       uint64
+	BytesSent     uint64
+	BytesReceived uint64
+	Latency       Histogram
+}
+
+// A MetricsObserver is an Observer which keeps Prometheus-style counters and latency histograms in memory.
+// Use Stats() to inspect them, or WriteTo() to expose them in the Prometheus text format, e.g., from an HTTP handler.
+type MetricsObserver struct {
+	buckets []float64
+	stats   map[operationKey]*OperationStats
+	lock    sync.Mutex
+}
+
+type operationKey struct {
+	service, operation, region, method string
+}
+
+// NewMetricsObserver() creates an observer whose latency histograms use the given bucket bounds, in seconds.
+// Pass nil to use DefaultLatencyBuckets.
+func NewMetricsObserver(buckets []float64) *MetricsObserver {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	return &MetricsObserver{
+		buckets: sorted,
+		stats:   map[operationKey]*OperationStats{},
+	}
+}
+
+// RequestStarted implements the Observer interface.  Metrics are only recorded once requests finish.
+func (m *MetricsObserver) RequestStarted(ctx context.Context, info *RequestInfo, header http.Header) context.Context {
+	return ctx
+}
+
+// RequestFinished records the finished request.
+func (m *MetricsObserver) RequestFinished(ctx context.Context, info *RequestInfo) {
+	key := operationKey{info.Service, info.Operation, info.Region, info.Method}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	s, ok := m.stats[key]
+	if !ok {
+		s = &OperationStats{
+			Service:   info.Service,
+			Operation: info.Operation,
+			Region:    info.Region,
+			Method:    info.Method,
+			Requests:  map[int]uint64{},
+			Latency: Histogram{
+				Buckets: m.buckets,
+				Counts:  make([]uint64, len(m.buckets)),
+			},
+		}
+		m.stats[key] = s
+	}
+
+	s.Requests[info.StatusCode]++
+	s.Retries += uint64(info.Retries)
+	if info.RequestSize > 0 {
+		s.BytesSent += uint64(info.RequestSize)
+	}
+	if info.ResponseSize > 0 {
+		s.BytesReceived += uint64(info.ResponseSize)
+	}
+	s.Latency.observe(info.Latency.Seconds())
+}
+
+// Stats() yields a copy of the metrics recorded so far, ordered by service, operation, region and method.
+func (m *MetricsObserver) Stats() []OperationStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stats := make([]OperationStats, 0, len(m.stats))
+	for _, s := range m.stats {
+		c := *s
+		c.Requests = make(map[int]uint64, len(s.Requests))
+		for code, n := range s.Requests {
+			c.Requests[code] = n
+		}
+		c.Latency.Counts = append([]uint64(nil), s.Latency.Counts...)
+		stats = append(stats, c)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Operation != b.Operation {
+			return a.Operation < b.Operation
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Method < b.Method
+	})
+	return stats
+}
+
+// WriteTo() writes the metrics recorded so far to w in the Prometheus text exposition format.
+// The metrics are named gorax_requests_total, gorax_request_retries_total, gorax_request_bytes_total and gorax_request_duration_seconds.
+func (m *MetricsObserver) WriteTo(w io.Writer) (int64, error) {
+	stats := m.Stats()
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintln(buf, "# HELP gorax_requests_total Requests made to Rackspace APIs, by status code.")
+	fmt.Fprintln(buf, "# TYPE gorax_requests_total counter")
+	for _, s := range stats {
+		codes := make([]int, 0, len(s.Requests))
+		for code := range s.Requests {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(buf, "gorax_requests_total{%s,code=\"%d\"} %d\n", s.labels(), code, s.Requests[code])
+		}
+	}
+
+	fmt.Fprintln(buf, "# HELP gorax_request_retries_total Attempts made beyond the first.")
+	fmt.Fprintln(buf, "# TYPE gorax_request_retries_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(buf, "gorax_request_retries_total{%s} %d\n", s.labels(), s.Retries)
+	}
+
+	fmt.Fprintln(buf, "# HELP gorax_request_bytes_total Body bytes exchanged with Rackspace APIs.")
+	fmt.Fprintln(buf, "# TYPE gorax_request_bytes_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(buf, "gorax_request_bytes_total{%s,direction=\"sent\"} %d\n", s.labels(), s.BytesSent)
+		fmt.Fprintf(buf, "gorax_request_bytes_total{%s,direction=\"received\"} %d\n", s.labels(), s.BytesReceived)
+	}
+
+	fmt.Fprintln(buf, "# HELP gorax_request_duration_seconds Time taken by requests to Rackspace APIs, retries included.")
+	fmt.Fprintln(buf, "# TYPE gorax_request_duration_seconds histogram")
+	for _, s := range stats {
+		for i, bound := range s.Latency.Buckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(buf, "gorax_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", s.labels(), le, s.Latency.Counts[i])
+		}
+		fmt.Fprintf(buf, "gorax_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", s.labels(), s.Latency.Count)
+		fmt.Fprintf(buf, "gorax_request_duration_seconds_sum{%s} %g\n", s.labels(), s.Latency.Sum)
+		fmt.Fprintf(buf, "gorax_request_duration_seconds_count{%s} %d\n", s.labels(), s.Latency.Count)
+	}
+
+	return buf.WriteTo(w)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (s *OperationStats) labels() string {
+	return fmt.Sprintf(`service="%s",operation="%s",region="%s",method="%s"`,
+		labelEscaper.Replace(s.Service), labelEscaper.Replace(s.Operation), labelEscaper.Replace(s.Region), labelEscaper.Replace(s.Method))
+}
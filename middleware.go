@@ -0,0 +1,92 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"net/http"
+)
+
+// A RoundTripFunc carries a request the rest of the way through a RestClient, yielding the server's (possibly transformed) response.
+type RoundTripFunc func(context.Context, *RestRequest) (*RestResponse, error)
+
+// The RoundTripMiddleware interface describes a filter which wraps an entire exchange with the server.
+//
+// HandleRoundTrip() receives the request after all RequestMiddlewares have processed it, along with the function which completes the exchange.
+// A middleware will usually invoke next exactly once and return its results, perhaps after inspecting or altering them.
+// However, it may also invoke next several times (to retry a request, say), or not at all (to answer from a cache, or to fail fast).
+//
+// If next yields an error for an unexpected status code, that error is an *APIError; see AsAPIError().
+// A middleware which discards a successful response must close its body.
+type RoundTripMiddleware interface {
+	HandleRoundTrip(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error)
+}
+
+// The RoundTripMiddlewareFunc type adapts an ordinary function into a RoundTripMiddleware.
+type RoundTripMiddlewareFunc func(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error)
+
+// HandleRoundTrip calls f(ctx, req, next).
+func (f RoundTripMiddlewareFunc) HandleRoundTrip(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error) {
+	return f(ctx, req, next)
+}
+
+// The ResponseMiddleware interface describes a filter which sees every response the server sends, paired with the request which provoked it.
+//
+// HandleResponse() runs before the response's status code is checked against the request's ExpectedStatusCodes,
+// so it sees error responses too, and may even substitute a different response altogether.
+// If it returns an error, the request fails with that error, and the response body is closed on its behalf.
+type ResponseMiddleware interface {
+	HandleResponse(ctx context.Context, req *RestRequest, resp *RestResponse) (*RestResponse, error)
+}
+
+// RequestFilter() adapts a RequestMiddleware into a RoundTripMiddleware, for those occasions where a request filter must run at a
+// particular point in the round-trip chain, such as inside a retry loop.
+// The filter is applied to a copy of the request on every pass, so repeated passes never compound its alterations.
+func RequestFilter(m RequestMiddleware) RoundTripMiddleware {
+	return RoundTripMiddlewareFunc(func(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error) {
+		var err error
+
+		filtered := req.clone()
+		if cm, ok := m.(ContextRequestMiddleware); ok {
+			filtered, err = cm.HandleRequestWithContext(ctx, filtered)
+		} else {
+			filtered, err = m.HandleRequest(filtered)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return next(ctx, filtered)
+	})
+}
+
+// bind() fixes a middleware's next function, yielding a RoundTripFunc suitable as the next function of an outer middleware.
+func bind(m RoundTripMiddleware, next RoundTripFunc) RoundTripFunc {
+	return func(ctx context.Context, req *RestRequest) (*RestResponse, error) {
+		return m.HandleRoundTrip(ctx, req, next)
+	}
+}
+
+// clone() yields a shallow copy of the request whose Header may be altered without affecting the original.
+func (r *RestRequest) clone() *RestRequest {
+	c := *r
+	c.Header = r.Header.Clone()
+	if c.Header == nil {
+		c.Header = http.Header{}
+	}
+	return &c
+}
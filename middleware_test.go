@@ -0,0 +1,127 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// statusRecorder is a ResponseMiddleware which notes every status code it sees.
+type statusRecorder struct {
+	seen []int
+}
+
+func (r *statusRecorder) HandleResponse(ctx context.Context, req *RestRequest, resp *RestResponse) (*RestResponse, error) {
+	r.seen = append(r.seen, resp.StatusCode)
+	return resp, nil
+}
+
+// pathPrefixer is a RequestMiddleware which scopes every request beneath a tenant, as KeystoneAuthMiddleware does.
+type pathPrefixer struct{}
+
+func (p pathPrefixer) HandleRequest(req *RestRequest) (*RestRequest, error) {
+	req.Path = "/tenant" + req.Path
+	return req, nil
+}
+
+func TestResponseMiddlewareSeesFailures(t *testing.T) {
+	transport := &testTransport{status: http.StatusNotFound, response: ITEM_NOT_FOUND_FAULT}
+	c := withTestClient(transport)
+	recorder := &statusRecorder{}
+	c.ResponseMiddlewares = []ResponseMiddleware{recorder}
+
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/entities/x", ExpectedStatusCodes: []int{http.StatusOK}})
+	if !IsNotFound(err) {
+		t.Error("Expected a not-found error; got", err)
+		return
+	}
+	if len(recorder.seen) != 1 || recorder.seen[0] != http.StatusNotFound {
+		t.Error("Expected the response middleware to see the 404; saw", recorder.seen)
+		return
+	}
+}
+
+func TestRoundTripMiddlewareOrdering(t *testing.T) {
+	transport := &testTransport{response: "{}"}
+	c := withTestClient(transport)
+
+	var order []string
+	mark := func(name string) RoundTripMiddleware {
+		return RoundTripMiddlewareFunc(func(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error) {
+			order = append(order, name+">")
+			resp, err := next(ctx, req)
+			order = append(order, "<"+name)
+			return resp, err
+		})
+	}
+	c.RoundTripMiddlewares = []RoundTripMiddleware{mark("outer"), mark("inner")}
+
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []string{"outer>", "inner>", "<inner", "<outer"}
+	for i := range expected {
+		if i >= len(order) || order[i] != expected[i] {
+			t.Error("Expected middlewares to nest as", expected, "got", order)
+			return
+		}
+	}
+}
+
+func TestRoundTripMiddlewareMayAnswer(t *testing.T) {
+	transport := &testTransport{}
+	c := withTestClient(transport)
+	c.RoundTripMiddlewares = []RoundTripMiddleware{
+		RoundTripMiddlewareFunc(func(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error) {
+			return nil, &RestError{ErrorString: "short-circuited"}
+		}),
+	}
+
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+	if err == nil || err.Error() != "short-circuited" {
+		t.Error("Expected the middleware's own answer; got", err)
+		return
+	}
+	if transport.called != 0 {
+		t.Error("Expected no traffic to reach the server")
+		return
+	}
+}
+
+func TestRequestFilterInsideRetries(t *testing.T) {
+	transport := &testTransport{statuses: []int{503, 200}, response: "{}"}
+	c := withTestClient(transport)
+	c.SetRetryPolicy(&RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	c.RoundTripMiddlewares = []RoundTripMiddleware{RequestFilter(pathPrefixer{})}
+
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/entities", ExpectedStatusCodes: []int{http.StatusOK}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, req := range transport.requests {
+		if req.URL.Path != "/v1.0/tenant/entities" {
+			t.Error("Expected each attempt to be filtered exactly once; got", req.URL.Path)
+			return
+		}
+	}
+}
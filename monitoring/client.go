@@ -17,13 +17,17 @@ limitations under the License.
 package monitoring
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/coreos/etcd/third_party/github.com/coreos/go-log/log"
 	"github.com/racker/gorax"
 	"github.com/racker/gorax/identity"
+	v2identity "github.com/racker/gorax/v2.0/identity"
 )
 
 // A MonitoringClient object exists for each outstanding connection to the Rackspace Cloud Monitoring APIs.
@@ -36,14 +40,97 @@ func (m *MonitoringClient) SetDebug(debug bool) {
 	m.client.SetDebug(debug)
 }
 
+// SetLogger() reports every exchange made by the monitoring client to the given logger, at the given level of detail.
+// Middlewares which make requests of their own, such as the Keystone authenticator, log through the same logger.
+func (m *MonitoringClient) SetLogger(logger gorax.Logger, level gorax.LogLevel) {
+	m.client.SetLogger(logger, level)
+	for _, middleware := range m.client.RequestMiddlewares {
+		if l, ok := middleware.(interface {
+			SetLogger(gorax.Logger, gorax.LogLevel)
+		}); ok {
+			l.SetLogger(logger, level)
+		}
+	}
+}
+
+// UseClient() configures the monitoring client to use a specific net/http client, both for its own requests
+// and for those its middlewares make, such as the Keystone authenticator's.
+// Customized transports are useful if extra logging is required, or if you're using unit tests to isolate and verify correct behavior.
+func (m *MonitoringClient) UseClient(client *http.Client) {
+	m.client.UseClient(client)
+	for _, middleware := range m.client.RequestMiddlewares {
+		if u, ok := middleware.(interface {
+			UseClient(*http.Client)
+		}); ok {
+			u.UseClient(client)
+		}
+	}
+}
+
+// UseTokenStore() makes the client's Keystone authenticator share its tokens through the given store,
+// so that other clients, and other processes, authenticating as the same user can reuse them; see gorax.TokenStore.
+func (m *MonitoringClient) UseTokenStore(store gorax.TokenStore) {
+	for _, middleware := range m.client.RequestMiddlewares {
+		if u, ok := middleware.(interface {
+			UseTokenStore(gorax.TokenStore)
+		}); ok {
+			u.UseTokenStore(store)
+		}
+	}
+}
+
+// AddObserver() reports every request made by the monitoring client to the given observer, including those its middlewares make,
+// such as the Keystone authenticator's; see gorax.Observer.
+// Requests are labelled with the "monitoring" service, and operations named after the client's methods, e.g., "monitoring.ListChecks".
+func (m *MonitoringClient) AddObserver(observer gorax.Observer) {
+	m.client.AddObserver(observer)
+	for _, middleware := range m.client.RequestMiddlewares {
+		if o, ok := middleware.(interface {
+			AddObserver(gorax.Observer)
+		}); ok {
+			o.AddObserver(observer)
+		}
+	}
+}
+
+// SetCache() makes the monitoring client retain GET responses, such as those of GetEntity() and ListChecks(), in the given cache,
+// revalidating them with conditional requests.  See gorax.Cache for details; pass nil to stop caching.
+func (m *MonitoringClient) SetCache(cache *gorax.Cache) {
+	m.client.SetCache(cache)
+}
+
+// UseCircuitBreaker() makes the monitoring client fail fast while its endpoint keeps failing; see gorax.CircuitBreaker.
+// Share one breaker among all the workers talking to the same endpoint.  Pass nil to remove a previously installed breaker.
+func (m *MonitoringClient) UseCircuitBreaker(breaker *gorax.CircuitBreaker) {
+	m.client.UseCircuitBreaker(breaker)
+}
+
+// SetRetryPolicy() configures how the monitoring client re-attempts requests that fail for transient reasons, such as rate limiting.
+// See gorax.RetryPolicy for details; pass nil to disable retries.
+func (m *MonitoringClient) SetRetryPolicy(policy *gorax.RetryPolicy) {
+	m.client.SetRetryPolicy(policy)
+}
+
+// SetTimeout() bounds how long any single request made through the monitoring client may take.
+// Use the *WithContext variants of each method for finer-grained, per-call deadlines or cancellation.
+func (m *MonitoringClient) SetTimeout(timeout time.Duration) {
+	m.client.SetTimeout(timeout)
+}
+
 func (m *MonitoringClient) DeleteCheck(enId string, chId string) error {
+	return m.DeleteCheckWithContext(context.Background(), enId, chId)
+}
+
+// DeleteCheckWithContext is like DeleteCheck, but issues its requests under the given context.
+func (m *MonitoringClient) DeleteCheckWithContext(ctx context.Context, enId string, chId string) error {
 	restReq := &gorax.RestRequest{
 		Method:              "DELETE",
 		Path:                fmt.Sprintf("/entities/%s/checks/%s", enId, chId),
 		ExpectedStatusCodes: []int{http.StatusNoContent},
+		Operation:           "monitoring.DeleteCheck",
 	}
 
-	_, err := m.client.PerformRequest(restReq)
+	_, err := m.client.PerformRequestWithContext(ctx, restReq)
 
 	if err != nil {
 		return err
@@ -53,6 +140,11 @@ func (m *MonitoringClient) DeleteCheck(enId string, chId string) error {
 }
 
 func (m *MonitoringClient) UpdateCheck(enId string, chId string, check interface{}) error {
+	return m.UpdateCheckWithContext(context.Background(), enId, chId, check)
+}
+
+// UpdateCheckWithContext is like UpdateCheck, but issues its requests under the given context.
+func (m *MonitoringClient) UpdateCheckWithContext(ctx context.Context, enId string, chId string, check interface{}) error {
 	restReq := &gorax.RestRequest{
 		Method: "PUT",
 		Path:   fmt.Sprintf("/entities/%s/checks/%s", enId, chId),
@@ -60,13 +152,19 @@ func (m *MonitoringClient) UpdateCheck(enId string, chId string, check interface
 			Object: check,
 		},
 		ExpectedStatusCodes: []int{http.StatusNoContent},
+		Operation:           "monitoring.UpdateCheck",
 	}
 
-	_, err := m.client.PerformRequest(restReq)
+	_, err := m.client.PerformRequestWithContext(ctx, restReq)
 	return err
 }
 
 func (m *MonitoringClient) CreateCheck(enId string, check interface{}) (string, error) {
+	return m.CreateCheckWithContext(context.Background(), enId, check)
+}
+
+// CreateCheckWithContext is like CreateCheck, but issues its requests under the given context.
+func (m *MonitoringClient) CreateCheckWithContext(ctx context.Context, enId string, check interface{}) (string, error) {
 	path := fmt.Sprintf("/entities/%s/checks", enId)
 	restReq := &gorax.RestRequest{
 		Method: "POST",
@@ -75,9 +173,10 @@ func (m *MonitoringClient) CreateCheck(enId string, check interface{}) (string,
 			Object: check,
 		},
 		ExpectedStatusCodes: []int{http.StatusCreated},
+		Operation:           "monitoring.CreateCheck",
 	}
 
-	resp, err := m.client.PerformRequest(restReq)
+	resp, err := m.client.PerformRequestWithContext(ctx, restReq)
 	if err != nil {
 		return "", err
 	}
@@ -86,6 +185,11 @@ func (m *MonitoringClient) CreateCheck(enId string, check interface{}) (string,
 }
 
 func (m *MonitoringClient) CreateEntity(label string, agentId string, metadata map[string]string, ipaddresses map[string]string) (url string, err error) {
+	return m.CreateEntityWithContext(context.Background(), label, agentId, metadata, ipaddresses)
+}
+
+// CreateEntityWithContext is like CreateEntity, but issues its requests under the given context.
+func (m *MonitoringClient) CreateEntityWithContext(ctx context.Context, label string, agentId string, metadata map[string]string, ipaddresses map[string]string) (url string, err error) {
 	type entityCreate struct {
 		Label       *string            `json:"label"`
 		AgentId     *string            `json:"agent_id"`
@@ -114,9 +218,10 @@ func (m *MonitoringClient) CreateEntity(label string, agentId string, metadata m
 			Object: postData,
 		},
 		ExpectedStatusCodes: []int{http.StatusCreated},
+		Operation:           "monitoring.CreateEntity",
 	}
 
-	resp, err := m.client.PerformRequest(restReq)
+	resp, err := m.client.PerformRequestWithContext(ctx, restReq)
 	if err != nil {
 		return "", err
 	}
@@ -125,53 +230,39 @@ func (m *MonitoringClient) CreateEntity(label string, agentId string, metadata m
 }
 
 func (m *MonitoringClient) ListEntities() ([]Entity, error) {
-	entities := make([]Entity, 0)
-	var nextMarker *string
-
-	for true {
-		restReq := &gorax.RestRequest{
-			Method:              "GET",
-			Path:                "/entities",
-			ExpectedStatusCodes: []int{http.StatusOK},
-		}
-
-		if nextMarker != nil {
-			restReq.Path += "?marker=" + *nextMarker
-		}
-
-		resp, err := m.client.PerformRequest(restReq)
-
-		if err != nil {
-			return nil, err
-		}
-
-		container := &PaginatedEntityList{}
-		err = resp.DeserializeBody(container)
+	return m.ListEntitiesWithContext(context.Background())
+}
 
-		if err != nil {
-			return nil, err
-		}
+// ListEntitiesWithContext is like ListEntities, but issues its requests under the given context.
+func (m *MonitoringClient) ListEntitiesWithContext(ctx context.Context) ([]Entity, error) {
+	entities := make([]Entity, 0)
 
-		entities = append(entities, container.Values...)
+	it := m.IterateEntities()
+	for it.Next(ctx) {
+		entities = append(entities, it.Entity())
+	}
 
-		if container.Metadata.NextMarker == nil {
-			break
-		} else {
-			nextMarker = container.Metadata.NextMarker
-		}
+	if it.Err() != nil {
+		return nil, it.Err()
 	}
 
 	return entities, nil
 }
 
 func (m *MonitoringClient) GetEntity(entityId string) (*Entity, error) {
+	return m.GetEntityWithContext(context.Background(), entityId)
+}
+
+// GetEntityWithContext is like GetEntity, but issues its requests under the given context.
+func (m *MonitoringClient) GetEntityWithContext(ctx context.Context, entityId string) (*Entity, error) {
 	restReq := &gorax.RestRequest{
 		Method:              "GET",
 		Path:                "/entities/" + entityId,
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "monitoring.GetEntity",
 	}
 
-	resp, err := m.client.PerformRequest(restReq)
+	resp, err := m.client.PerformRequestWithContext(ctx, restReq)
 
 	if err != nil {
 		return nil, err
@@ -183,56 +274,43 @@ func (m *MonitoringClient) GetEntity(entityId string) (*Entity, error) {
 }
 
 // ListChecks() retrieves a list of Check objects configured for a given entity.
-// This function abstracts pagination of the results for you.
+// This function abstracts pagination of the results for you, but holds every check in memory at once;
+// use IterateChecks() to walk large collections incrementally.
 // If successful, the error result will always be nil; otherwise, the Check slice will be nil.
 func (m *MonitoringClient) ListChecks(entityId string) ([]Check, error) {
-	checks := make([]Check, 0)
-	var nextMarker *string
-
-	for {
-		restReq := &gorax.RestRequest{
-			Method:              "GET",
-			Path:                "/entities/" + entityId + "/checks",
-			ExpectedStatusCodes: []int{http.StatusOK},
-		}
-
-		if nextMarker != nil {
-			restReq.Path += "?marker=" + *nextMarker
-		}
-
-		resp, err := m.client.PerformRequest(restReq)
-
-		if err != nil {
-			return nil, err
-		}
-
-		container := &PaginatedCheckList{}
-		err = resp.DeserializeBody(container)
+	return m.ListChecksWithContext(context.Background(), entityId)
+}
 
-		if err != nil {
-			return nil, err
-		}
+// ListChecksWithContext is like ListChecks, but issues its requests under the given context.
+func (m *MonitoringClient) ListChecksWithContext(ctx context.Context, entityId string) ([]Check, error) {
+	checks := make([]Check, 0)
 
-		checks = append(checks, container.Values...)
+	it := m.IterateChecks(entityId)
+	for it.Next(ctx) {
+		checks = append(checks, it.Check())
+	}
 
-		if container.Metadata.NextMarker == nil {
-			break
-		} else {
-			nextMarker = container.Metadata.NextMarker
-		}
+	if it.Err() != nil {
+		return nil, it.Err()
 	}
 
 	return checks, nil
 }
 
 func (m *MonitoringClient) DeleteEntity(entityId string) (*Entity, error) {
+	return m.DeleteEntityWithContext(context.Background(), entityId)
+}
+
+// DeleteEntityWithContext is like DeleteEntity, but issues its requests under the given context.
+func (m *MonitoringClient) DeleteEntityWithContext(ctx context.Context, entityId string) (*Entity, error) {
 	restReq := &gorax.RestRequest{
 		Method:              "DELETE",
 		Path:                "/entities/" + entityId,
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "monitoring.DeleteEntity",
 	}
 
-	_, err := m.client.PerformRequest(restReq)
+	_, err := m.client.PerformRequestWithContext(ctx, restReq)
 
 	if err != nil {
 		return nil, err
@@ -242,6 +320,11 @@ func (m *MonitoringClient) DeleteEntity(entityId string) (*Entity, error) {
 }
 
 func (m *MonitoringClient) HostInfoEntity(entityId string, hostInfoType string) (interface{}, error) {
+	return m.HostInfoEntityWithContext(context.Background(), entityId, hostInfoType)
+}
+
+// HostInfoEntityWithContext is like HostInfoEntity, but issues its requests under the given context.
+func (m *MonitoringClient) HostInfoEntityWithContext(ctx context.Context, entityId string, hostInfoType string) (interface{}, error) {
 	var info interface{}
 
 	switch hostInfoType {
@@ -269,9 +352,10 @@ func (m *MonitoringClient) HostInfoEntity(entityId string, hostInfoType string)
 		Method:              "GET",
 		Path:                path,
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "monitoring.HostInfoEntity",
 	}
 
-	resp, err := m.client.PerformRequest(restReq)
+	resp, err := m.client.PerformRequestWithContext(ctx, restReq)
 	if err != nil {
 		return nil, err
 	}
@@ -281,25 +365,37 @@ func (m *MonitoringClient) HostInfoEntity(entityId string, hostInfoType string)
 }
 
 func (m *MonitoringClient) UpgradeAgent(agentId string) error {
+	return m.UpgradeAgentWithContext(context.Background(), agentId)
+}
+
+// UpgradeAgentWithContext is like UpgradeAgent, but issues its requests under the given context.
+func (m *MonitoringClient) UpgradeAgentWithContext(ctx context.Context, agentId string) error {
 	restReq := &gorax.RestRequest{
 		Method:              "POST",
 		Path:                fmt.Sprintf("/agents/%s/upgrade", agentId),
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "monitoring.UpgradeAgent",
 	}
 
-	_, err := m.client.PerformRequest(restReq)
+	_, err := m.client.PerformRequestWithContext(ctx, restReq)
 
 	return err
 }
 
 func (m *MonitoringClient) AgentList() (interface{}, error) {
+	return m.AgentListWithContext(context.Background())
+}
+
+// AgentListWithContext is like AgentList, but issues its requests under the given context.
+func (m *MonitoringClient) AgentListWithContext(ctx context.Context) (interface{}, error) {
 	restReq := &gorax.RestRequest{
 		Method:              "GET",
 		Path:                "/agents",
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "monitoring.AgentList",
 	}
 
-	resp, err := m.client.PerformRequest(restReq)
+	resp, err := m.client.PerformRequestWithContext(ctx, restReq)
 	if err != nil {
 		return nil, err
 	}
@@ -310,6 +406,11 @@ func (m *MonitoringClient) AgentList() (interface{}, error) {
 }
 
 func (m *MonitoringClient) AgentTargets(entityId string, agentType string) (interface{}, error) {
+	return m.AgentTargetsWithContext(context.Background(), entityId, agentType)
+}
+
+// AgentTargetsWithContext is like AgentTargets, but issues its requests under the given context.
+func (m *MonitoringClient) AgentTargetsWithContext(ctx context.Context, entityId string, agentType string) (interface{}, error) {
 	info := &AgentTarget{}
 
 	path := fmt.Sprintf("/entities/%s/agent/check_types/%s/targets", entityId, agentType)
@@ -317,9 +418,10 @@ func (m *MonitoringClient) AgentTargets(entityId string, agentType string) (inte
 		Method:              "GET",
 		Path:                path,
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "monitoring.AgentTargets",
 	}
 
-	resp, err := m.client.PerformRequest(restReq)
+	resp, err := m.client.PerformRequestWithContext(ctx, restReq)
 	if err != nil {
 		return nil, err
 	}
@@ -329,46 +431,31 @@ func (m *MonitoringClient) AgentTargets(entityId string, agentType string) (inte
 }
 
 func (m *MonitoringClient) AgentTokenList() ([]AgentToken, error) {
-	tokens := make([]AgentToken, 0)
-	var nextMarker *string
-
-	for true {
-		restReq := &gorax.RestRequest{
-			Method:              "GET",
-			Path:                "/agent_tokens",
-			ExpectedStatusCodes: []int{http.StatusOK},
-		}
-
-		if nextMarker != nil {
-			restReq.Path += "?marker=" + *nextMarker
-		}
-
-		resp, err := m.client.PerformRequest(restReq)
-
-		if err != nil {
-			return nil, err
-		}
-
-		container := &PaginatedAgentTokenList{}
-		err = resp.DeserializeBody(container)
+	return m.AgentTokenListWithContext(context.Background())
+}
 
-		if err != nil {
-			return nil, err
-		}
+// AgentTokenListWithContext is like AgentTokenList, but issues its requests under the given context.
+func (m *MonitoringClient) AgentTokenListWithContext(ctx context.Context) ([]AgentToken, error) {
+	tokens := make([]AgentToken, 0)
 
-		tokens = append(tokens, container.Values...)
+	it := m.IterateAgentTokens()
+	for it.Next(ctx) {
+		tokens = append(tokens, it.AgentToken())
+	}
 
-		if container.Metadata.NextMarker == nil {
-			break
-		} else {
-			nextMarker = container.Metadata.NextMarker
-		}
+	if it.Err() != nil {
+		return nil, it.Err()
 	}
 
 	return tokens, nil
 }
 
 func (m *MonitoringClient) AgentHostInfo(agentId string, agentType string) (interface{}, error) {
+	return m.AgentHostInfoWithContext(context.Background(), agentId, agentType)
+}
+
+// AgentHostInfoWithContext is like AgentHostInfo, but issues its requests under the given context.
+func (m *MonitoringClient) AgentHostInfoWithContext(ctx context.Context, agentId string, agentType string) (interface{}, error) {
 	var info interface{}
 
 	switch agentType {
@@ -396,9 +483,10 @@ func (m *MonitoringClient) AgentHostInfo(agentId string, agentType string) (inte
 		Method:              "GET",
 		Path:                path,
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "monitoring.AgentHostInfo",
 	}
 
-	resp, err := m.client.PerformRequest(restReq)
+	resp, err := m.client.PerformRequestWithContext(ctx, restReq)
 	if err != nil {
 		return nil, err
 	}
@@ -408,94 +496,60 @@ func (m *MonitoringClient) AgentHostInfo(agentId string, agentType string) (inte
 }
 
 func (m *MonitoringClient) AgentConnectionsList(agentId string) (interface{}, error) {
-	conns := make([]AgentConnection, 0)
-	var nextMarker *string
-
-	for true {
-		restReq := &gorax.RestRequest{
-			Method:              "GET",
-			Path:                fmt.Sprintf("/agents/%s/connections", agentId),
-			ExpectedStatusCodes: []int{http.StatusOK},
-		}
-
-		if nextMarker != nil {
-			restReq.Path += "?marker=" + *nextMarker
-		}
-
-		resp, err := m.client.PerformRequest(restReq)
-
-		if err != nil {
-			return nil, err
-		}
-
-		container := &PaginatedAgentConnectionList{}
-		err = resp.DeserializeBody(container)
+	return m.AgentConnectionsListWithContext(context.Background(), agentId)
+}
 
-		if err != nil {
-			return nil, err
-		}
+// AgentConnectionsListWithContext is like AgentConnectionsList, but issues its requests under the given context.
+func (m *MonitoringClient) AgentConnectionsListWithContext(ctx context.Context, agentId string) (interface{}, error) {
+	conns := make([]AgentConnection, 0)
 
-		conns = append(conns, container.Values...)
+	it := m.IterateAgentConnections(agentId)
+	for it.Next(ctx) {
+		conns = append(conns, it.AgentConnection())
+	}
 
-		if container.Metadata.NextMarker == nil {
-			break
-		} else {
-			nextMarker = container.Metadata.NextMarker
-		}
+	if it.Err() != nil {
+		return nil, it.Err()
 	}
 
 	return conns, nil
 }
 
 func (m *MonitoringClient) CheckTypeList() (interface{}, error) {
-	types := make([]CheckType, 0)
-	var nextMarker *string
-
-	for true {
-		restReq := &gorax.RestRequest{
-			Method:              "GET",
-			Path:                "/check_types",
-			ExpectedStatusCodes: []int{http.StatusOK},
-		}
-
-		if nextMarker != nil {
-			restReq.Path += "?marker=" + *nextMarker
-		}
-
-		resp, err := m.client.PerformRequest(restReq)
-
-		if err != nil {
-			return nil, err
-		}
-
-		container := &PaginatedCheckTypeList{}
-		err = resp.DeserializeBody(container)
+	return m.CheckTypeListWithContext(context.Background())
+}
 
-		if err != nil {
-			return nil, err
-		}
+// CheckTypeListWithContext is like CheckTypeList, but issues its requests under the given context.
+func (m *MonitoringClient) CheckTypeListWithContext(ctx context.Context) (interface{}, error) {
+	types := make([]CheckType, 0)
 
-		types = append(types, container.Values...)
+	it := m.IterateCheckTypes()
+	for it.Next(ctx) {
+		types = append(types, it.CheckType())
+	}
 
-		if container.Metadata.NextMarker == nil {
-			break
-		} else {
-			nextMarker = container.Metadata.NextMarker
-		}
+	if it.Err() != nil {
+		return nil, it.Err()
 	}
 
 	return types, nil
 }
 
 func (m *MonitoringClient) DeleteAgentToken(id string) error {
+	return m.DeleteAgentTokenWithContext(context.Background(), id)
+}
+
+// DeleteAgentTokenWithContext is like DeleteAgentToken, but issues its requests under the given context.
+func (m *MonitoringClient) DeleteAgentTokenWithContext(ctx context.Context, id string) error {
 	path := fmt.Sprintf("/agent_tokens/%s", id)
 	restReq := &gorax.RestRequest{
 		Method:              "DELETE",
 		Path:                path,
 		ExpectedStatusCodes: []int{http.StatusNoContent},
+		Operation:           "monitoring.DeleteAgentToken",
 	}
 
-	_, err := m.client.PerformRequest(restReq)
+	_, err := m.client.PerformRequestWithContext(ctx, restReq)
 	if err != nil {
 		return err
 	}
@@ -504,47 +558,31 @@ func (m *MonitoringClient) DeleteAgentToken(id string) error {
 }
 
 func (m *MonitoringClient) ListMonitoringZones() (interface{}, error) {
-	zones := make([]MonitoringZone, 0)
-	var nextMarker *string
-
-	for true {
-		restReq := &gorax.RestRequest{
-			Method:              "GET",
-			Path:                "/monitoring_zones",
-			ExpectedStatusCodes: []int{http.StatusOK},
-		}
-
-		if nextMarker != nil {
-			restReq.Path += "?marker=" + *nextMarker
-		}
-
-		resp, err := m.client.PerformRequest(restReq)
-
-		if err != nil {
-			return nil, err
-		}
-
-		container := &PaginatedMonitoringZoneList{}
-		err = resp.DeserializeBody(container)
+	return m.ListMonitoringZonesWithContext(context.Background())
+}
 
-		if err != nil {
-			return nil, err
-		}
+// ListMonitoringZonesWithContext is like ListMonitoringZones, but issues its requests under the given context.
+func (m *MonitoringClient) ListMonitoringZonesWithContext(ctx context.Context) (interface{}, error) {
+	zones := make([]MonitoringZone, 0)
 
-		zones = append(zones, container.Values...)
+	it := m.IterateMonitoringZones()
+	for it.Next(ctx) {
+		zones = append(zones, it.MonitoringZone())
+	}
 
-		if container.Metadata.NextMarker == nil {
-			break
-		} else {
-			nextMarker = container.Metadata.NextMarker
-		}
+	if it.Err() != nil {
+		return nil, it.Err()
 	}
 
 	return zones, nil
-
 }
 
 func (m *MonitoringClient) TracerouteMonitoringZone(mzId string, target string, resolver string) (interface{}, error) {
+	return m.TracerouteMonitoringZoneWithContext(context.Background(), mzId, target, resolver)
+}
+
+// TracerouteMonitoringZoneWithContext is like TracerouteMonitoringZone, but issues its requests under the given context.
+func (m *MonitoringClient) TracerouteMonitoringZoneWithContext(ctx context.Context, mzId string, target string, resolver string) (interface{}, error) {
 	postData := struct {
 		Target         string `json:"target"`
 		TargetResolver string `json:"target_resolver"`
@@ -561,11 +599,12 @@ func (m *MonitoringClient) TracerouteMonitoringZone(mzId string, target string,
 			Object: postData,
 		},
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "monitoring.TracerouteMonitoringZone",
 	}
 
 	route := &MonitoringZoneTraceroute{}
 
-	resp, err := m.client.PerformRequest(restReq)
+	resp, err := m.client.PerformRequestWithContext(ctx, restReq)
 	if err != nil {
 		return nil, err
 	}
@@ -575,83 +614,138 @@ func (m *MonitoringClient) TracerouteMonitoringZone(mzId string, target string,
 }
 
 func (m *MonitoringClient) ListMetrics(enId string, chId string) (interface{}, error) {
-	metrics := make([]Metric, 0)
-	var nextMarker *string
-
-	path := fmt.Sprintf("/entities/%s/checks/%s/metrics", enId, chId)
-
-	for true {
-		restReq := &gorax.RestRequest{
-			Method:              "GET",
-			Path:                path,
-			ExpectedStatusCodes: []int{http.StatusOK},
-		}
-
-		if nextMarker != nil {
-			restReq.Path += "?marker=" + *nextMarker
-		}
-
-		resp, err := m.client.PerformRequest(restReq)
-
-		if err != nil {
-			return nil, err
-		}
-
-		container := &PaginatedMetricList{}
-		err = resp.DeserializeBody(container)
+	return m.ListMetricsWithContext(context.Background(), enId, chId)
+}
 
-		if err != nil {
-			return nil, err
-		}
+// ListMetricsWithContext is like ListMetrics, but issues its requests under the given context.
+func (m *MonitoringClient) ListMetricsWithContext(ctx context.Context, enId string, chId string) (interface{}, error) {
+	metrics := make([]Metric, 0)
 
-		metrics = append(metrics, container.Values...)
+	it := m.IterateMetrics(enId, chId)
+	for it.Next(ctx) {
+		metrics = append(metrics, it.Metric())
+	}
 
-		if container.Metadata.NextMarker == nil {
-			break
-		} else {
-			nextMarker = container.Metadata.NextMarker
-		}
+	if it.Err() != nil {
+		return nil, it.Err()
 	}
 
 	return metrics, nil
 }
 
 func (m *MonitoringClient) ListLimits() (interface{}, error) {
+	return m.ListLimitsWithContext(context.Background())
+}
+
+// ListLimitsWithContext is like ListLimits, but issues its requests under the given context.
+func (m *MonitoringClient) ListLimitsWithContext(ctx context.Context) (interface{}, error) {
 
 	restReq := &gorax.RestRequest{
 		Method:              "GET",
 		Path:                "/limits",
 		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           "monitoring.ListLimits",
 	}
 
 	limit := &Limit{}
 
-	resp, err := m.client.PerformRequest(restReq)
+	resp, err := m.client.PerformRequestWithContext(ctx, restReq)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.DeserializeBody(limit)
 	if err != nil {
 		return nil, err
 	}
-	resp.DeserializeBody(limit)
 
-	return limit, err
+	return limit, nil
 }
 
 // MakePasswordMonitoringClient creates an object representing the monitoring client, with username/password authentication.
-func MakePasswordMonitoringClient(url string, authurl string, username string, password string) *MonitoringClient {
+// Every request the client makes carries a correlation ID, which any Keystone token request it prompts shares; see gorax.CorrelationMiddleware.
+// A request rejected because Keystone revoked the client's token is replayed once with a fresh token; see identity.KeystoneAuthMiddleware.
+// Use gorax.WithResponseMetadata() with the *WithContext methods to learn the request ID of each call, as support staff will ask for it.
+//
+// Any options given configure how the client reaches both the monitoring service and Keystone, e.g., through an egress proxy.
+// See gorax.ClientOption.
+func MakePasswordMonitoringClient(url string, authurl string, username string, password string, opts ...gorax.ClientOption) *MonitoringClient {
 	m := &MonitoringClient{
-		client: gorax.MakeRestClient(url),
+		client: makeMonitoringRestClient(url, opts),
 	}
+	keystone := identity.MakeKeystonePasswordMiddleware(authurl, username, password, opts...)
 	m.client.RequestMiddlewares = []gorax.RequestMiddleware{
-		identity.MakeKeystonePasswordMiddleware(authurl, username, password),
+		gorax.NewCorrelationMiddleware(),
+		keystone,
 	}
+	m.client.RoundTripMiddlewares = []gorax.RoundTripMiddleware{keystone}
 	return m
 }
 
-func MakeAPIKeyMonitoringClient(url string, authurl string, username string, apiKey string) *MonitoringClient {
+// MakeAPIKeyMonitoringClient creates an object representing the monitoring client, with username/API key authentication.
+// It is otherwise like MakePasswordMonitoringClient.
+func MakeAPIKeyMonitoringClient(url string, authurl string, username string, apiKey string, opts ...gorax.ClientOption) *MonitoringClient {
 	m := &MonitoringClient{
-		client: gorax.MakeRestClient(url),
+		client: makeMonitoringRestClient(url, opts),
 	}
+	keystone := identity.MakeKeystoneAPIKeyMiddleware(authurl, username, apiKey, opts...)
 	m.client.RequestMiddlewares = []gorax.RequestMiddleware{
-		identity.MakeKeystoneAPIKeyMiddleware(authurl, username, apiKey),
+		gorax.NewCorrelationMiddleware(),
+		keystone,
 	}
+	m.client.RoundTripMiddlewares = []gorax.RoundTripMiddleware{keystone}
 	return m
 }
+
+// MakeIdentityMonitoringClient creates an object representing the monitoring client, authenticated by any identity of the v2.0/identity package.
+// In particular, it lets the client authenticate against Keystone v3; see identity.MakeKeystoneIdentityMiddleware().
+// The options given configure how the client reaches the monitoring service; configure the identity's own connection separately.
+// The identity's token requests, being its own, carry no correlation ID.
+// It is otherwise like MakePasswordMonitoringClient.
+func MakeIdentityMonitoringClient(url string, id v2identity.Identity, opts ...gorax.ClientOption) *MonitoringClient {
+	m := &MonitoringClient{
+		client: makeMonitoringRestClient(url, opts),
+	}
+	auth := identity.MakeKeystoneIdentityMiddleware(id)
+	m.client.RequestMiddlewares = []gorax.RequestMiddleware{
+		gorax.NewCorrelationMiddleware(),
+		auth,
+	}
+	m.client.RoundTripMiddlewares = []gorax.RoundTripMiddleware{auth}
+	return m
+}
+
+// MonitoringServiceType names Cloud Monitoring in the service catalog.
+const MonitoringServiceType = "rax:monitor"
+
+// MakeCatalogMonitoringClient creates an object representing the monitoring client, authenticated by the given Keystone middleware,
+// and addressing the monitoring endpoint listed in the user's service catalog.
+// This saves hard-coding the monitoring service's URL; the middleware authenticates straight away to learn it.
+//
+//	auth := identity.MakeKeystoneAPIKeyMiddleware(identity.USIdentityService, username, apiKey)
+//	cm, err := monitoring.MakeCatalogMonitoringClient(auth)
+//
+// Any options given configure how the client reaches the monitoring service; the middleware's own options govern Keystone.
+// It is otherwise like MakePasswordMonitoringClient.
+func MakeCatalogMonitoringClient(auth *identity.KeystoneAuthMiddleware, opts ...gorax.ClientOption) (*MonitoringClient, error) {
+	ep, err := auth.ResolveEndpoint(context.Background(), v2identity.EndpointOpts{Type: MonitoringServiceType})
+	if err != nil {
+		return nil, err
+	}
+
+	// The catalog's URL ends in the tenant ID, which the middleware adds to every request's path itself.
+	m := &MonitoringClient{
+		client: makeMonitoringRestClient(strings.TrimSuffix(ep.URL, "/"+ep.Endpoint.TenantId), opts),
+	}
+	m.client.RequestMiddlewares = []gorax.RequestMiddleware{
+		gorax.NewCorrelationMiddleware(),
+		auth,
+	}
+	m.client.RoundTripMiddlewares = []gorax.RoundTripMiddleware{auth}
+	return m, nil
+}
+
+func makeMonitoringRestClient(url string, opts []gorax.ClientOption) *gorax.RestClient {
+	c := gorax.MakeRestClient(url, opts...)
+	c.Service = "monitoring"
+	return c
+}
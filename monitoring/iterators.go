@@ -0,0 +1,153 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"fmt"
+
+	"github.com/racker/gorax"
+)
+
+// nextMarker prefers the explicit next_marker, falling back on the marker embedded in next_href.
+func (m PaginationMetadata) nextMarker() string {
+	if m.NextMarker != nil {
+		return *m.NextMarker
+	}
+	if m.NextHref != nil {
+		return gorax.MarkerFromHref(*m.NextHref)
+	}
+	return ""
+}
+
+func (l *PaginatedEntityList) Len() int               { return len(l.Values) }
+func (l *PaginatedEntityList) Item(i int) interface{} { return l.Values[i] }
+func (l *PaginatedEntityList) NextMarker() string     { return l.Metadata.nextMarker() }
+
+func (l *PaginatedCheckList) Len() int               { return len(l.Values) }
+func (l *PaginatedCheckList) Item(i int) interface{} { return l.Values[i] }
+func (l *PaginatedCheckList) NextMarker() string     { return l.Metadata.nextMarker() }
+
+func (l *PaginatedAgentTokenList) Len() int               { return len(l.Values) }
+func (l *PaginatedAgentTokenList) Item(i int) interface{} { return l.Values[i] }
+func (l *PaginatedAgentTokenList) NextMarker() string     { return l.Metadata.nextMarker() }
+
+func (l *PaginatedAgentConnectionList) Len() int               { return len(l.Values) }
+func (l *PaginatedAgentConnectionList) Item(i int) interface{} { return l.Values[i] }
+func (l *PaginatedAgentConnectionList) NextMarker() string     { return l.Metadata.nextMarker() }
+
+func (l *PaginatedCheckTypeList) Len() int               { return len(l.Values) }
+func (l *PaginatedCheckTypeList) Item(i int) interface{} { return l.Values[i] }
+func (l *PaginatedCheckTypeList) NextMarker() string     { return l.Metadata.nextMarker() }
+
+func (l *PaginatedMonitoringZoneList) Len() int               { return len(l.Values) }
+func (l *PaginatedMonitoringZoneList) Item(i int) interface{} { return l.Values[i] }
+func (l *PaginatedMonitoringZoneList) NextMarker() string     { return l.Metadata.nextMarker() }
+
+func (l *PaginatedMetricList) Len() int               { return len(l.Values) }
+func (l *PaginatedMetricList) Item(i int) interface{} { return l.Values[i] }
+func (l *PaginatedMetricList) NextMarker() string     { return l.Metadata.nextMarker() }
+
+// An EntityIterator lazily walks the account's entities; see IterateEntities().
+type EntityIterator struct{ *gorax.Iterator }
+
+// Entity yields the current entity.
+func (it *EntityIterator) Entity() Entity { return it.Item().(Entity) }
+
+// IterateEntities() yields an iterator over the account's entities, which fetches them a page at a time as iteration proceeds.
+// Use the iterator's Pager() to adjust the page size or resume from an earlier marker before iterating.
+func (m *MonitoringClient) IterateEntities() *EntityIterator {
+	return &EntityIterator{m.iterate("monitoring.ListEntities", "/entities", func() gorax.Page { return &PaginatedEntityList{} })}
+}
+
+// A CheckIterator lazily walks the checks configured on an entity; see IterateChecks().
+type CheckIterator struct{ *gorax.Iterator }
+
+// Check yields the current check.
+func (it *CheckIterator) Check() Check { return it.Item().(Check) }
+
+// IterateChecks() yields an iterator over the checks configured on an entity, which fetches them a page at a time as iteration proceeds.
+func (m *MonitoringClient) IterateChecks(entityId string) *CheckIterator {
+	path := fmt.Sprintf("/entities/%s/checks", entityId)
+	return &CheckIterator{m.iterate("monitoring.ListChecks", path, func() gorax.Page { return &PaginatedCheckList{} })}
+}
+
+// An AgentTokenIterator lazily walks the account's agent tokens; see IterateAgentTokens().
+type AgentTokenIterator struct{ *gorax.Iterator }
+
+// AgentToken yields the current agent token.
+func (it *AgentTokenIterator) AgentToken() AgentToken { return it.Item().(AgentToken) }
+
+// IterateAgentTokens() yields an iterator over the account's agent tokens, which fetches them a page at a time as iteration proceeds.
+func (m *MonitoringClient) IterateAgentTokens() *AgentTokenIterator {
+	return &AgentTokenIterator{m.iterate("monitoring.AgentTokenList", "/agent_tokens", func() gorax.Page { return &PaginatedAgentTokenList{} })}
+}
+
+// An AgentConnectionIterator lazily walks an agent's connections; see IterateAgentConnections().
+type AgentConnectionIterator struct{ *gorax.Iterator }
+
+// AgentConnection yields the current agent connection.
+func (it *AgentConnectionIterator) AgentConnection() AgentConnection {
+	return it.Item().(AgentConnection)
+}
+
+// IterateAgentConnections() yields an iterator over an agent's connections, which fetches them a page at a time as iteration proceeds.
+func (m *MonitoringClient) IterateAgentConnections(agentId string) *AgentConnectionIterator {
+	path := fmt.Sprintf("/agents/%s/connections", agentId)
+	return &AgentConnectionIterator{m.iterate("monitoring.AgentConnectionsList", path, func() gorax.Page { return &PaginatedAgentConnectionList{} })}
+}
+
+// A CheckTypeIterator lazily walks the available check types; see IterateCheckTypes().
+type CheckTypeIterator struct{ *gorax.Iterator }
+
+// CheckType yields the current check type.
+func (it *CheckTypeIterator) CheckType() CheckType { return it.Item().(CheckType) }
+
+// IterateCheckTypes() yields an iterator over the available check types, which fetches them a page at a time as iteration proceeds.
+func (m *MonitoringClient) IterateCheckTypes() *CheckTypeIterator {
+	return &CheckTypeIterator{m.iterate("monitoring.CheckTypeList", "/check_types", func() gorax.Page { return &PaginatedCheckTypeList{} })}
+}
+
+// A MonitoringZoneIterator lazily walks the available monitoring zones; see IterateMonitoringZones().
+type MonitoringZoneIterator struct{ *gorax.Iterator }
+
+// MonitoringZone yields the current monitoring zone.
+func (it *MonitoringZoneIterator) MonitoringZone() MonitoringZone {
+	return it.Item().(MonitoringZone)
+}
+
+// IterateMonitoringZones() yields an iterator over the available monitoring zones, which fetches them a page at a time as iteration proceeds.
+func (m *MonitoringClient) IterateMonitoringZones() *MonitoringZoneIterator {
+	return &MonitoringZoneIterator{m.iterate("monitoring.ListMonitoringZones", "/monitoring_zones", func() gorax.Page { return &PaginatedMonitoringZoneList{} })}
+}
+
+// A MetricIterator lazily walks the metrics a check reports; see IterateMetrics().
+type MetricIterator struct{ *gorax.Iterator }
+
+// Metric yields the current metric.
+func (it *MetricIterator) Metric() Metric { return it.Item().(Metric) }
+
+// IterateMetrics() yields an iterator over the metrics a check reports, which fetches them a page at a time as iteration proceeds.
+func (m *MonitoringClient) IterateMetrics(enId string, chId string) *MetricIterator {
+	path := fmt.Sprintf("/entities/%s/checks/%s/metrics", enId, chId)
+	return &MetricIterator{m.iterate("monitoring.ListMetrics", path, func() gorax.Page { return &PaginatedMetricList{} })}
+}
+
+func (m *MonitoringClient) iterate(operation string, path string, newPage func() gorax.Page) *gorax.Iterator {
+	pager := gorax.NewPager(m.client, path, newPage)
+	pager.SetOperation(operation)
+	return gorax.NewIterator(pager)
+}
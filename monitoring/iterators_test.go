@@ -0,0 +1,90 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/racker/gorax"
+)
+
+// withMonitoringServer runs f against a MonitoringClient whose requests are answered by handler.
+func withMonitoringServer(handler http.HandlerFunc, f func(m *MonitoringClient)) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	f(&MonitoringClient{client: gorax.MakeRestClient(server.URL)})
+}
+
+func TestListChecksFollowsMarkers(t *testing.T) {
+	pages := map[string]string{
+		"":    `{"values": [{"id": "ch1"}, {"id": "ch2"}], "metadata": {"next_marker": "ch3"}}`,
+		"ch3": `{"values": [{"id": "ch3"}], "metadata": {"next_marker": null}}`,
+	}
+
+	withMonitoringServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/entities/en1/checks" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, pages[r.URL.Query().Get("marker")])
+	}, func(m *MonitoringClient) {
+		checks, err := m.ListChecks("en1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(checks) != 3 || checks[2].Id != "ch3" {
+			t.Error("Expected three checks across two pages; got", checks)
+			return
+		}
+	})
+}
+
+func TestIterateEntitiesStopsEarly(t *testing.T) {
+	requests := 0
+
+	withMonitoringServer(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"values": [{"id": "en%d"}], "metadata": {"next_href": "http://x/entities?marker=en%d"}}`, requests, requests+1)
+	}, func(m *MonitoringClient) {
+		it := m.IterateEntities()
+		it.Pager().SetLimit(1)
+		for it.Next(context.Background()) {
+			if it.Entity().Id == "en3" {
+				break
+			}
+		}
+		if it.Err() != nil {
+			t.Error(it.Err())
+			return
+		}
+		if requests != 3 {
+			t.Error("Expected iteration to stop after three pages; made", requests, "requests")
+			return
+		}
+		if it.Marker() != "en3" {
+			t.Error("Expected to be able to resume from en3; got", it.Marker())
+			return
+		}
+	})
+}
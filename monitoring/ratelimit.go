@@ -0,0 +1,150 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/racker/gorax"
+)
+
+// Rate classes under which Cloud Monitoring meters requests, in addition to gorax.GlobalRateClass.
+const (
+	TestCheckRateClass        = "test_check"
+	TestAlarmRateClass        = "test_alarm"
+	TestNotificationRateClass = "test_notification"
+	TracerouteRateClass       = "traceroute"
+)
+
+// ClassifyRequest() assigns a monitoring API request to the rate classes, beyond the global class, which it counts against.
+// It serves as the Classify function of rate limiters built by NewRateLimiter().
+func ClassifyRequest(req *gorax.RestRequest) []string {
+	path := req.Path
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+
+	switch {
+	case strings.HasSuffix(path, "/test-check"), strings.HasSuffix(path, "/test"):
+		return []string{TestCheckRateClass}
+	case strings.HasSuffix(path, "/test-alarm"):
+		return []string{TestAlarmRateClass}
+	case strings.HasSuffix(path, "/test-notification"), strings.HasSuffix(path, "/test-notification-plan"):
+		return []string{TestNotificationRateClass}
+	case strings.HasSuffix(path, "/traceroute"):
+		return []string{TracerouteRateClass}
+	}
+	return nil
+}
+
+// NewRateLimiter() creates a rate limiter which paces each class of monitoring request according to the account's published limits.
+// Obtain the limits from ListLimits().
+func NewRateLimiter(limits *Limit) (*gorax.RateLimiter, error) {
+	l := gorax.NewRateLimiter(ClassifyRequest)
+
+	windows := []struct {
+		class  string
+		limit  int
+		used   int
+		window string
+	}{
+		{gorax.GlobalRateClass, limits.Rate.Global.Limit, limits.Rate.Global.Used, limits.Rate.Global.Window},
+		{TestCheckRateClass, limits.Rate.TestCheck.Limit, limits.Rate.TestCheck.Used, limits.Rate.TestCheck.Window},
+		{TestAlarmRateClass, limits.Rate.TestAlarm.Limit, limits.Rate.TestAlarm.Used, limits.Rate.TestAlarm.Window},
+		{TestNotificationRateClass, limits.Rate.TestNotification.Limit, limits.Rate.TestNotification.Used, limits.Rate.TestNotification.Window},
+		{TracerouteRateClass, limits.Rate.Traceroute.Limit, limits.Rate.Traceroute.Used, limits.Rate.Traceroute.Window},
+	}
+
+	for _, w := range windows {
+		if w.limit <= 0 {
+			continue
+		}
+		window, err := parseWindow(w.window)
+		if err != nil {
+			return nil, err
+		}
+		l.SetLimit(w.class, w.limit, window, w.limit-w.used)
+	}
+
+	return l, nil
+}
+
+// parseWindow() interprets the window descriptions found in the limits resource, such as "24.0 hours" or "1 minute".
+func parseWindow(window string) (time.Duration, error) {
+	fields := strings.Fields(window)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("unrecognized rate limit window: %q", window)
+	}
+
+	n, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("unrecognized rate limit window: %q", window)
+	}
+
+	var unit time.Duration
+	switch strings.TrimSuffix(strings.ToLower(fields[1]), "s") {
+	case "second":
+		unit = time.Second
+	case "minute":
+		unit = time.Minute
+	case "hour":
+		unit = time.Hour
+	case "day":
+		unit = 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("unrecognized rate limit window: %q", window)
+	}
+
+	return time.Duration(n * float64(unit)), nil
+}
+
+// UseRateLimiter() paces all requests made through the monitoring client with the given rate limiter.
+// Share one limiter amongst every client acting on the same account, so that their requests are paced together.
+// Pass nil to remove a previously installed limiter.
+func (m *MonitoringClient) UseRateLimiter(limiter *gorax.RateLimiter) {
+	middlewares := []gorax.RoundTripMiddleware{}
+	for _, middleware := range m.client.RoundTripMiddlewares {
+		if _, ok := middleware.(*gorax.RateLimiter); !ok {
+			middlewares = append(middlewares, middleware)
+		}
+	}
+	if limiter != nil {
+		middlewares = append([]gorax.RoundTripMiddleware{limiter}, middlewares...)
+	}
+	m.client.RoundTripMiddlewares = middlewares
+}
+
+// EnableRateLimiting() fetches the account's published limits, and paces all further requests made through the client accordingly.
+// It yields the installed limiter, so that it may be shared with other clients through UseRateLimiter().
+func (m *MonitoringClient) EnableRateLimiting(ctx context.Context) (*gorax.RateLimiter, error) {
+	limits, err := m.ListLimitsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter, err := NewRateLimiter(limits.(*Limit))
+	if err != nil {
+		return nil, err
+	}
+
+	m.UseRateLimiter(limiter)
+	return limiter, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/racker/gorax"
+)
+
+const LIMITS = `{
+	"resource": {"checks": 10000, "alarms": 10000},
+	"rate": {
+		"global": {"limit": 50000, "used": 1000, "window": "24.0 hours"},
+		"test_check": {"limit": 500, "used": 500, "window": "24.0 hours"},
+		"traceroute": {"limit": 300, "used": 0, "window": "24.0 hours"}
+	}
+}`
+
+func TestNewRateLimiterFromLimits(t *testing.T) {
+	limits := &Limit{}
+	if err := json.Unmarshal([]byte(LIMITS), limits); err != nil {
+		t.Error(err)
+		return
+	}
+
+	limiter, err := NewRateLimiter(limits)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, class := range []string{gorax.GlobalRateClass, TestCheckRateClass, TracerouteRateClass} {
+		if limiter.Bucket(class) == nil {
+			t.Error("Expected a bucket for", class)
+			return
+		}
+	}
+	if limiter.Bucket(TestAlarmRateClass) != nil {
+		t.Error("Expected no bucket for a class without published limits")
+		return
+	}
+}
+
+func TestClassifyRequest(t *testing.T) {
+	cases := map[string]string{
+		"/123/entities/en1/test-check":            TestCheckRateClass,
+		"/123/entities/en1/checks/ch1/test?debug": TestCheckRateClass,
+		"/123/monitoring_zones/mzord/traceroute":  TracerouteRateClass,
+		"/123/entities/en1/test-alarm":            TestAlarmRateClass,
+		"/123/entities/en1/checks":                "",
+	}
+	for path, expected := range cases {
+		classes := ClassifyRequest(&gorax.RestRequest{Path: path})
+		got := ""
+		if len(classes) > 0 {
+			got = classes[0]
+		}
+		if got != expected {
+			t.Error("Expected", path, "to be classed", expected, "got", got)
+			return
+		}
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	cases := map[string]time.Duration{
+		"24.0 hours": 24 * time.Hour,
+		"1 minute":   time.Minute,
+		"0.5 days":   12 * time.Hour,
+	}
+	for window, expected := range cases {
+		got, err := parseWindow(window)
+		if err != nil || got != expected {
+			t.Error("Expected", window, "to parse as", expected, "got", got, err)
+			return
+		}
+	}
+	if _, err := parseWindow("forever"); err == nil {
+		t.Error("Expected an unrecognized window to be rejected")
+		return
+	}
+}
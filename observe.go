@@ -0,0 +1,129 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// A RequestInfo describes one logical request made through a RestClient, for the benefit of Observers.
+//
+// Service, Operation and Region identify the call: Service and Region come from the client, and Operation from the request,
+// e.g., "monitoring", "monitoring.ListChecks" and "".
+// Any of these may be empty if the client or request doesn't say.
+//
+// The remaining fields are filled in as the request progresses.
+// StatusCode is zero if no response arrived, in which case Err explains why.
+// Latency spans every attempt, including any delay between retries, and Retries counts the attempts beyond the first.
+// RequestSize and ResponseSize hold body lengths in bytes, or -1 where unknown.
+type RequestInfo struct {
+	Service      string
+	Operation    string
+	Region       string
+	Method       string
+	URL          string
+	Start        time.Time
+	StatusCode   int
+	Latency      time.Duration
+	Retries      int
+	RequestSize  int64
+	ResponseSize int64
+	Err          error
+}
+
+// The Observer interface is told about every request a RestClient performs, as it starts and again as it finishes.
+// Observers exist to feed metrics and tracing systems; see MetricsObserver and TraceObserver.
+//
+// RequestStarted() is called once the request middlewares have run, before the first attempt is made.
+// It may add headers to the request, which go on a copy of its header, so the caller's RestRequest is left unstamped and may be sent again;
+// it returns the context in which the request proceeds; an observer with nothing to add returns ctx itself.
+// RequestFinished() is called with that same context once the request completes, successfully or not.
+// Implementations must be safe for concurrent use, since a RestClient may be shared among goroutines.
+type Observer interface {
+	RequestStarted(ctx context.Context, info *RequestInfo, header http.Header) context.Context
+	RequestFinished(ctx context.Context, info *RequestInfo)
+}
+
+// AddObserver() arranges for the observer to be told about every request the client subsequently performs.
+func (c *RestClient) AddObserver(observer Observer) {
+	c.Observers = append(c.Observers, observer)
+}
+
+// attemptsKey keys the attempt counter that observe() places in the context of an observed request.
+type attemptsKey struct{}
+
+// countAttempt() notes one more attempt at the request bound to ctx, if it is being observed.
+func countAttempt(ctx context.Context) {
+	if n, ok := ctx.Value(attemptsKey{}).(*int32); ok {
+		atomic.AddInt32(n, 1)
+	}
+}
+
+// observe() performs the request through the client's chain, reporting it to each of the client's observers.
+// Observers finish in the reverse of the order in which they started, so that each one's work nests within its predecessor's.
+func (c *RestClient) observe(ctx context.Context, restReq *RestRequest) (*RestResponse, error) {
+	info := &RequestInfo{
+		Service:      c.Service,
+		Operation:    restReq.Operation,
+		Region:       c.Region,
+		Method:       restReq.Method,
+		URL:          c.BaseUrl + restReq.Path,
+		RequestSize:  -1,
+		ResponseSize: -1,
+	}
+	if restReq.Body == nil {
+		info.RequestSize = 0
+	} else if n, err := restReq.Body.ContentLength(); err == nil {
+		info.RequestSize = n
+	}
+
+	stamped := *restReq
+	stamped.Header = restReq.Header.Clone()
+	restReq = &stamped
+
+	contexts := make([]context.Context, len(c.Observers))
+	for i, observer := range c.Observers {
+		ctx = observer.RequestStarted(ctx, info, restReq.Header)
+		contexts[i] = ctx
+	}
+
+	attempts := new(int32)
+	info.Start = time.Now()
+	resp, err := c.chain()(context.WithValue(ctx, attemptsKey{}, attempts), restReq)
+	info.Latency = time.Since(info.Start)
+	info.Err = err
+	if n := int(atomic.LoadInt32(attempts)); n > 1 {
+		info.Retries = n - 1
+	}
+
+	if resp != nil {
+		info.StatusCode = resp.StatusCode
+		info.ResponseSize = resp.ContentLength
+	} else if apiErr := AsAPIError(err); apiErr != nil {
+		info.StatusCode = apiErr.StatusCode
+		info.ResponseSize = int64(len(apiErr.Body))
+	}
+
+	for i := len(c.Observers) - 1; i >= 0; i-- {
+		c.Observers[i].RequestFinished(contexts[i], info)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type recordingObserver struct {
+	name     string
+	events   *[]string
+	finished []*RequestInfo
+}
+
+func (o *recordingObserver) RequestStarted(ctx context.Context, info *RequestInfo, header http.Header) context.Context {
+	*o.events = append(*o.events, "start "+o.name)
+	return context.WithValue(ctx, markerKey{}, o.name)
+}
+
+func (o *recordingObserver) RequestFinished(ctx context.Context, info *RequestInfo) {
+	*o.events = append(*o.events, "finish "+o.name+" "+ctx.Value(markerKey{}).(string))
+	c := *info
+	o.finished = append(o.finished, &c)
+}
+
+func TestObserversSeeEachRequest(t *testing.T) {
+	transport := &testTransport{statuses: []int{503, 200}, response: `{"ok":true}`}
+	c := withTestClient(transport)
+	c.Service = "monitoring"
+	c.Region = "DFW"
+	c.SetRetryPolicy(testRetryPolicy())
+
+	var events []string
+	first := &recordingObserver{name: "first", events: &events}
+	second := &recordingObserver{name: "second", events: &events}
+	c.AddObserver(first)
+	c.AddObserver(second)
+
+	_, err := c.PerformRequest(&RestRequest{
+		Method:    "PUT",
+		Path:      "/entities/en1",
+		Body:      &BytesRequestBody{Data: []byte("12345")},
+		Operation: "monitoring.UpdateEntity",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := "start first,start second,finish second second,finish first first"
+	if strings.Join(events, ",") != expected {
+		t.Error("Expected observers to nest; got", events)
+		return
+	}
+
+	info := second.finished[0]
+	if info.Service != "monitoring" || info.Operation != "monitoring.UpdateEntity" || info.Region != "DFW" {
+		t.Error("Expected the request to be labelled; got", info)
+		return
+	}
+	if info.StatusCode != 200 || info.Retries != 1 || info.RequestSize != 5 || info.Err != nil {
+		t.Error("Expected one retry ending in success; got", info)
+		return
+	}
+	if info.URL != "http://example.com/v1.0/entities/en1" || info.Method != "PUT" {
+		t.Error("Expected the request's method and URL; got", info.Method, info.URL)
+		return
+	}
+}
+
+func TestObserverSeesAPIErrors(t *testing.T) {
+	transport := &testTransport{status: 404, response: ITEM_NOT_FOUND_FAULT}
+	c := withTestClient(transport)
+
+	var events []string
+	o := &recordingObserver{name: "o", events: &events}
+	c.AddObserver(o)
+
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/x", ExpectedStatusCodes: []int{200}})
+	if !IsNotFound(err) {
+		t.Error("Expected a not-found error; got", err)
+		return
+	}
+	info := o.finished[0]
+	if info.StatusCode != 404 || info.Err != err || info.ResponseSize != int64(len(ITEM_NOT_FOUND_FAULT)) || info.Retries != 0 {
+		t.Error("Expected the fault to be reported; got", info)
+		return
+	}
+}
+
+func TestMetricsObserver(t *testing.T) {
+	transport := &testTransport{statuses: []int{200, 200, 404}, response: "{}"}
+	c := withTestClient(transport)
+	c.Service = "servers"
+	c.Region = "ORD"
+	m := NewMetricsObserver([]float64{0.5, 0.1})
+	c.AddObserver(m)
+
+	for i := 0; i < 3; i++ {
+		c.PerformRequest(&RestRequest{Method: "GET", Path: "/servers", Operation: "servers.Servers", ExpectedStatusCodes: []int{200}})
+	}
+
+	stats := m.Stats()
+	if len(stats) != 1 {
+		t.Error("Expected a single operation; got", stats)
+		return
+	}
+	s := stats[0]
+	if s.Requests[200] != 2 || s.Requests[404] != 1 || s.Latency.Count != 3 {
+		t.Error("Expected two successes and a failure; got", s.Requests, s.Latency.Count)
+		return
+	}
+	if s.Latency.Buckets[0] != 0.1 || s.Latency.Counts[0] != 3 {
+		t.Error("Expected sorted buckets counting fast requests; got", s.Latency)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, line := range []string{
+		`gorax_requests_total{service="servers",operation="servers.Servers",region="ORD",method="GET",code="404"} 1`,
+		`gorax_request_duration_seconds_bucket{service="servers",operation="servers.Servers",region="ORD",method="GET",le="+Inf"} 3`,
+		"# TYPE gorax_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Error("Expected exposition to include", line, "; got", buf.String())
+			return
+		}
+	}
+}
+
+func TestTraceObserverPropagatesTraceparent(t *testing.T) {
+	transport := &testTransport{response: "{}"}
+	c := withTestClient(transport)
+
+	var spans, parents []TraceContext
+	c.AddObserver(&TraceObserver{OnFinish: func(span, parent TraceContext, info *RequestInfo) {
+		spans = append(spans, span)
+		parents = append(parents, parent)
+	}})
+
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/a"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	root, err := ParseTraceparent(transport.requests[0].Header.Get("traceparent"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if root != spans[0] || root.Flags != TraceFlagSampled || parents[0] != (TraceContext{}) {
+		t.Error("Expected a new sampled trace; got", root, spans[0], parents[0])
+		return
+	}
+
+	parent, _ := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	_, err = c.PerformRequestWithContext(WithTraceContext(context.Background(), parent), &RestRequest{Method: "GET", Path: "/b"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	child, err := ParseTraceparent(transport.requests[1].Header.Get("traceparent"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if child.TraceID != parent.TraceID || child.SpanID == parent.SpanID || child.Flags != 0 || parents[1] != parent {
+		t.Error("Expected a child span of", parent, "; got", child)
+		return
+	}
+}
+
+func TestTraceObserverLeavesRequestUnstamped(t *testing.T) {
+	transport := &testTransport{response: "{}"}
+	c := withTestClient(transport)
+	c.AddObserver(&TraceObserver{})
+
+	req := &RestRequest{Method: "GET", Path: "/a"}
+	for i := 0; i < 2; i++ {
+		if _, err := c.PerformRequest(req); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if req.Header.Get("traceparent") != "" {
+		t.Error("Expected the caller's request to be left unstamped; got", req.Header.Get("traceparent"))
+		return
+	}
+
+	first, _ := ParseTraceparent(transport.requests[0].Header.Get("traceparent"))
+	second, _ := ParseTraceparent(transport.requests[1].Header.Get("traceparent"))
+	if first.TraceID == second.TraceID {
+		t.Error("Expected a resent request to begin a new trace; got", first, second)
+		return
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const header = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	tc, err := ParseTraceparent(header)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if tc.String() != header {
+		t.Error("Expected", header, "; got", tc.String())
+		return
+	}
+
+	for _, bad := range []string{
+		"",
+		"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-zzad6b7169203331-01",
+	} {
+		if _, err := ParseTraceparent(bad); err == nil {
+			t.Error("Expected", bad, "to be rejected")
+			return
+		}
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// A ClientOption configures how a RestClient reaches the network; pass any number of them to MakeRestClient().
+//
+// WithHTTPClient() and WithTransport() supply the client or transport to start from; by default, a plain http.Client
+// using http.DefaultTransport is used.
+// The remaining options adjust a copy of that transport, leaving the original untouched.
+// They can only do so if it is an *http.Transport; a transport of any other kind is used exactly as supplied.
+type ClientOption func(*clientConfig)
+
+type clientConfig struct {
+	httpClient *http.Client
+	transport  http.RoundTripper
+	timeout    *time.Duration
+	adjust     []func(*http.Transport)
+}
+
+// DefaultTimeout bounds how long any single request may take, including reading the response body,
+// for clients built without WithHTTPClient() or WithTimeout(); it guards against endpoints which hang without ever answering.
+// It is generous, so as not to cut short large downloads; pass WithTimeout(), or call SetTimeout(), to choose another bound, zero meaning none.
+var DefaultTimeout = 5 * time.Minute
+
+// WithHTTPClient() makes the RestClient perform its requests with a copy of the given client,
+// sharing its transport, cookie jar and redirect policy, but not the client itself,
+// so that settings such as SetTimeout() leave the caller's client untouched.
+// The client's own Timeout applies in place of DefaultTimeout, even if zero.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *clientConfig) {
+		c.httpClient = client
+	}
+}
+
+// WithTransport() makes the RestClient send its requests through the given transport.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(c *clientConfig) {
+		c.transport = transport
+	}
+}
+
+// WithTimeout() bounds how long any single request may take, including reading the response body; see RestClient.SetTimeout().
+// A zero duration means no limit at all.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *clientConfig) {
+		c.timeout = &timeout
+	}
+}
+
+func adjustTransport(f func(*http.Transport)) ClientOption {
+	return func(c *clientConfig) {
+		c.adjust = append(c.adjust, f)
+	}
+}
+
+// WithRootCAs() makes the RestClient trust only servers whose certificates chain to the given pool, e.g., one holding a private CA.
+// See LoadCertPool().
+func WithRootCAs(pool *x509.CertPool) ClientOption {
+	return adjustTransport(func(t *http.Transport) {
+		tlsConfig(t).RootCAs = pool
+	})
+}
+
+// WithClientCertificate() makes the RestClient present the given certificate to servers which ask for one.
+// Use tls.LoadX509KeyPair() to load a certificate and its key from PEM files.
+func WithClientCertificate(cert tls.Certificate) ClientOption {
+	return adjustTransport(func(t *http.Transport) {
+		config := tlsConfig(t)
+		config.Certificates = append(config.Certificates, cert)
+	})
+}
+
+// WithProxy() sends every request through the HTTP proxy at the given URL, e.g., "http://proxy.example.com:3128".
+// Credentials may be embedded in the URL.  Pass nil to connect directly, ignoring any proxy named by the environment.
+func WithProxy(proxy *url.URL) ClientOption {
+	return adjustTransport(func(t *http.Transport) {
+		if proxy == nil {
+			t.Proxy = nil
+		} else {
+			t.Proxy = http.ProxyURL(proxy)
+		}
+	})
+}
+
+// WithProxyFunc() chooses a proxy for each request with the given function; see http.Transport's Proxy field.
+// By default, proxies are chosen according to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func WithProxyFunc(proxy func(*http.Request) (*url.URL, error)) ClientOption {
+	return adjustTransport(func(t *http.Transport) {
+		t.Proxy = proxy
+	})
+}
+
+// WithDialTimeout() bounds how long establishing each TCP connection may take.
+func WithDialTimeout(timeout time.Duration) ClientOption {
+	return adjustTransport(func(t *http.Transport) {
+		t.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	})
+}
+
+// WithTLSHandshakeTimeout() bounds how long each TLS handshake may take.
+func WithTLSHandshakeTimeout(timeout time.Duration) ClientOption {
+	return adjustTransport(func(t *http.Transport) {
+		t.TLSHandshakeTimeout = timeout
+	})
+}
+
+// WithResponseHeaderTimeout() bounds how long to wait for a response's headers once the request has been written.
+func WithResponseHeaderTimeout(timeout time.Duration) ClientOption {
+	return adjustTransport(func(t *http.Transport) {
+		t.ResponseHeaderTimeout = timeout
+	})
+}
+
+// WithIdleConnections() sizes the pool of idle connections kept for reuse: at most maxIdle in all, and maxIdlePerHost to any one host,
+// each closed after lying idle for idleTimeout.  Zero values leave the corresponding setting alone.
+func WithIdleConnections(maxIdle, maxIdlePerHost int, idleTimeout time.Duration) ClientOption {
+	return adjustTransport(func(t *http.Transport) {
+		if maxIdle > 0 {
+			t.MaxIdleConns = maxIdle
+		}
+		if maxIdlePerHost > 0 {
+			t.MaxIdleConnsPerHost = maxIdlePerHost
+		}
+		if idleTimeout > 0 {
+			t.IdleConnTimeout = idleTimeout
+		}
+	})
+}
+
+// WithMaxConnsPerHost() limits the number of connections, whether active or idle, to any one host.
+func WithMaxConnsPerHost(n int) ClientOption {
+	return adjustTransport(func(t *http.Transport) {
+		t.MaxConnsPerHost = n
+	})
+}
+
+func tlsConfig(t *http.Transport) *tls.Config {
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	return t.TLSClientConfig
+}
+
+// NewHTTPClient() builds the net/http client the given options describe.
+// It lets code which does not use RestClient, such as servers.Region, share a RestClient's configuration by way of UseClient().
+// The result is always a new client, even if WithHTTPClient() is the only option, though it may share the transport of the client given.
+// Unless WithHTTPClient() or WithTimeout() say otherwise, its Timeout is DefaultTimeout.
+func NewHTTPClient(opts ...ClientOption) *http.Client {
+	config := &clientConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	client := &http.Client{Timeout: DefaultTimeout}
+	if config.httpClient != nil {
+		c := *config.httpClient
+		client = &c
+	}
+	if config.transport != nil {
+		client.Transport = config.transport
+	}
+
+	if len(config.adjust) > 0 {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		if t, ok := base.(*http.Transport); ok {
+			t = t.Clone()
+			for _, adjust := range config.adjust {
+				adjust(t)
+			}
+			client.Transport = t
+		}
+	}
+
+	if config.timeout != nil {
+		client.Timeout = *config.timeout
+	}
+	return client
+}
+
+// LoadCertPool() yields a certificate pool holding the system's trusted roots, along with every certificate in the given PEM files.
+// Use it with WithRootCAs() to trust a private certificate authority in addition to the public ones.
+func LoadCertPool(pemFiles ...string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	for _, name := range pemFiles {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", name)
+		}
+	}
+	return pool, nil
+}
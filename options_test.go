@@ -0,0 +1,162 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRootCAsOption(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+
+	_, err := MakeRestClient(server.URL).PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+	if err == nil {
+		t.Error("Expected the test server's certificate to be untrusted by default")
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "gorax-ca")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, data, 0600); err != nil {
+		t.Error(err)
+		return
+	}
+
+	pool, err := LoadCertPool(caFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = MakeRestClient(server.URL, WithRootCAs(pool), WithTLSHandshakeTimeout(5*time.Second)).PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := LoadCertPool(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("Expected a missing CA bundle to be reported")
+		return
+	}
+}
+
+func TestProxyOption(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = req.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	c := MakeRestClient("http://monitoring.api.rackspacecloud.com/v1.0", WithProxy(proxyURL))
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/entities"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if proxied != "http://monitoring.api.rackspacecloud.com/v1.0/entities" {
+		t.Error("Expected the request to pass through the proxy; got", proxied)
+		return
+	}
+}
+
+func TestResponseHeaderTimeoutOption(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := MakeRestClient(server.URL, WithResponseHeaderTimeout(10*time.Millisecond), WithIdleConnections(4, 2, time.Minute))
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/"})
+	if err == nil {
+		t.Error("Expected a slow server to time out")
+		return
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	custom := &http.Client{Transport: &testTransport{}, Timeout: time.Minute}
+	copied := NewHTTPClient(WithHTTPClient(custom))
+	if copied == custom || copied.Transport != custom.Transport || copied.Timeout != time.Minute {
+		t.Error("Expected a copy of the supplied client, sharing its transport; got", copied)
+		return
+	}
+	copied.Timeout = time.Second
+	if custom.Timeout != time.Minute {
+		t.Error("Expected changes to the copy to leave the supplied client untouched")
+		return
+	}
+	if c := NewHTTPClient(); c.Timeout != DefaultTimeout {
+		t.Error("Expected a new client to default to DefaultTimeout; got", c.Timeout)
+		return
+	}
+	if c := NewHTTPClient(WithTimeout(0)); c.Timeout != 0 {
+		t.Error("Expected WithTimeout(0) to lift the default timeout; got", c.Timeout)
+		return
+	}
+	custom = &http.Client{}
+	if c := NewHTTPClient(WithHTTPClient(custom)); c.Timeout != 0 {
+		t.Error("Expected the supplied client's timeout to prevail over the default; got", c.Timeout)
+		return
+	}
+
+	transport := &testTransport{}
+	client := NewHTTPClient(WithHTTPClient(custom), WithTransport(transport), WithTimeout(time.Second), WithDialTimeout(time.Second))
+	if client == custom || custom.Transport != nil || custom.Timeout != 0 {
+		t.Error("Expected the supplied client to be left untouched")
+		return
+	}
+	if client.Transport != transport || client.Timeout != time.Second {
+		t.Error("Expected an opaque transport to be used exactly as supplied; got", client.Transport, client.Timeout)
+		return
+	}
+
+	before := http.DefaultTransport.(*http.Transport).TLSClientConfig
+	client = NewHTTPClient(WithRootCAs(x509.NewCertPool()), WithMaxConnsPerHost(3))
+	t2, ok := client.Transport.(*http.Transport)
+	if !ok || t2 == http.DefaultTransport || t2.MaxConnsPerHost != 3 || t2.TLSClientConfig.RootCAs == nil {
+		t.Error("Expected an adjusted copy of the default transport; got", client.Transport)
+		return
+	}
+	if http.DefaultTransport.(*http.Transport).TLSClientConfig != before {
+		t.Error("Expected the default transport to be left untouched")
+		return
+	}
+}
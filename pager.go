@@ -0,0 +1,251 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The Page interface describes one installment of a marker-paginated collection, as decoded from a single response.
+//
+// Len() yields the number of items on the page, and Item() the i'th of them.
+// NextMarker() yields the marker from which the following page begins, or "" if this page is the last.
+// See MarkerFromHref() and NextMarkerFromLinks() for help in deriving markers from services which advertise whole URLs instead.
+type Page interface {
+	Len() int
+	Item(i int) interface{}
+	NextMarker() string
+}
+
+// A Link is a hypertext reference, as found in the "links" arrays OpenStack services attach to resources and collections.
+type Link struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+// MarkerFromHref() extracts the marker query parameter from a next-page URL, such as a Cloud Monitoring next_href.
+// It yields "" if href is empty or carries no marker.
+func MarkerFromHref(href string) string {
+	if href == "" {
+		return ""
+	}
+
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+
+	return u.Query().Get("marker")
+}
+
+// NextMarkerFromLinks() finds the link with relation "next" amongst an OpenStack-style links array, and extracts its marker.
+// It yields "" if there is no next link.
+func NextMarkerFromLinks(links []Link) string {
+	for _, link := range links {
+		if link.Rel == "next" {
+			return MarkerFromHref(link.Href)
+		}
+	}
+	return ""
+}
+
+// A Pager fetches the pages of a marker-paginated collection, one request per page, only as they are asked for.
+//
+// Each page is requested from the pager's path, with limit and marker query parameters appended as needed,
+// and decoded into a fresh Page produced by the pager's page factory.
+//
+// A Pager may be resumed from any page it has visited by handing Marker()'s result to Resume(), perhaps in another process altogether.
+type Pager struct {
+	client    *RestClient
+	path      string
+	newPage   func() Page
+	limit     int
+	marker    string
+	done      bool
+	operation string
+}
+
+// NewPager() creates a pager over the collection found at path, beneath the client's BaseUrl.
+// The newPage function must yield a fresh, empty Page each time it's called; responses are decoded into it with DeserializeBody().
+func NewPager(client *RestClient, path string, newPage func() Page) *Pager {
+	return &Pager{
+		client:  client,
+		path:    path,
+		newPage: newPage,
+	}
+}
+
+// SetLimit() asks the server for at most n items per page.
+// A limit of zero, the default, leaves the page size to the server.
+func (p *Pager) SetLimit(n int) {
+	p.limit = n
+}
+
+// SetOperation() names the logical operation the pager's requests belong to, for the benefit of the client's Observers.
+func (p *Pager) SetOperation(operation string) {
+	p.operation = operation
+}
+
+// Resume() makes the next page fetched begin at the given marker, as previously obtained from Marker().
+func (p *Pager) Resume(marker string) {
+	p.marker = marker
+	p.done = false
+}
+
+// Marker() yields the marker from which the next page will be fetched; "" denotes the start of the collection.
+func (p *Pager) Marker() string {
+	return p.marker
+}
+
+// Done() reports whether the last page has been fetched.
+func (p *Pager) Done() bool {
+	return p.done
+}
+
+// NextPage() fetches the next page of the collection.
+// It yields nil, with no error, once the collection is exhausted.
+func (p *Pager) NextPage(ctx context.Context) (Page, error) {
+	if p.done {
+		return nil, nil
+	}
+
+	restReq := &RestRequest{
+		Method:              "GET",
+		Path:                p.pagePath(),
+		ExpectedStatusCodes: []int{http.StatusOK},
+		Operation:           p.operation,
+	}
+
+	resp, err := p.client.PerformRequestWithContext(ctx, restReq)
+	if err != nil {
+		return nil, err
+	}
+
+	page := p.newPage()
+	err = resp.DeserializeBody(page)
+	if err != nil {
+		return nil, err
+	}
+
+	p.marker = page.NextMarker()
+	p.done = p.marker == ""
+	return page, nil
+}
+
+// pagePath() appends the pager's limit and marker to its path, preserving any query parameters already present.
+func (p *Pager) pagePath() string {
+	query := url.Values{}
+	if p.limit > 0 {
+		query.Set("limit", strconv.Itoa(p.limit))
+	}
+	if p.marker != "" {
+		query.Set("marker", p.marker)
+	}
+
+	if len(query) == 0 {
+		return p.path
+	}
+	if strings.Contains(p.path, "?") {
+		return p.path + "&" + query.Encode()
+	}
+	return p.path + "?" + query.Encode()
+}
+
+// An Iterator walks the items of a paginated collection one at a time, fetching pages from its Pager only as they are needed.
+// Abandoning an Iterator part-way through is perfectly safe; no request is left outstanding between calls to Next().
+//
+// The idiomatic loop reads:
+//
+//	it := NewIterator(pager)
+//	for it.Next(ctx) {
+//		item := it.Item()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	pager  *Pager
+	page   Page
+	marker string
+	index  int
+	err    error
+}
+
+// NewIterator() creates an iterator over the items of the pager's collection.
+func NewIterator(pager *Pager) *Iterator {
+	return &Iterator{
+		pager:  pager,
+		marker: pager.Marker(),
+	}
+}
+
+// Pager() yields the pager from which the iterator draws its pages, e.g., to adjust its limit before iteration begins.
+func (it *Iterator) Pager() *Pager {
+	return it.pager
+}
+
+// Next() advances to the next item, fetching a new page if the current one is exhausted.
+// It returns false once the collection is exhausted, or if an error occurs; see Err().
+func (it *Iterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.index++
+	for it.page == nil || it.index >= it.page.Len() {
+		if it.pager.Done() {
+			return false
+		}
+
+		marker := it.pager.Marker()
+		page, err := it.pager.NextPage(ctx)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if page == nil {
+			return false
+		}
+
+		it.page = page
+		it.marker = marker
+		it.index = 0
+	}
+
+	return true
+}
+
+// Item() yields the current item.  It must only be called after Next() has returned true.
+func (it *Iterator) Item() interface{} {
+	return it.page.Item(it.index)
+}
+
+// Err() yields the error, if any, which ended iteration prematurely.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Marker() yields the marker of the page holding the current item.
+// Resuming a pager from this marker revisits the current page in its entirety; items already seen on it will be seen again.
+func (it *Iterator) Marker() string {
+	return it.marker
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"testing"
+)
+
+// namePage is a Page in the layout used by Cloud Monitoring, albeit with a trivial item type.
+type namePage struct {
+	Values   []string
+	Metadata struct {
+		NextMarker *string `json:"next_marker"`
+		NextHref   *string `json:"next_href"`
+	}
+}
+
+func (p *namePage) Len() int               { return len(p.Values) }
+func (p *namePage) Item(i int) interface{} { return p.Values[i] }
+func (p *namePage) NextMarker() string {
+	if p.Metadata.NextMarker != nil {
+		return *p.Metadata.NextMarker
+	}
+	if p.Metadata.NextHref != nil {
+		return MarkerFromHref(*p.Metadata.NextHref)
+	}
+	return ""
+}
+
+func newNamePage() Page { return &namePage{} }
+
+const (
+	FIRST_PAGE  = `{"values": ["a", "b"], "metadata": {"next_marker": "c"}}`
+	SECOND_PAGE = `{"values": ["c", "d"], "metadata": {"next_href": "https://example.com/v1.0/123/entities?limit=2&marker=e%2Bf"}}`
+	LAST_PAGE   = `{"values": ["e+f"], "metadata": {"next_marker": null}}`
+)
+
+func TestIteratorWalksAllPages(t *testing.T) {
+	transport := &testTransport{responses: []string{FIRST_PAGE, SECOND_PAGE, LAST_PAGE}}
+	c := withTestClient(transport)
+
+	pager := NewPager(c, "/entities", newNamePage)
+	pager.SetLimit(2)
+	it := NewIterator(pager)
+
+	var names []string
+	for it.Next(context.Background()) {
+		names = append(names, it.Item().(string))
+	}
+	if it.Err() != nil {
+		t.Error(it.Err())
+		return
+	}
+	if len(names) != 5 || names[4] != "e+f" {
+		t.Error("Expected all five items across three pages; got", names)
+		return
+	}
+
+	expected := []string{"/v1.0/entities?limit=2", "/v1.0/entities?limit=2&marker=c", "/v1.0/entities?limit=2&marker=e%2Bf"}
+	for i, req := range transport.requests {
+		if req.URL.RequestURI() != expected[i] {
+			t.Error("Expected request", i, "for", expected[i], "got", req.URL.RequestURI())
+			return
+		}
+	}
+}
+
+func TestIteratorIsLazyAndResumable(t *testing.T) {
+	transport := &testTransport{responses: []string{FIRST_PAGE, SECOND_PAGE, LAST_PAGE}}
+	c := withTestClient(transport)
+
+	it := NewIterator(NewPager(c, "/entities", newNamePage))
+	it.Next(context.Background())
+	it.Next(context.Background())
+	it.Next(context.Background())
+	if it.Item().(string) != "c" || transport.called != 2 {
+		t.Error("Expected to stop after two pages at item c; fetched", transport.called, "pages")
+		return
+	}
+
+	// Stop early, and pick up from the current page elsewhere.
+	marker := it.Marker()
+	transport.responses = []string{SECOND_PAGE, LAST_PAGE}
+	transport.called = 0
+
+	pager := NewPager(c, "/entities", newNamePage)
+	pager.Resume(marker)
+	resumed := NewIterator(pager)
+	if !resumed.Next(context.Background()) || resumed.Item().(string) != "c" {
+		t.Error("Expected to resume at item c")
+		return
+	}
+}
+
+func TestNextMarkerFromLinks(t *testing.T) {
+	links := []Link{
+		{Href: "https://dfw.servers.api.rackspacecloud.com/v2/123/servers/detail", Rel: "self"},
+		{Href: "https://dfw.servers.api.rackspacecloud.com/v2/123/servers/detail?limit=1&marker=abc", Rel: "next"},
+	}
+	if m := NextMarkerFromLinks(links); m != "abc" {
+		t.Error("Expected marker abc; got", m)
+		return
+	}
+	if m := NextMarkerFromLinks(links[:1]); m != "" {
+		t.Error("Expected no marker without a next link; got", m)
+		return
+	}
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package provider offers a single authenticated session with the cloud, from which clients for each of its services are obtained.
+//
+// A Provider authenticates once, through any identity of the v2.0/identity package, and every client it vends shares that identity's
+// token and service catalog.  When the token nears expiry, or a service rejects it, it is renewed once on behalf of every client.
+// The clients also share one net/http transport, and hence one pool of connections, along with the provider's logging, retry and observer settings.
+// Each client, and the identity, has its own copy of the net/http client, however, so that a timeout set on one client affects it alone.
+//
+//	id := identity.NewIdentityWithAPIKey(username, apiKey, "")
+//	p := provider.New(id)
+//	p.SetRetryPolicy(gorax.DefaultRetryPolicy())
+//	region, err := p.Compute("DFW")
+//	...
+//	cm, err := p.Monitoring()
+package provider
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/racker/gorax"
+	gidentity "github.com/racker/gorax/identity"
+	"github.com/racker/gorax/monitoring"
+	"github.com/racker/gorax/v2.0/cloud/servers"
+	"github.com/racker/gorax/v2.0/identity"
+)
+
+// A Provider vends clients for the services in its identity's catalog.
+// Settings made through its methods apply to the clients it vends afterwards, not to those already vended.
+// A Provider is safe for concurrent use.
+type Provider struct {
+	id        identity.Identity
+	client    *http.Client
+	iface     string
+	logger    gorax.Logger
+	logLevel  gorax.LogLevel
+	retry     *gorax.RetryPolicy
+	observers []gorax.Observer
+	lock      sync.Mutex
+}
+
+// The configurable interface is satisfied by every client a Provider vends.
+type configurable interface {
+	UseClient(*http.Client)
+	SetLogger(gorax.Logger, gorax.LogLevel)
+	SetRetryPolicy(*gorax.RetryPolicy)
+	AddObserver(gorax.Observer)
+}
+
+// New creates a provider acting as the given identity, which need not be authenticated yet.
+// Any options given configure how the provider's clients, and the identity itself if it supports UseClient, reach the network;
+// see gorax.ClientOption.
+func New(id identity.Identity, opts ...gorax.ClientOption) *Provider {
+	p := &Provider{
+		id:     id,
+		client: gorax.NewHTTPClient(opts...),
+		iface:  identity.PublicInterface,
+	}
+	p.useClient(p.httpClient())
+	return p
+}
+
+// Identity yields the identity on whose behalf the provider acts.
+func (p *Provider) Identity() identity.Identity {
+	return p.id
+}
+
+// Authenticate authenticates the provider's identity, unless it is authenticated already.
+// The provider's other methods do so as needed, so it's seldom necessary to call this directly,
+// other than to learn early that the credentials are wrong.
+func (p *Provider) Authenticate() error {
+	if p.id.IsAuthenticated() {
+		return nil
+	}
+	if reauth, ok := p.id.(identity.Reauthenticator); ok {
+		return reauth.Reauthenticate("")
+	}
+	return p.id.Authenticate()
+}
+
+// UseClient makes the provider's identity, and the clients vended hereafter, perform their requests through copies of the given net/http client.
+// The copies share its Transport.
+func (p *Provider) UseClient(client *http.Client) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.client = client
+	p.useClient(p.httpClient())
+}
+
+// httpClient yields a copy of the provider's net/http client, sharing its Transport, for the identity or a newly vended client.
+// The caller must hold the provider's lock, unless the provider is still being created.
+func (p *Provider) httpClient() *http.Client {
+	c := *p.client
+	return &c
+}
+
+func (p *Provider) useClient(client *http.Client) {
+	if u, ok := p.id.(interface {
+		UseClient(*http.Client)
+	}); ok {
+		u.UseClient(client)
+	}
+}
+
+// UseServiceNet makes the clients vended hereafter reach their services through ServiceNet, Rackspace's internal network,
+// on which traffic between servers and services in the same region incurs no bandwidth charges.
+// Services which aren't on ServiceNet, such as Cloud Monitoring, are still reached through their public URLs.
+// Pass false to revert to the public interface.
+func (p *Provider) UseServiceNet(serviceNet bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if serviceNet {
+		p.iface = identity.InternalInterface
+	} else {
+		p.iface = identity.PublicInterface
+	}
+}
+
+// SetLogger reports every exchange made by the clients vended hereafter to the given logger, at the given level of detail.
+func (p *Provider) SetLogger(logger gorax.Logger, level gorax.LogLevel) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.logger, p.logLevel = logger, level
+}
+
+// SetRetryPolicy makes the clients vended hereafter retry their failed requests according to the given policy.
+// The policy is shared, not copied; see gorax.RetryPolicy.
+func (p *Provider) SetRetryPolicy(policy *gorax.RetryPolicy) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.retry = policy
+}
+
+// AddObserver reports every request made by the clients vended hereafter to the given observer; see gorax.Observer.
+func (p *Provider) AddObserver(observer gorax.Observer) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.observers = append(p.observers, observer)
+}
+
+// Endpoint finds an endpoint in the identity's service catalog, authenticating first if need be.
+// Unless opts names an interface, the provider's applies; see UseServiceNet.
+// Services which offer no internal URL, like Cloud Monitoring and other global services, are reached through their public URL even on ServiceNet.
+func (p *Provider) Endpoint(opts identity.EndpointOpts) (*identity.ResolvedEndpoint, error) {
+	if err := p.Authenticate(); err != nil {
+		return nil, err
+	}
+	var ep *identity.ResolvedEndpoint
+	err := p.resolve(opts, func(opts identity.EndpointOpts) (err error) {
+		ep, err = identity.ResolveEndpoint(p.id, opts)
+		return err
+	})
+	return ep, err
+}
+
+// Compute yields a client for the cloud servers of the given region; pass "" for the user's default region.
+func (p *Provider) Compute(region string) (servers.Region, error) {
+	if err := p.Authenticate(); err != nil {
+		return nil, err
+	}
+	var r servers.Region
+	err := p.resolve(identity.EndpointOpts{Region: region}, func(opts identity.EndpointOpts) (err error) {
+		r, err = servers.RegionByEndpoint(p.id, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.configure(r.(servers.ConfigurableRegion))
+	return r, nil
+}
+
+// Monitoring yields a client for Cloud Monitoring, a global service.
+func (p *Provider) Monitoring() (*monitoring.MonitoringClient, error) {
+	ep, err := p.Endpoint(identity.EndpointOpts{Type: monitoring.MonitoringServiceType})
+	if err != nil {
+		return nil, err
+	}
+
+	// The catalog's URL ends in the tenant ID, which the monitoring client adds to every request's path itself.
+	m := monitoring.MakeIdentityMonitoringClient(strings.TrimSuffix(ep.URL, "/"+ep.Endpoint.TenantId), p.id)
+	p.configure(m)
+	return m, nil
+}
+
+// Admin yields a client for the identity service's admin API, through which a user-admin manages the account's sub-users.
+// The admin API is that of Identity v2.0; providers acting as a Keystone v3 identity yield gidentity.ErrAdminRequiresV2.
+func (p *Provider) Admin() (*gidentity.AdminClient, error) {
+	a, err := gidentity.MakeAdminClient(p.id)
+	if err != nil {
+		return nil, err
+	}
+	p.configure(a)
+	return a, nil
+}
+
+// ServiceClient yields a RestClient for any service in the catalog, addressing the endpoint which satisfies opts.
+// Requests carry the identity's token, and paths are relative to the endpoint's URL, which normally includes the tenant.
+// This serves services for which gorax has no dedicated client yet.
+func (p *Provider) ServiceClient(opts identity.EndpointOpts) (*gorax.RestClient, error) {
+	ep, err := p.Endpoint(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := gorax.MakeRestClient(ep.URL)
+	c.Service = ep.Service.Type
+	c.Region = ep.Endpoint.Region
+	auth := gidentity.MakeKeystoneIdentityMiddleware(p.id)
+	auth.SkipTenantPath = true
+	c.RequestMiddlewares = append(c.RequestMiddlewares, auth)
+	c.RoundTripMiddlewares = append(c.RoundTripMiddlewares, auth)
+	p.configure(c)
+	return c, nil
+}
+
+// resolve calls find with opts, filling in the provider's interface unless opts names one.
+// Should the provider's interface be ServiceNet, and find report that the service has no such endpoint, find is called again for the public interface.
+func (p *Provider) resolve(opts identity.EndpointOpts, find func(identity.EndpointOpts) error) error {
+	if opts.Interface != "" {
+		return find(opts)
+	}
+	opts.Interface = p.endpointInterface()
+	err := find(opts)
+	if opts.Interface == identity.InternalInterface && errors.Is(err, identity.ErrEndpointNotFound) {
+		opts.Interface = identity.PublicInterface
+		err = find(opts)
+	}
+	return err
+}
+
+// endpointInterface yields the interface through which vended clients reach their services.
+func (p *Provider) endpointInterface() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.iface
+}
+
+// configure applies the provider's shared settings to a newly vended client.
+func (p *Provider) configure(c configurable) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	c.UseClient(p.httpClient())
+	if p.logger != nil {
+		c.SetLogger(p.logger, p.logLevel)
+	}
+	if p.retry != nil {
+		c.SetRetryPolicy(p.retry)
+	}
+	for _, o := range p.observers {
+		c.AddObserver(o)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/racker/gorax"
+	"github.com/racker/gorax/v2.0/identity"
+)
+
+const LOGIN_RESPONSE = `{"access": {
+	"token": {"id": "shared-token", "expires": "2099-01-01T00:00:00.000Z", "tenant": {"id": "12345"}},
+	"user": {"id": "1", "name": "joe", "RAX-AUTH:defaultRegion": "DFW"},
+	"serviceCatalog": [
+		{"name": "cloudServersOpenStack", "type": "compute", "endpoints": [
+			{"region": "DFW", "tenantId": "12345", "versionId": "2",
+			 "publicURL": "https://dfw.servers.example.com/v2/12345", "internalURL": "https://snet-dfw.servers.example.com/v2/12345"}]},
+		{"name": "cloudMonitoring", "type": "rax:monitor", "endpoints": [
+			{"tenantId": "12345", "publicURL": "https://monitoring.example.com/v1.0/12345"}]},
+		{"name": "cloudDNS", "type": "rax:dns", "endpoints": [
+			{"tenantId": "12345", "publicURL": "https://dns.example.com/v1.0/12345"}]}
+	]}}`
+
+// cloudTransport plays the identity service and every service in LOGIN_RESPONSE's catalog, recording the URLs requested of the latter.
+type cloudTransport struct {
+	lock   sync.Mutex
+	logins int
+	seen   []string
+}
+
+func (t *cloudTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	status, body := http.StatusOK, `{"flavors": [], "values": [], "metadata": {}}`
+	if strings.HasSuffix(req.URL.Path, "/tokens") {
+		t.logins++
+		body = LOGIN_RESPONSE
+	} else {
+		t.seen = append(t.seen, req.URL.Host+req.URL.Path)
+		if req.Header.Get("X-Auth-Token") != "shared-token" {
+			status, body = http.StatusUnauthorized, `{"unauthorized": {"code": 401}}`
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestProviderSharesOneSession(t *testing.T) {
+	transport := &cloudTransport{}
+	p := New(identity.NewIdentityWithAPIKey("joe", "key", ""), gorax.WithTransport(transport))
+	metrics := gorax.NewMetricsObserver(nil)
+	p.AddObserver(metrics)
+	p.UseServiceNet(true)
+
+	region, err := p.Compute("")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := region.Flavors(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cm, err := p.Monitoring()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := cm.ListEntities(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dns, err := p.ServiceClient(identity.EndpointOpts{Type: "rax:dns"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp, err := dns.PerformRequest(&gorax.RestRequest{Method: "GET", Path: "/domains", ExpectedStatusCodes: []int{http.StatusOK}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+
+	if transport.logins != 1 {
+		t.Error("Expected one authentication for all clients; got", transport.logins)
+		return
+	}
+	expected := []string{
+		"snet-dfw.servers.example.com/v2/12345/flavors",
+		"monitoring.example.com/v1.0/12345/entities",
+		"dns.example.com/v1.0/12345/domains",
+	}
+	if strings.Join(transport.seen, " ") != strings.Join(expected, " ") {
+		t.Error("Expected requests", expected, "got:", transport.seen)
+		return
+	}
+	if len(metrics.Stats()) != 3 {
+		t.Error("Expected every client to report to the shared observer; got", metrics.Stats())
+		return
+	}
+}
+
+// clientRecorder stands in for a vended client, or for an identity, recording the net/http clients it is given.
+type clientRecorder struct {
+	identity.Identity
+	clients []*http.Client
+}
+
+func (r *clientRecorder) UseClient(c *http.Client)               { r.clients = append(r.clients, c) }
+func (r *clientRecorder) SetLogger(gorax.Logger, gorax.LogLevel) {}
+func (r *clientRecorder) SetRetryPolicy(*gorax.RetryPolicy)      {}
+func (r *clientRecorder) AddObserver(gorax.Observer)             {}
+
+func TestProviderCopiesClient(t *testing.T) {
+	transport := &cloudTransport{}
+	id := &clientRecorder{Identity: identity.NewIdentityWithAPIKey("joe", "key", "")}
+	p := New(id, gorax.WithTransport(transport))
+	a, b := &clientRecorder{}, &clientRecorder{}
+	p.configure(a)
+	p.configure(b)
+
+	clients := []*http.Client{id.clients[0], a.clients[0], b.clients[0]}
+	for i, c := range clients {
+		if c.Transport != transport {
+			t.Error("Expected every client to share the provider's transport; got", c.Transport)
+			return
+		}
+		for _, other := range clients[:i] {
+			if c == other {
+				t.Error("Expected the identity and each vended client to have a client of its own")
+				return
+			}
+		}
+	}
+
+	a.clients[0].Timeout = time.Second
+	if b.clients[0].Timeout != gorax.DefaultTimeout || id.clients[0].Timeout != gorax.DefaultTimeout {
+		t.Error("Expected a timeout set on one client to leave the others alone")
+		return
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// GlobalRateClass names the rate class every request passing through a RateLimiter draws upon.
+const GlobalRateClass = "global"
+
+// A TokenBucket paces events to a steady rate, while permitting bursts up to its capacity.
+// It is safe for concurrent use; goroutines sharing a bucket are throttled together, and are served in the order they arrive.
+type TokenBucket struct {
+	lock     sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewTokenBucket() creates a bucket which refills at the given rate of tokens per second, holding at most capacity tokens.
+// The bucket starts out full.
+func NewTokenBucket(rate float64, capacity int) *TokenBucket {
+	return &TokenBucket{
+		rate:     rate,
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		last:     time.Now(),
+	}
+}
+
+// SetTokens() overrides the number of tokens presently available, e.g., to account for requests already made during the current window.
+func (b *TokenBucket) SetTokens(n int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	b.tokens = math.Min(float64(n), b.capacity)
+}
+
+// Wait() takes a token from the bucket, blocking until one becomes available or the context ends.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	b.lock.Lock()
+	now := time.Now()
+	b.refill(now)
+	b.tokens--
+	deficit := -b.tokens
+	b.lock.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	if b.rate <= 0 {
+		b.refund()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / b.rate * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.refund()
+		return ctx.Err()
+	}
+}
+
+func (b *TokenBucket) refund() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens = math.Min(b.tokens+1, b.capacity)
+}
+
+// refill() credits the tokens accrued since the last refill; the caller must hold the lock.
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.tokens+elapsed*b.rate, b.capacity)
+		b.last = now
+	}
+}
+
+// A RateLimiter is a RoundTripMiddleware which paces requests so as to stay within an account's published rate limits.
+//
+// Requests are sorted into rate classes by the Classify function.
+// Every request draws a token from the GlobalRateClass bucket, and also from the bucket of each class Classify assigns it to;
+// classes without a configured limit are not paced.
+// A nil Classify assigns requests to the global class alone.
+//
+// A single RateLimiter may be shared by any number of clients and goroutines, which are then throttled together.
+type RateLimiter struct {
+	Classify func(*RestRequest) []string
+
+	lock    sync.Mutex
+	buckets map[string]*TokenBucket
+}
+
+// NewRateLimiter() creates a rate limiter with no limits configured, and so imposes none until SetLimit() is called.
+func NewRateLimiter(classify func(*RestRequest) []string) *RateLimiter {
+	return &RateLimiter{
+		Classify: classify,
+		buckets:  map[string]*TokenBucket{},
+	}
+}
+
+// SetLimit() permits at most limit requests of the given class per window.
+// The remaining argument tells how many of those requests are still available in the current window;
+// pass limit itself if nothing is known about the window's usage so far.
+func (l *RateLimiter) SetLimit(class string, limit int, window time.Duration, remaining int) {
+	if limit <= 0 || window <= 0 {
+		return
+	}
+
+	bucket := NewTokenBucket(float64(limit)/window.Seconds(), limit)
+	bucket.SetTokens(remaining)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.buckets[class] = bucket
+}
+
+// Bucket() yields the token bucket pacing the given class, or nil if the class is not limited.
+func (l *RateLimiter) Bucket(class string) *TokenBucket {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.buckets[class]
+}
+
+// HandleRoundTrip waits for the request's rate classes to permit it, then performs it.
+func (l *RateLimiter) HandleRoundTrip(ctx context.Context, req *RestRequest, next RoundTripFunc) (*RestResponse, error) {
+	classes := []string{GlobalRateClass}
+	if l.Classify != nil {
+		classes = append(classes, l.Classify(req)...)
+	}
+
+	for _, class := range classes {
+		if bucket := l.Bucket(class); bucket != nil {
+			if err := bucket.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return next(ctx, req)
+}
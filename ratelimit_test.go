@@ -0,0 +1,92 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketPaces(t *testing.T) {
+	bucket := NewTokenBucket(100, 2)
+	start := time.Now()
+
+	for i := 0; i < 4; i++ {
+		if err := bucket.Wait(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Two tokens are available immediately; the other two take 10ms apiece to accrue.
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Error("Expected the bucket to pace requests beyond its burst; took only", elapsed)
+		return
+	}
+}
+
+func TestTokenBucketHonorsContext(t *testing.T) {
+	bucket := NewTokenBucket(0.001, 1)
+	bucket.SetTokens(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+
+	if err := bucket.Wait(ctx); err != context.DeadlineExceeded {
+		t.Error("Expected to give up at the deadline; got", err)
+		return
+	}
+}
+
+func TestRateLimiterSharedAcrossGoroutines(t *testing.T) {
+	transport := &testTransport{response: "{}"}
+	c := withTestClient(transport)
+
+	limiter := NewRateLimiter(func(req *RestRequest) []string {
+		if strings.HasSuffix(req.Path, "/traceroute") {
+			return []string{"traceroute"}
+		}
+		return nil
+	})
+	limiter.SetLimit(GlobalRateClass, 1000, time.Second, 1000)
+	limiter.SetLimit("traceroute", 100, time.Second, 1)
+	c.RoundTripMiddlewares = []RoundTripMiddleware{limiter}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.PerformRequest(&RestRequest{Method: "POST", Path: "/monitoring_zones/mzord/traceroute"})
+		}()
+	}
+	wg.Wait()
+
+	// One traceroute may proceed at once; the other two wait 10ms apiece.
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Error("Expected traceroutes to be paced together; took only", elapsed)
+		return
+	}
+	if transport.called != 3 {
+		t.Error("Expected all three requests to be made eventually; made", transport.called)
+		return
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package recorder provides an HTTP transport which records real exchanges with Rackspace services to cassette files,
+// and replays them later without any network access.
+// It lets tests and examples exercise the gorax clients deterministically, without a live Rackspace account.
+//
+// Hand the recorder's Client() to RestClient.UseClient(), identity.UseClient() or servers.Region.UseClient(), as appropriate.
+// Credentials are scrubbed from cassettes before they are written; see gorax.RedactHeader() and gorax.RedactJSON().
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/racker/gorax"
+)
+
+// A Mode determines whether a Recorder talks to the network.
+type Mode int
+
+const (
+	// Replay answers every request from the cassette, and fails any request the cassette cannot answer.
+	Replay Mode = iota
+	// Record passes every request through to the network, and writes the exchanges to the cassette when stopped.
+	Record
+	// Auto replays from the cassette if it exists, and records a new one otherwise.
+	Auto
+)
+
+// A RecordedRequest describes a request as stored in a cassette.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// A RecordedResponse describes a response as stored in a cassette.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// An Interaction pairs a recorded request with the response it received.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// A Cassette is the on-disk collection of a recording's interactions, in the order they occurred.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// A Matcher decides which aspects of a request must agree with a recorded request for the recording to answer it.
+// Method and Path are compared exactly; Query compares query parameters irrespective of order;
+// Body compares request bodies, treating JSON documents as equal if they hold the same values.
+// Header names request headers whose values must agree once credentials are scrubbed;
+// a credential header thus matches whenever both requests carry it, whatever its value.
+type Matcher struct {
+	Method bool
+	Path   bool
+	Query  bool
+	Body   bool
+	Header []string
+}
+
+// DefaultMatcher compares method, path and query, but not bodies.
+var DefaultMatcher = Matcher{Method: true, Path: true, Query: true}
+
+// A Recorder is an http.RoundTripper which records exchanges to, or replays them from, a cassette file.
+//
+// In replay mode, each request is answered by the first unused recorded interaction matching it, so that a sequence of identical
+// requests (polling a server's status, say) receives the recorded sequence of responses.
+// If AllowReuse is set, interactions may answer any number of matching requests once all have been used.
+//
+// Transport, if set, is used to reach the network while recording; otherwise http.DefaultTransport is used.
+type Recorder struct {
+	Matcher    Matcher
+	AllowReuse bool
+	Transport  http.RoundTripper
+
+	path     string
+	mode     Mode
+	cassette *Cassette
+	used     []bool
+	lock     sync.Mutex
+}
+
+// New() creates a recorder backed by the cassette at path.
+// In Replay mode, and in Auto mode when the file exists, the cassette is loaded immediately; an error is returned if it cannot be.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		Matcher:  DefaultMatcher,
+		path:     path,
+		mode:     mode,
+		cassette: &Cassette{},
+	}
+
+	if mode == Auto {
+		r.mode = Record
+		if _, err := os.Stat(path); err == nil {
+			r.mode = Replay
+		}
+	}
+
+	if r.mode == Replay {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, r.cassette); err != nil {
+			return nil, fmt.Errorf("recorder: malformed cassette %s: %s", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+
+	return r, nil
+}
+
+// Mode() reports whether the recorder is recording or replaying.
+// A recorder created in Auto mode reports the mode it settled upon.
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Client() yields an http.Client whose requests pass through the recorder.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Stop() writes the cassette, if recording.  It must be called once the recording is complete.
+func (r *Recorder) Stop() error {
+	if r.mode != Record {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	data, err := json.MarshalIndent(r.cassette, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, append(data, '\n'), 0644)
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := RecordedRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: gorax.RedactHeader(req.Header),
+		Body:   string(gorax.RedactJSON(body)),
+	}
+
+	if r.mode == Replay {
+		return r.replay(req, &recorded)
+	}
+	return r.record(req, &recorded)
+}
+
+func (r *Recorder) replay(req *http.Request, recorded *RecordedRequest) (*http.Response, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	found := -1
+	for i, interaction := range r.cassette.Interactions {
+		if r.Matcher.matches(&interaction.Request, recorded) {
+			if !r.used[i] {
+				found = i
+				break
+			}
+			if r.AllowReuse && found < 0 {
+				found = i
+			}
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("recorder: no interaction in %s matches %s %s", r.path, recorded.Method, recorded.URL)
+	}
+	r.used[found] = true
+
+	resp := r.cassette.Interactions[found].Response
+	header := resp.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Recorder) record(req *http.Request, recorded *RecordedRequest) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+		Request: *recorded,
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     gorax.RedactHeader(resp.Header),
+			Body:       string(gorax.RedactJSON(data)),
+		},
+	})
+
+	return resp, nil
+}
+
+// readRequestBody() reads the request's body, leaving an identical replacement in its place.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+func (m Matcher) matches(recorded, actual *RecordedRequest) bool {
+	if m.Method && recorded.Method != actual.Method {
+		return false
+	}
+
+	ru, err1 := url.Parse(recorded.URL)
+	au, err2 := url.Parse(actual.URL)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	if m.Path && ru.Path != au.Path {
+		return false
+	}
+	if m.Query && ru.Query().Encode() != au.Query().Encode() {
+		return false
+	}
+	if m.Body && !sameBody(recorded.Body, actual.Body) {
+		return false
+	}
+	for _, name := range m.Header {
+		if strings.Join(recorded.Header.Values(name), ",") != strings.Join(actual.Header.Values(name), ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sameBody(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	var da, db interface{}
+	if json.Unmarshal([]byte(a), &da) != nil || json.Unmarshal([]byte(b), &db) != nil {
+		return false
+	}
+	ca, _ := json.Marshal(da)
+	cb, _ := json.Marshal(db)
+	return bytes.Equal(ca, cb)
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package recorder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/racker/gorax"
+	"github.com/racker/gorax/v2.0/cloud/servers"
+	"github.com/racker/gorax/v2.0/identity"
+)
+
+func TestReplayIdentityAndServers(t *testing.T) {
+	r, err := New("testdata/flavors.json", Replay)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	id := identity.NewIdentity("demoauthor", "not-the-recorded-password", "")
+	id.UseClient(r.Client())
+	if err := id.Authenticate(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	region, err := servers.RegionByName(id, "dfw")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	region.UseClient(r.Client())
+
+	flavors, err := region.Flavors()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(flavors) != 2 || flavors[1].Name != "1GB Standard Instance" {
+		t.Error("Expected the two recorded flavors; got", flavors)
+		return
+	}
+
+	_, err = region.Images()
+	if err == nil || !strings.Contains(err.Error(), "no interaction") {
+		t.Error("Expected an unrecorded request to fail; got", err)
+		return
+	}
+}
+
+func TestRecordScrubsCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Subject-Token", "secret-token")
+		fmt.Fprint(w, `{"token":{"id":"secret-token"},"name":"kept"}`)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassettes", "scrub.json")
+
+	r, err := New(path, Auto)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if r.Mode() != Record {
+		t.Error("Expected Auto to record when no cassette exists")
+		return
+	}
+
+	client := gorax.MakeRestClient(server.URL)
+	client.UseClient(r.Client())
+	_, err = client.PerformRequest(&gorax.RestRequest{
+		Method: "POST",
+		Path:   "/tokens",
+		Body:   &gorax.JSONRequestBody{Object: map[string]string{"password": "hunter2"}},
+		Header: http.Header{"X-Auth-Token": {"secret-token"}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := r.Stop(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Contains(string(data), "secret-token") || strings.Contains(string(data), "hunter2") {
+		t.Error("Expected credentials to be scrubbed from the cassette; got", string(data))
+		return
+	}
+	if !strings.Contains(string(data), "kept") {
+		t.Error("Expected ordinary response content to be recorded; got", string(data))
+		return
+	}
+
+	r, err = New(path, Auto)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if r.Mode() != Replay {
+		t.Error("Expected Auto to replay an existing cassette")
+		return
+	}
+}
+
+func TestReplaySequenceAndReuse(t *testing.T) {
+	r := &Recorder{
+		Matcher:  DefaultMatcher,
+		mode:     Replay,
+		cassette: &Cassette{},
+	}
+	for _, status := range []string{"BUILD", "ACTIVE"} {
+		r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+			Request:  RecordedRequest{Method: "GET", URL: "https://example.com/servers/1?a=1&b=2"},
+			Response: RecordedResponse{StatusCode: 200, Body: status},
+		})
+	}
+	r.used = make([]bool, 2)
+
+	get := func() (string, error) {
+		resp, err := r.Client().Get("https://example.com/servers/1?b=2&a=1")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		return string(data), err
+	}
+
+	for _, expected := range []string{"BUILD", "ACTIVE"} {
+		body, err := get()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if body != expected {
+			t.Error("Expected", expected, "; got", body)
+			return
+		}
+	}
+
+	if _, err := get(); err == nil {
+		t.Error("Expected exhausted interactions to fail without AllowReuse")
+		return
+	}
+
+	r.AllowReuse = true
+	body, err := get()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if body != "BUILD" {
+		t.Error("Expected the first interaction to be reused; got", body)
+		return
+	}
+}
+
+func TestMatcherBody(t *testing.T) {
+	m := Matcher{Body: true}
+	a := &RecordedRequest{URL: "/", Body: `{"a":1,"b":2}`}
+	b := &RecordedRequest{URL: "/", Body: `{"b":2, "a":1}`}
+	if !m.matches(a, b) {
+		t.Error("Expected equivalent JSON bodies to match")
+		return
+	}
+	b.Body = `{"a":2}`
+	if m.matches(a, b) {
+		t.Error("Expected different bodies not to match")
+		return
+	}
+}
+
+func TestMatcherHeader(t *testing.T) {
+	m := Matcher{Header: []string{"X-Auth-Token"}}
+	a := &RecordedRequest{URL: "/", Header: http.Header{"X-Auth-Token": {gorax.Redacted}}}
+	b := &RecordedRequest{URL: "/", Header: gorax.RedactHeader(http.Header{"X-Auth-Token": {"another-token"}})}
+	if !m.matches(a, b) {
+		t.Error("Expected scrubbed tokens to match whatever their values")
+		return
+	}
+	b.Header = nil
+	if m.matches(a, b) {
+		t.Error("Expected a request lacking the header not to match")
+		return
+	}
+}
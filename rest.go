@@ -18,15 +18,19 @@ package gorax
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"net/http"
-	"net/http/httputil"
+	"os"
+	"time"
 )
 
+// A RestError reports a failure detected by the client itself, before or without any exchange with the server.
+// Faults reported by the server are represented by APIError instead.
 type RestError struct {
 	ErrorString string
 }
@@ -54,19 +58,22 @@ func (e *RestError) Error() string {
 //
 // ExpectedStatusCodes provides a set of response codes considered to be valid for the request.
 // This field may also be nil if you just don't care about response checking.
+//
+// Operation optionally names the logical API call the request belongs to, such as "monitoring.ListChecks", for the benefit of Observers.
 type RestRequest struct {
 	Method              string
 	Path                string
 	Header              http.Header
 	Body                RequestBody
 	ExpectedStatusCodes []int
+	Operation           string
 }
 
 // The RequestBody interface represents an abstract concept of a hunk of data passed along with an HTTP request.
 // Bodies may be part of a request, a response, or both, depending upon the resource accessed and the method used.
 //
 // The ContentType() (string, error) method yields the official, even if experimental, MIME type string for the content in the body.
-// The ContentLength() (int64, error) method yields its length.  Finally, the Body() (io.Reader, error) method yields a reader interface
+// The ContentLength() (int64, error) method yields its length, or -1 if the length is not known in advance.  Finally, the Body() (io.Reader, error) method yields a reader interface
 // that allows the client software access to the contents of the data.
 type RequestBody interface {
 	ContentType() (string, error)
@@ -119,10 +126,13 @@ type RestResponse struct {
 }
 
 // DeserializeBody() recreates an object graph as denoted in the REST response's body.
-// 
+//
+// The body is decoded according to the response's content-type, using the decoder registered for that media type.
+// JSON and XML documents, plain text and HTML are supported out of the box, and other types may be added with RegisterDecoder().
 // This method will return an error if the response's content-type cannot be recognized.
-// Presently, the RestResponse type only supports application/json; future versions of this
-// type may support additional types.  Refer to http://godoc.org/encoding/json for more information.
+// Refer to http://godoc.org/encoding/json and http://godoc.org/encoding/xml for more information.
+//
+// DeserializeBody() reads the entire body into memory; use Stream() or WriteTo() for bodies too large for that.
 func (r *RestResponse) DeserializeBody(target interface{}) error {
 	defer r.Body.Close()
 	data, err := ioutil.ReadAll(r.Body)
@@ -137,9 +147,8 @@ func (r *RestResponse) DeserializeBody(target interface{}) error {
 		return err
 	}
 
-	switch contentType {
-	case "application/json":
-		return json.Unmarshal(data, target)
+	if decode, ok := decoderFor(contentType); ok {
+		return decode(data, target)
 	}
 
 	return fmt.Errorf("unsupported Content-Type: %s", r.Header.Get("Content-Type"))
@@ -149,6 +158,14 @@ type RequestMiddleware interface {
 	HandleRequest(*RestRequest) (*RestRequest, error)
 }
 
+// The ContextRequestMiddleware interface extends RequestMiddleware for filters which may block, such as authenticators.
+// When a middleware implements this interface, PerformRequestWithContext() invokes HandleRequestWithContext() in place of HandleRequest(),
+// handing it the caller's context so that any work the filter performs may be cancelled or time-boxed along with the request itself.
+type ContextRequestMiddleware interface {
+	RequestMiddleware
+	HandleRequestWithContext(context.Context, *RestRequest) (*RestRequest, error)
+}
+
 // The RestClient object encapsulates a connection to a RESTful service.  Several assumptions are
 // made about this service:
 //
@@ -159,23 +176,52 @@ type RequestMiddleware interface {
 // 4.  Filters may alter the original request.  For example, an authentication filter may inject an authentication token,
 // while a tracing filter may inject a unique tracing token for logging purposes.
 //
-// Additionally, the Debug field indicates whether or not request/response logging (usually to stdout) occurs.
+// Once the request middlewares have run, the request passes through the RoundTripMiddlewares in order.
+// Each of these wraps the remainder of the exchange, and so may inspect, replay or even answer the request itself;
+// see the RoundTripMiddleware type.
+// The ResponseMiddlewares, in turn, see each response as it arrives, before its status code is checked against the request's expectations.
+//
+// The RetryPolicy field, if not nil, governs whether and how failed requests are re-attempted.
+// It behaves as the outermost round-trip middleware; see the RetryPolicy type for more details.
+//
+// If the Logger field is set, every attempted exchange is reported to it, in as much detail as the LogLevel field asks for.
+// Credentials are redacted from these reports; see the LogRecord type.
+// The Debug field is a shorthand which, in the absence of a Logger, reports everything to stdout.
+//
+// Every request is also reported to each of the Observers, labelled with the client's Service and Region; see the Observer type.
+//
+// If the Cache field is set, GET responses are retained and revalidated with conditional requests; see the Cache type.
 type RestClient struct {
-	BaseUrl            string
-	RequestMiddlewares []RequestMiddleware
-	Debug              bool
-	client             *http.Client
+	BaseUrl              string
+	RequestMiddlewares   []RequestMiddleware
+	RoundTripMiddlewares []RoundTripMiddleware
+	ResponseMiddlewares  []ResponseMiddleware
+	RetryPolicy          *RetryPolicy
+	Logger               Logger
+	LogLevel             LogLevel
+	Debug                bool
+	Observers            []Observer
+	Service              string
+	Region               string
+	Cache                *Cache
+	client               *http.Client
 }
 
 // MakeRestClient() creates a new RestClient reference to a RESTful service.  The provided URL sets the BaseUrl of
 // the client, which scopes the resource paths of all RestRequests used to invoke services.  This function can never
 // fail except in out-of-memory situations.
-func MakeRestClient(url string) *RestClient {
+//
+// Any options given configure how the client reaches the network, e.g., through a proxy, or trusting a private certificate authority.
+// See the ClientOption type.
+func MakeRestClient(url string, opts ...ClientOption) *RestClient {
 	return &RestClient{
-		BaseUrl:            url,
-		RequestMiddlewares: []RequestMiddleware{},
-		Debug:              false,
-		client:             &http.Client{},
+		BaseUrl:              url,
+		RequestMiddlewares:   []RequestMiddleware{},
+		RoundTripMiddlewares: []RoundTripMiddleware{},
+		ResponseMiddlewares:  []ResponseMiddleware{},
+		Observers:            []Observer{},
+		Debug:                false,
+		client:               NewHTTPClient(opts...),
 	}
 }
 
@@ -183,10 +229,14 @@ func MakeRestClient(url string) *RestClient {
 // The request must be encapsulated in a RestRequest object.
 //
 // This function will return with an error if the REST server provides a response which is not anticipated by the client software.
+// Such errors are always of type *APIError, and carry the decoded fault document the server sent; see IsNotFound() and friends.
 // To configure the list of anticipated response codes, the RestRequest must have a non-nil ExpectedStatusCodes field value.
 // See the RestRequest type for more details.
-// 
-// If the request is in debug mode, diagnostic dumps of the HTTP traffic will appear on stdout.
+//
+// If the client has a RetryPolicy, server errors, rate-limiting responses and connection resets are retried according to that policy
+// before any error is reported.
+//
+// If the client has a Logger, or is in debug mode, a record of each exchange is logged, with credentials redacted.
 //
 // PerformRequest() computes the content length and type from the request's configured body, if any exists.
 //
@@ -197,8 +247,15 @@ func MakeRestClient(url string) *RestClient {
 // will have its provided username and password fields authenticated in prior to the actual and intended request handler for the specified
 // resource.
 func (c *RestClient) PerformRequest(restReq *RestRequest) (*RestResponse, error) {
+	return c.PerformRequestWithContext(context.Background(), restReq)
+}
+
+// PerformRequestWithContext() behaves like PerformRequest(), but binds the request to the provided context.
+// If the context is cancelled or its deadline passes before the response arrives, the request is aborted and the context's error is returned.
+// The same context is handed to every middleware implementing ContextRequestMiddleware, so authentication round-trips abort along with the request.
+// If the context was prepared with WithResponseMetadata(), the final response's particulars, such as its request ID, are captured there.
+func (c *RestClient) PerformRequestWithContext(ctx context.Context, restReq *RestRequest) (*RestResponse, error) {
 	var err error
-	var body io.Reader
 
 	// Request Middlewares shouldn't have to worry about a nil Header
 	if restReq.Header == nil {
@@ -206,13 +263,85 @@ func (c *RestClient) PerformRequest(restReq *RestRequest) (*RestResponse, error)
 	}
 
 	for _, middleware := range c.RequestMiddlewares {
-		restReq, err = middleware.HandleRequest(restReq)
+		if cm, ok := middleware.(ContextRequestMiddleware); ok {
+			restReq, err = cm.HandleRequestWithContext(ctx, restReq)
+		} else {
+			restReq, err = middleware.HandleRequest(restReq)
+		}
 
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	var resp *RestResponse
+	if len(c.Observers) > 0 {
+		resp, err = c.observe(ctx, restReq)
+	} else {
+		resp, err = c.chain()(ctx, restReq)
+	}
+
+	captureMetadata(ctx, restReq, resp, err)
+	return resp, err
+}
+
+// chain() assembles the client's round-trip middlewares around its final exchange with the server.
+// The retry policy, if any, sits outermost, so that every attempt passes through the remaining middlewares anew;
+// the logger, if any, sits innermost, so that it reports exactly what was exchanged with the server.
+func (c *RestClient) chain() RoundTripFunc {
+	next := RoundTripFunc(c.roundTrip)
+
+	logger, level := c.Logger, c.LogLevel
+	if logger == nil && c.Debug {
+		logger, level = stdoutLogger, LogBodies
+	}
+	if logger != nil {
+		next = bind(&LoggingMiddleware{Logger: logger, Level: level}, next)
+	}
+
+	for i := len(c.RoundTripMiddlewares) - 1; i >= 0; i-- {
+		next = bind(c.RoundTripMiddlewares[i], next)
+	}
+
+	if c.RetryPolicy != nil {
+		next = bind(c.RetryPolicy, next)
+	}
+
+	return next
+}
+
+// roundTrip() performs the exchange proper: it sends the request, passes the response through the response middlewares,
+// and finally vets the resulting status code against the request's expectations.
+func (c *RestClient) roundTrip(ctx context.Context, restReq *RestRequest) (*RestResponse, error) {
+	countAttempt(ctx)
+
+	resp, err := c.do(ctx, restReq)
+	if err != nil {
+		return nil, err
+	}
+
+	restResp := &RestResponse{resp}
+	for _, middleware := range c.ResponseMiddlewares {
+		restResp, err = middleware.HandleResponse(ctx, restReq, restResp)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+
+	if !expectsStatus(restReq, restResp.StatusCode) {
+		return nil, newAPIErrorFromResponse(restResp.Response)
+	}
+
+	return restResp, nil
+}
+
+// do() makes a single attempt at delivering the request to the web server.
+// The request body, if any, is obtained afresh from the RequestBody on every call, so that retried attempts send the complete body.
+func (c *RestClient) do(ctx context.Context, restReq *RestRequest) (*http.Response, error) {
+	var body io.Reader
+	var err error
+
 	if restReq.Body != nil {
 		body, err = restReq.Body.Body()
 		if err != nil {
@@ -220,7 +349,10 @@ func (c *RestClient) PerformRequest(restReq *RestRequest) (*RestResponse, error)
 		}
 	}
 
-	req, err := http.NewRequest(restReq.Method, c.BaseUrl+restReq.Path, body)
+	req, err := http.NewRequestWithContext(ctx, restReq.Method, c.BaseUrl+restReq.Path, body)
+	if err != nil {
+		return nil, err
+	}
 	req.Header = restReq.Header
 
 	if len(req.Header.Get("Accept")) == 0 {
@@ -228,15 +360,12 @@ func (c *RestClient) PerformRequest(restReq *RestRequest) (*RestResponse, error)
 	}
 
 	if restReq.Body != nil {
-		if err != nil {
-			return nil, err
-		}
-
 		contentLength, err := restReq.Body.ContentLength()
 		if err != nil {
 			return nil, err
 		}
 
+		// A negative length leaves net/http to send the body with chunked transfer encoding.
 		req.ContentLength = contentLength
 
 		contentType, err := restReq.Body.ContentType()
@@ -247,44 +376,66 @@ func (c *RestClient) PerformRequest(restReq *RestRequest) (*RestResponse, error)
 		req.Header.Set("Content-Type", contentType)
 	}
 
-	if c.Debug {
-		dump, err := httputil.DumpRequestOut(req, true)
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-		fmt.Println("")
-		fmt.Println(string(dump))
+	if c.Cache != nil {
+		return c.Cache.do(req, c.client.Do)
 	}
+	return c.client.Do(req)
+}
 
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, err
+// expectsStatus() reports whether the status code appears in the request's ExpectedStatusCodes.
+// A request with no expectations accepts every status code.
+func expectsStatus(restReq *RestRequest, status int) bool {
+	if restReq.ExpectedStatusCodes == nil {
+		return true
 	}
 
-	if c.Debug {
-		dump, err := httputil.DumpResponse(resp, true)
-		if err != nil {
-			fmt.Println(err.Error())
+	for _, value := range restReq.ExpectedStatusCodes {
+		if value == status {
+			return true
 		}
-		fmt.Println("")
-		fmt.Println(string(dump))
 	}
 
-	if restReq.ExpectedStatusCodes != nil {
-		for _, value := range restReq.ExpectedStatusCodes {
-			if value == resp.StatusCode {
-				return &RestResponse{resp}, nil
-			}
-		}
-
-		return nil, fmt.Errorf("unexpected HTTP status code: %d", resp.StatusCode)
-	}
+	return false
+}
 
-	return &RestResponse{resp}, nil
+// The SetRetryPolicy() function configures how the client re-attempts requests which fail for transient reasons.
+// Pass nil to disable retries altogether, which is the default.
+func (c *RestClient) SetRetryPolicy(policy *RetryPolicy) {
+	c.RetryPolicy = policy
 }
 
 // The SetDebug() function either enables (true) or disables (false) diagnostic output to stdout
 // of all traffic (request and response alike) made through the client.
+// Credentials are redacted from the output.  SetDebug() has no effect while a Logger is configured; see SetLogger().
 func (c *RestClient) SetDebug(debug bool) {
 	c.Debug = debug
 }
+
+// The SetTimeout() function bounds how long any single request made through the client may take, including reading the response body.
+// Clients start with DefaultTimeout, unless built WithHTTPClient() or WithTimeout().
+// A zero duration means no client-wide limit; per-request deadlines may still be imposed with PerformRequestWithContext().
+// The timeout applies to a copy of the client's net/http client, so that others sharing the latter are unaffected.
+func (c *RestClient) SetTimeout(timeout time.Duration) {
+	cl := *c.client
+	cl.Timeout = timeout
+	c.client = &cl
+}
+
+// stdoutLogger serves clients in debug mode which have no Logger of their own.
+var stdoutLogger = NewWriterLogger(os.Stdout)
+
+// The SetLogger() function reports every exchange made through the client to the given logger, at the given level of detail.
+// Pass a nil logger to stop logging.
+func (c *RestClient) SetLogger(logger Logger, level LogLevel) {
+	c.Logger = logger
+	c.LogLevel = level
+}
+
+// UseClient() configures the RestClient to use a specific net/http client.
+// This allows you to configure a custom HTTP transport for specialized requirements.
+// You normally wouldn't need to set this, as the net/http package makes reasonable
+// choices on its own.  Customized transports are useful, however, if extra logging
+// is required, or if you're using unit tests to isolate and verify correct behavior.
+func (c *RestClient) UseClient(client *http.Client) {
+	c.client = client
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTransport stands in for the network, answering every request with a canned response.
+// If block is set, RoundTrip waits for the request's context to end instead of answering.
+// If statuses is set, successive calls answer with successive status codes, repeating the last one once exhausted.
+// Likewise, if responses is set, successive calls answer with successive bodies.
+type testTransport struct {
+	status    int
+	statuses  []int
+	header    http.Header
+	response  string
+	responses []string
+	block     bool
+	called    int
+	requests  []*http.Request
+	lock      sync.Mutex
+}
+
+func (t *testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.called++
+	t.requests = append(t.requests, req)
+
+	if t.block {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+
+	header := t.header
+	if header == nil {
+		header = http.Header{"Content-Type": []string{"application/json"}}
+	}
+	status := t.status
+	if len(t.statuses) > 0 {
+		i := t.called - 1
+		if i >= len(t.statuses) {
+			i = len(t.statuses) - 1
+		}
+		status = t.statuses[i]
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := t.response
+	if len(t.responses) > 0 {
+		i := t.called - 1
+		if i >= len(t.responses) {
+			i = len(t.responses) - 1
+		}
+		response = t.responses[i]
+	}
+
+	return &http.Response{
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(response)),
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+func withTestClient(t *testTransport) *RestClient {
+	c := MakeRestClient("http://example.com/v1.0")
+	c.client = &http.Client{Transport: t}
+	return c
+}
+
+type markerKey struct{}
+
+type contextMiddleware struct {
+	sawContext bool
+}
+
+func (m *contextMiddleware) HandleRequest(req *RestRequest) (*RestRequest, error) {
+	return req, nil
+}
+
+func (m *contextMiddleware) HandleRequestWithContext(ctx context.Context, req *RestRequest) (*RestRequest, error) {
+	m.sawContext = ctx.Value(markerKey{}) == "present"
+	return req, nil
+}
+
+func TestPerformRequestWithContextCancels(t *testing.T) {
+	transport := &testTransport{block: true}
+	c := withTestClient(transport)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := c.PerformRequestWithContext(ctx, &RestRequest{Method: "GET", Path: "/slow"})
+	if err == nil {
+		t.Error("Expected a deadline error from a hung endpoint")
+		return
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Error("Expected the context deadline to have passed; got", ctx.Err())
+		return
+	}
+}
+
+func TestContextReachesMiddleware(t *testing.T) {
+	transport := &testTransport{response: "{}"}
+	c := withTestClient(transport)
+	m := &contextMiddleware{}
+	c.RequestMiddlewares = []RequestMiddleware{m}
+
+	ctx := context.WithValue(context.Background(), markerKey{}, "present")
+	_, err := c.PerformRequestWithContext(ctx, &RestRequest{Method: "GET", Path: "/", ExpectedStatusCodes: []int{http.StatusOK}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !m.sawContext {
+		t.Error("Expected HandleRequestWithContext to receive the caller's context")
+		return
+	}
+	if transport.requests[0].URL.String() != "http://example.com/v1.0/" {
+		t.Error("Unexpected request URL", transport.requests[0].URL.String())
+		return
+	}
+}
+
+func TestSetTimeoutLeavesCallersClientAlone(t *testing.T) {
+	mine := &http.Client{Transport: &testTransport{response: "{}"}}
+	c := MakeRestClient("http://example.com/v1.0")
+	c.UseClient(mine)
+	c.SetTimeout(3 * time.Second)
+
+	if mine.Timeout != 0 {
+		t.Error("Expected the caller's client to keep its timeout; got", mine.Timeout)
+		return
+	}
+	if c.client.Timeout != 3*time.Second || c.client.Transport != mine.Transport {
+		t.Error("Expected the RestClient's copy to carry the timeout and transport; got", c.client.Timeout, c.client.Transport)
+		return
+	}
+	if http.DefaultClient.Timeout != 0 {
+		t.Error("Expected http.DefaultClient to be untouched")
+		return
+	}
+}
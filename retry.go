@@ -0,0 +1,204 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// The RetryPolicy type describes how a RestClient re-attempts requests that fail for transient reasons.
+//
+// A request is retried when the server answers with a 5xx status (other than 501 Not Implemented),
+// when the server rate-limits the request (429 Too Many Requests, or Rackspace's 413 overLimit fault accompanied by a Retry-After header),
+// or when the connection is reset before a response arrives.
+// Responses whose status code appears in the request's ExpectedStatusCodes are never retried.
+//
+// MaxRetries bounds the number of additional attempts made after the first.
+//
+// Between attempts, the client waits an exponentially growing, randomly jittered delay starting from BaseDelay and never exceeding MaxDelay.
+// If the server supplies a Retry-After header, its value is used instead; should it ask for a longer wait than MaxDelay, the client gives up
+// rather than stalling, and the rate-limited response is handed back to the caller.
+//
+// A RetryPolicy is itself a RoundTripMiddleware; assigning it to a RestClient's RetryPolicy field places it outermost in the chain,
+// but it may equally be listed among the client's RoundTripMiddlewares wherever suits.
+//
+// Only idempotent methods (GET, HEAD, OPTIONS, PUT and DELETE) are retried unless RetryNonIdempotent is set.
+// Set it only if repeating a POST cannot cause harm, e.g., because the API deduplicates requests on its own.
+// Requests whose bodies cannot be sent twice, such as a ReaderRequestBody wrapping a pipe, are never retried.
+type RetryPolicy struct {
+	MaxRetries         int
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy() yields a retry policy suitable for most Rackspace APIs:
+// up to three retries, backing off from half a second up to thirty seconds.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries: 3,
+		BaseDelay:  500 * time.Millisecond,
+		MaxDelay:   30 * time.Second,
+	}
+}
+
+// HandleRoundTrip makes RetryPolicy a RoundTripMiddleware.
+// It invokes next until the exchange either succeeds, fails permanently, or the policy runs out of retries.
+func (p *RetryPolicy) HandleRoundTrip(ctx context.Context, restReq *RestRequest, next RoundTripFunc) (*RestResponse, error) {
+	for retries := 0; ; retries++ {
+		resp, err := next(ctx, restReq)
+		if retries >= p.MaxRetries || !p.retryable(ctx, restReq, resp, err) {
+			return resp, err
+		}
+
+		delay := p.backoff(retries)
+		if after, ok := retryAfter(outcomeHeader(resp, err)); ok {
+			if after > p.MaxDelay {
+				return resp, err
+			}
+			delay = after
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryable() decides whether the outcome of an attempt warrants another.
+func (p *RetryPolicy) retryable(ctx context.Context, restReq *RestRequest, resp *RestResponse, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if !p.RetryNonIdempotent && !isIdempotent(restReq.Method) {
+		return false
+	}
+
+	if body, ok := restReq.Body.(replayableBody); ok && !body.replayable() {
+		return false
+	}
+
+	var status int
+	switch apiErr := AsAPIError(err); {
+	case apiErr != nil:
+		status = apiErr.StatusCode
+	case err != nil:
+		return isConnectionReset(err)
+	case restReq.ExpectedStatusCodes != nil:
+		// The response matched the request's expectations.
+		return false
+	default:
+		status = resp.StatusCode
+	}
+
+	switch {
+	case status == http.StatusTooManyRequests:
+		return true
+	case status == http.StatusRequestEntityTooLarge:
+		_, ok := retryAfter(outcomeHeader(resp, err))
+		return ok
+	case status == http.StatusNotImplemented:
+		return false
+	case status >= 500:
+		return true
+	}
+
+	return false
+}
+
+// outcomeHeader() yields the response headers of an attempt, whether it succeeded or failed with an APIError.
+func outcomeHeader(resp *RestResponse, err error) http.Header {
+	if resp != nil {
+		return resp.Header
+	}
+	if apiErr := AsAPIError(err); apiErr != nil {
+		return apiErr.Header
+	}
+	return nil
+}
+
+// backoff() computes the jittered delay before the given retry; retries count from zero.
+func (p *RetryPolicy) backoff(retries int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 0; i < retries && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
+
+// retryAfter() interprets a Retry-After header, which may hold either a number of seconds or an HTTP date.
+func retryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		delay := time.Until(when)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// isConnectionReset() reports whether a transport error indicates the server dropped the connection out from under us.
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
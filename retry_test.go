@@ -0,0 +1,124 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries: 3,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+	}
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	transport := &testTransport{statuses: []int{503, 502, 200}, response: "{}"}
+	c := withTestClient(transport)
+	c.SetRetryPolicy(testRetryPolicy())
+
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/entities", ExpectedStatusCodes: []int{http.StatusOK}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if transport.called != 3 {
+		t.Error("Expected 3 attempts; got", transport.called)
+		return
+	}
+}
+
+func TestRetryGivesUpAfterMaxRetries(t *testing.T) {
+	transport := &testTransport{status: 500}
+	c := withTestClient(transport)
+	c.SetRetryPolicy(testRetryPolicy())
+
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/entities", ExpectedStatusCodes: []int{http.StatusOK}})
+	if err == nil {
+		t.Error("Expected an error once retries are exhausted")
+		return
+	}
+	if transport.called != 4 {
+		t.Error("Expected 1 attempt plus 3 retries; got", transport.called)
+		return
+	}
+}
+
+func TestRetryHonorsOverLimit(t *testing.T) {
+	transport := &testTransport{
+		statuses: []int{413, 200},
+		header:   http.Header{"Retry-After": []string{"0"}, "Content-Type": []string{"application/json"}},
+		response: "{}",
+	}
+	c := withTestClient(transport)
+	c.SetRetryPolicy(testRetryPolicy())
+
+	body := &JSONRequestBody{Object: map[string]string{"label": "x"}}
+	_, err := c.PerformRequest(&RestRequest{Method: "PUT", Path: "/entities/en1", Body: body, ExpectedStatusCodes: []int{http.StatusOK}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if transport.called != 2 {
+		t.Error("Expected 2 attempts; got", transport.called)
+		return
+	}
+	if transport.requests[1].ContentLength != transport.requests[0].ContentLength {
+		t.Error("Expected the retried request to carry the full body")
+		return
+	}
+}
+
+func TestRetrySkipsPostUnlessOptedIn(t *testing.T) {
+	transport := &testTransport{statuses: []int{503, 201}}
+	c := withTestClient(transport)
+	c.SetRetryPolicy(testRetryPolicy())
+
+	req := &RestRequest{Method: "POST", Path: "/entities", ExpectedStatusCodes: []int{http.StatusCreated}}
+	_, err := c.PerformRequest(req)
+	if err == nil || transport.called != 1 {
+		t.Error("Expected a POST to fail without retrying; attempts:", transport.called)
+		return
+	}
+
+	transport.called = 0
+	c.RetryPolicy.RetryNonIdempotent = true
+	_, err = c.PerformRequest(req)
+	if err != nil || transport.called != 2 {
+		t.Error("Expected an opted-in POST to be retried; attempts:", transport.called, err)
+		return
+	}
+}
+
+func TestRetryAfterTooLongGivesUp(t *testing.T) {
+	transport := &testTransport{
+		status: 429,
+		header: http.Header{"Retry-After": []string{"3600"}},
+	}
+	c := withTestClient(transport)
+	c.SetRetryPolicy(testRetryPolicy())
+
+	_, err := c.PerformRequest(&RestRequest{Method: "GET", Path: "/limits", ExpectedStatusCodes: []int{http.StatusOK}})
+	if err == nil || transport.called != 1 {
+		t.Error("Expected an hour-long Retry-After to end retrying; attempts:", transport.called)
+		return
+	}
+}
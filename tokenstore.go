@@ -0,0 +1,254 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A StoredToken is an authentication response retained by a TokenStore.
+// Response holds the identity service's reply, re-encoded as JSON by the client which obtained it;
+// Expires records when the token it carries ceases to be valid.
+type StoredToken struct {
+	Response json.RawMessage `json:"response"`
+	Expires  time.Time       `json:"expires"`
+}
+
+// Expired() reports whether the token has expired as of the given time.
+func (t *StoredToken) Expired(now time.Time) bool {
+	return !now.Before(t.Expires)
+}
+
+// The TokenStore interface retains authentication responses, keyed by identity service URL and username,
+// so that clients authenticating as the same user may share one token rather than each requesting its own.
+// Get never yields an expired token.
+// Implementations must be safe for concurrent use.
+// See NewMemoryTokenStore() and NewFileTokenStore().
+type TokenStore interface {
+	Get(authURL, username string) (*StoredToken, bool)
+	Set(authURL, username string, token *StoredToken)
+	Delete(authURL, username string)
+}
+
+func tokenKey(authURL, username string) string {
+	return authURL + " " + username
+}
+
+// A MemoryTokenStore shares tokens amongst the clients of a single process.
+type MemoryTokenStore struct {
+	tokens map[string]*StoredToken
+	lock   sync.Mutex
+}
+
+// NewMemoryTokenStore() creates an empty in-memory token store.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: map[string]*StoredToken{},
+	}
+}
+
+// Get implements the TokenStore interface.
+func (s *MemoryTokenStore) Get(authURL, username string) (*StoredToken, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := tokenKey(authURL, username)
+	token, ok := s.tokens[key]
+	if !ok {
+		return nil, false
+	}
+	if token.Expired(time.Now()) {
+		delete(s.tokens, key)
+		return nil, false
+	}
+	return token, true
+}
+
+// Set implements the TokenStore interface.
+func (s *MemoryTokenStore) Set(authURL, username string, token *StoredToken) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokens[tokenKey(authURL, username)] = token
+}
+
+// Delete implements the TokenStore interface.
+func (s *MemoryTokenStore) Delete(authURL, username string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.tokens, tokenKey(authURL, username))
+}
+
+// ErrTokenStoreLocked is returned by FileTokenStore.Update() when another process holds the store's lock for longer than LockTimeout.
+var ErrTokenStoreLocked = errors.New("token store is locked by another process")
+
+// A FileTokenStore shares tokens amongst processes, such as successive invocations of a command-line tool, through a single file.
+// The file is readable and writable by its owner alone, since the tokens within it grant access to their users' accounts.
+//
+// Processes updating the file take turns, holding a lock file alongside it (the store's path with ".lock" appended) while they do so.
+// A lock file older than StaleLockAge is presumed abandoned by a process which died holding it, and is removed.
+// The file itself is replaced atomically, so readers never see it half-written.
+//
+// Expired tokens are never yielded, and are discarded whenever the file is rewritten.
+// Failures to read or write the file are not reported; the store behaves as though it held no token, and clients simply authenticate afresh.
+type FileTokenStore struct {
+	path         string
+	LockTimeout  time.Duration
+	StaleLockAge time.Duration
+	lock         sync.Mutex
+}
+
+// NewFileTokenStore() creates a store keeping its tokens in the named file, creating its directory if necessary.
+// The lock times out after 10 seconds, and lock files older than a minute are presumed stale.
+func NewFileTokenStore(path string) (*FileTokenStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return &FileTokenStore{
+		path:         path,
+		LockTimeout:  10 * time.Second,
+		StaleLockAge: time.Minute,
+	}, nil
+}
+
+// Path() yields the name of the store's file.
+func (s *FileTokenStore) Path() string {
+	return s.path
+}
+
+// Get implements the TokenStore interface.
+func (s *FileTokenStore) Get(authURL, username string) (*StoredToken, bool) {
+	token, ok := s.read()[tokenKey(authURL, username)]
+	if !ok || token.Expired(time.Now()) {
+		return nil, false
+	}
+	return token, true
+}
+
+// Set implements the TokenStore interface.
+func (s *FileTokenStore) Set(authURL, username string, token *StoredToken) {
+	s.Update(func(tokens map[string]*StoredToken) {
+		tokens[tokenKey(authURL, username)] = token
+	})
+}
+
+// Delete implements the TokenStore interface.
+func (s *FileTokenStore) Delete(authURL, username string) {
+	s.Update(func(tokens map[string]*StoredToken) {
+		delete(tokens, tokenKey(authURL, username))
+	})
+}
+
+// Update() rewrites the store's file with the changes f makes to its unexpired tokens, which are keyed by auth URL and username separated by a space.
+// It holds the store's lock throughout, so that concurrent updates, even from other processes, are never lost.
+func (s *FileTokenStore) Update(f func(tokens map[string]*StoredToken)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer os.Remove(s.path + ".lock")
+
+	tokens := s.read()
+	now := time.Now()
+	for key, token := range tokens {
+		if token.Expired(now) {
+			delete(tokens, key)
+		}
+	}
+	f(tokens)
+
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// read() yields the tokens in the store's file, or none at all if it cannot be read.
+// Files which others may read are ignored, lest they have been planted.
+func (s *FileTokenStore) read() map[string]*StoredToken {
+	tokens := map[string]*StoredToken{}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return tokens
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.Mode().Perm()&0077 != 0 {
+		return tokens
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return tokens
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return map[string]*StoredToken{}
+	}
+	for key, token := range tokens {
+		if token == nil {
+			delete(tokens, key)
+		}
+	}
+	return tokens
+}
+
+// acquire() creates the store's lock file, waiting for any other process holding it to finish, or for LockTimeout to pass.
+func (s *FileTokenStore) acquire() error {
+	name := s.path + ".lock"
+	deadline := time.Now().Add(s.LockTimeout)
+	for {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return f.Close()
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > s.StaleLockAge {
+			os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return ErrTokenStoreLocked
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func storedToken(id string, ttl time.Duration) *StoredToken {
+	return &StoredToken{
+		Response: []byte(`{"access":{"token":{"id":"` + id + `"}}}`),
+		Expires:  time.Now().Add(ttl),
+	}
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+	s := NewMemoryTokenStore()
+	s.Set("https://identity.example.com/v2.0", "joe", storedToken("t1", time.Hour))
+	s.Set("https://identity.example.com/v2.0", "ann", storedToken("t2", -time.Second))
+
+	token, ok := s.Get("https://identity.example.com/v2.0", "joe")
+	if !ok || string(token.Response) != string(storedToken("t1", 0).Response) {
+		t.Error("Expected the stored token; got", token, ok)
+		return
+	}
+	if _, ok := s.Get("https://identity.example.com/v2.0", "ann"); ok {
+		t.Error("Expected an expired token to be withheld")
+		return
+	}
+	if _, ok := s.Get("https://lon.identity.example.com/v2.0", "joe"); ok {
+		t.Error("Expected tokens to be keyed by auth URL")
+		return
+	}
+
+	s.Delete("https://identity.example.com/v2.0", "joe")
+	if _, ok := s.Get("https://identity.example.com/v2.0", "joe"); ok {
+		t.Error("Expected a deleted token to be gone")
+		return
+	}
+}
+
+func TestFileTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorax-tokens")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sub", "tokens.json")
+	s, err := NewFileTokenStore(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.Set("https://identity.example.com/v2.0", "joe", storedToken("t1", time.Hour))
+	s.Set("https://identity.example.com/v2.0", "ann", storedToken("t2", -time.Second))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Error("Expected the token file to be private to its owner; got", info.Mode().Perm())
+		return
+	}
+
+	other, err := NewFileTokenStore(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := other.Get("https://identity.example.com/v2.0", "joe"); !ok {
+		t.Error("Expected the token to be shared through the file")
+		return
+	}
+	if _, ok := other.Get("https://identity.example.com/v2.0", "ann"); ok {
+		t.Error("Expected an expired token to be withheld")
+		return
+	}
+
+	os.Chmod(path, 0644)
+	if _, ok := other.Get("https://identity.example.com/v2.0", "joe"); ok {
+		t.Error("Expected a token file others may read to be ignored")
+		return
+	}
+}
+
+func TestFileTokenStoreLocking(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorax-tokens")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens.json")
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		s, err := NewFileTokenStore(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			s.Set("https://identity.example.com/v2.0", user, storedToken(user, time.Hour))
+		}(string(rune('a' + n)))
+	}
+	wg.Wait()
+
+	s, _ := NewFileTokenStore(path)
+	for n := 0; n < 10; n++ {
+		if _, ok := s.Get("https://identity.example.com/v2.0", string(rune('a'+n))); !ok {
+			t.Error("Expected concurrent updates never to be lost; missing", string(rune('a'+n)))
+			return
+		}
+	}
+
+	ioutil.WriteFile(path+".lock", nil, 0600)
+	s.LockTimeout = 50 * time.Millisecond
+	if err := s.Update(func(map[string]*StoredToken) {}); err != ErrTokenStoreLocked {
+		t.Error("Expected a held lock to time out; got", err)
+		return
+	}
+
+	old := time.Now().Add(-2 * s.StaleLockAge)
+	os.Chtimes(path+".lock", old, old)
+	if err := s.Update(func(map[string]*StoredToken) {}); err != nil {
+		t.Error("Expected a stale lock to be broken; got", err)
+		return
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2013 Rackspace
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS-IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gorax
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader names the W3C Trace Context header that carries a request's trace and parent span.
+const TraceparentHeader = "Traceparent"
+
+// A TraceContext identifies a span within a distributed trace, as carried by the W3C traceparent header.
+// See https://www.w3.org/TR/trace-context/ for details.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// TraceFlagSampled marks a trace as sampled by its originator.
+const TraceFlagSampled byte = 0x01
+
+// ParseTraceparent() decodes a traceparent header value.
+// Only version 00 of the format is understood; other versions, and all-zero trace or span IDs, are rejected.
+func ParseTraceparent(s string) (TraceContext, error) {
+	var tc TraceContext
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return tc, fmt.Errorf("malformed traceparent: %q", s)
+	}
+
+	var flags [1]byte
+	if _, err := hex.Decode(tc.TraceID[:], []byte(parts[1])); err != nil {
+		return tc, fmt.Errorf("malformed traceparent: %q", s)
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(parts[2])); err != nil {
+		return tc, fmt.Errorf("malformed traceparent: %q", s)
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return tc, fmt.Errorf("malformed traceparent: %q", s)
+	}
+	tc.Flags = flags[0]
+
+	if tc.TraceID == [16]byte{} || tc.SpanID == [8]byte{} {
+		return tc, fmt.Errorf("invalid traceparent: %q", s)
+	}
+	return tc, nil
+}
+
+// String() encodes the trace context as a traceparent header value.
+func (tc TraceContext) String() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(tc.TraceID[:]), hex.EncodeToString(tc.SpanID[:]), tc.Flags)
+}
+
+type traceKey struct{}
+
+// WithTraceContext() yields a context carrying the given trace context.
+// Requests made under the returned context become children of that span; see TraceObserver.
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+// TraceContextFrom() yields the trace context carried by ctx, if any.
+func TraceContextFrom(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(TraceContext)
+	return tc, ok
+}
+
+// A TraceObserver is an Observer which propagates W3C Trace Context to the services a RestClient talks to.
+//
+// Each request is given a span of its own, whose parent is the span carried by the request's context (see WithTraceContext()),
+// or else the span named by a traceparent header already present on the request.
+// Failing both, the request begins a new, sampled trace.
+// The span is announced to the server in the request's traceparent header, and is carried by the context handed to later observers.
+//
+// If OnFinish is set, it is called with each span and the request it covered once the request completes,
+// which allows spans to be exported to a tracing system.
+type TraceObserver struct {
+	OnFinish func(span, parent TraceContext, info *RequestInfo)
+}
+
+type parentSpanKey struct{}
+
+// RequestStarted begins a span for the request and stamps it onto the headers of the request as sent.
+// The RestClient hands it a copy of the request's headers, so a RestRequest sent again begins a fresh trace, not a child of its last span.
+func (o *TraceObserver) RequestStarted(ctx context.Context, info *RequestInfo, header http.Header) context.Context {
+	parent, ok := TraceContextFrom(ctx)
+	if !ok {
+		parent, _ = ParseTraceparent(header.Get(TraceparentHeader))
+	}
+
+	span := parent
+	if span.TraceID == [16]byte{} {
+		rand.Read(span.TraceID[:])
+		span.Flags = TraceFlagSampled
+	}
+	rand.Read(span.SpanID[:])
+
+	header.Set(TraceparentHeader, span.String())
+	return context.WithValue(WithTraceContext(ctx, span), parentSpanKey{}, parent)
+}
+
+// RequestFinished reports the request's span to OnFinish, if set.
+func (o *TraceObserver) RequestFinished(ctx context.Context, info *RequestInfo) {
+	if o.OnFinish == nil {
+		return
+	}
+	span, _ := TraceContextFrom(ctx)
+	parent, _ := ctx.Value(parentSpanKey{}).(TraceContext)
+	o.OnFinish(span, parent, info)
+}
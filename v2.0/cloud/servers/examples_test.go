@@ -10,6 +10,7 @@ package servers
 
 import (
 	"fmt"
+	"github.com/racker/gorax/recorder"
 	"github.com/racker/gorax/v2.0/identity"
 )
 
@@ -19,8 +20,16 @@ const (
 )
 
 func ExampleRegionByName() {
-	id := identity.NewIdentity(USERNAME, APIKEY, "")
-	err := id.Authenticate()
+	// The example replays a recorded session, so that it runs without a Rackspace account.
+	// Leave out the recorder and the UseClient() calls to talk to Rackspace itself.
+	rec, err := recorder.New("testdata/region.json", recorder.Replay)
+	if err != nil {
+		panic(err)
+	}
+
+	id := identity.NewIdentityWithAPIKey(USERNAME, APIKEY, "")
+	id.UseClient(rec.Client())
+	err = id.Authenticate()
 	if err != nil {
 		panic(err)
 	}
@@ -29,6 +38,7 @@ func ExampleRegionByName() {
 	if err != nil {
 		panic(err)
 	}
+	region.UseClient(rec.Client())
 
 	images, err := region.Images()
 	if err != nil {
@@ -39,4 +49,8 @@ func ExampleRegionByName() {
 	for _, i := range images {
 		fmt.Printf("%20s  %s\n", string(i.Id[0:20]), i.Name)
 	}
+	// Output:
+	// UUID  Name
+	// a3a2c42f-575f-4381-9  CentOS 5.8
+	// a3a2c42f-575f-4381-9  CentOS 6.0
 }
@@ -3,8 +3,9 @@
 package servers
 
 import (
-	"fmt"
+	"errors"
 	"github.com/racker/gorax/v2.0/identity"
+	"regexp"
 	"strings"
 )
 
@@ -20,20 +21,56 @@ import (
 //
 // Region names are case insensitive for convenience; however,
 // they're traditionally written in all uppercase letters.
-func RegionByName(id identity.Identity, region string) (r Region, err error) {
-	sc, err := id.ServiceCatalog()
+// Pass "" to use the user's default region.
+//
+// The region is reached through its public URL; see RegionByEndpoint to use ServiceNet instead.
+func RegionByName(id identity.Identity, region string) (Region, error) {
+	return RegionByEndpoint(id, identity.EndpointOpts{Region: region})
+}
+
+// RegionByEndpoint grants access to the region whose compute endpoint satisfies opts.
+// The service type defaults to "compute", and the version to "2", since only OpenStack cloud servers are supported.
+// Should no endpoint of version 2 be found, an endpoint declaring no version at all will do, as when a private cloud publishes nova's unversioned root;
+// name a Version to insist upon it.
+//
+// For example, servers within Rackspace may reach the API over ServiceNet, free of bandwidth charges, with
+//
+//	servers.RegionByEndpoint(id, identity.EndpointOpts{Region: "DFW", Interface: identity.InternalInterface})
+//
+// If no endpoint satisfies opts, the error is an *identity.EndpointNotFoundError listing those the catalog does offer.
+func RegionByEndpoint(id identity.Identity, opts identity.EndpointOpts) (Region, error) {
+	if opts.Type == "" {
+		opts.Type = "compute"
+	}
+	versioned := opts
+	if versioned.Version == "" {
+		versioned.Version = "2"
+	}
+
+	ep, err := identity.ResolveEndpoint(id, versioned)
+	if opts.Version == "" && errors.Is(err, identity.ErrEndpointNotFound) {
+		if unversioned, uerr := identity.ResolveEndpoint(id, opts); uerr == nil && !declaresVersion(unversioned) {
+			ep, err = unversioned, nil
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
+	return makeRegionalClient(id, ep.Endpoint, ep.URL)
+}
+
+// versionSegment matches a version segment of an endpoint's URL, such as "v1.0" or "v2.1".
+var versionSegment = regexp.MustCompile(`^v[0-9]+(\.[0-9]+)*$`)
 
-	for _, entry := range sc {
-		if entry.Type == "compute" {
-			for _, endpoint := range entry.Endpoints {
-				if strings.ToUpper(endpoint.Region) == strings.ToUpper(region) {
-					return makeRegionalClient(id, endpoint)
-				}
-			}
+// declaresVersion reports whether a resolved endpoint names its API version, by its VersionId or a segment of its URL.
+func declaresVersion(ep *identity.ResolvedEndpoint) bool {
+	if ep.Endpoint.VersionId != "" {
+		return true
+	}
+	for _, segment := range strings.Split(strings.ToLower(ep.URL), "/") {
+		if versionSegment.MatchString(segment) {
+			return true
 		}
 	}
-	return nil, fmt.Errorf("Unsupported region or V1.0 services only")
+	return false
 }
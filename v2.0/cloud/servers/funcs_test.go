@@ -3,6 +3,7 @@
 package servers
 
 import (
+	"errors"
 	"github.com/racker/gorax/v2.0/identity"
 	"testing"
 )
@@ -20,6 +21,13 @@ func (i *myIdCard) Password() string {
 func (i *myIdCard) SetCredentials(userName, key, reg string) {
 }
 
+func (i *myIdCard) SetAPIKeyCredentials(userName, apiKey, reg string) {
+}
+
+func (i *myIdCard) APIKey() string {
+	return ""
+}
+
 func (i *myIdCard) Username() string {
 	return "my-username"
 }
@@ -111,6 +119,7 @@ func (i *myIdCard2) ServiceCatalog() (sc []identity.CatalogEntry, err error) {
 			Endpoints: []identity.EntryEndpoint{
 				identity.EntryEndpoint{
 					PublicURL:   "https://dfw.servers.api.rackspacecloud.com/v2/775360",
+					InternalURL: "https://snet-dfw.servers.api.rackspacecloud.com/v2/775360",
 					Region:      "DFW",
 					TenantId:    "775360",
 					VersionId:   "2",
@@ -136,6 +145,28 @@ func fakeId2() identity.Identity {
 	return &myIdCard2{}
 }
 
+// The unversionedIdCard structure substitutes for an identity of a private OpenStack cloud,
+// whose catalog publishes nova's unversioned root.
+type unversionedIdCard struct {
+	myIdCard
+}
+
+func (i *unversionedIdCard) ServiceCatalog() (sc []identity.CatalogEntry, err error) {
+	sc = []identity.CatalogEntry{
+		identity.CatalogEntry{
+			Name: "nova",
+			Type: "compute",
+			Endpoints: []identity.EntryEndpoint{
+				identity.EntryEndpoint{
+					PublicURL: "https://nova.example.com:8774",
+					Region:    "RegionOne",
+				},
+			},
+		},
+	}
+	return
+}
+
 /****** Unit Tests ******/
 
 func TestInRegion(t *testing.T) {
@@ -173,3 +204,43 @@ func TestInRegion(t *testing.T) {
 		return
 	}
 }
+
+func TestRegionByNameWithoutVersion(t *testing.T) {
+	region, err := RegionByName(&unversionedIdCard{}, "regionone")
+	if err != nil {
+		t.Error("InRegion: an unversioned compute endpoint should be accepted; got", err)
+		return
+	}
+	api, _ := region.EndpointByName("servers")
+	if api != "https://nova.example.com:8774/servers" {
+		t.Error("InRegion: expected the unversioned URL; got", api)
+		return
+	}
+
+	_, err = RegionByEndpoint(&unversionedIdCard{}, identity.EndpointOpts{Region: "regionone", Version: "2"})
+	if err == nil {
+		t.Error("InRegion: an explicit version should not match an unversioned endpoint")
+		return
+	}
+}
+
+func TestRegionByEndpoint(t *testing.T) {
+	id := fakeId2()
+
+	region, err := RegionByEndpoint(id, identity.EndpointOpts{Region: "dfw", Interface: identity.InternalInterface})
+	if err != nil {
+		t.Error("RegionByEndpoint: ServiceNet endpoint should be found; got", err)
+		return
+	}
+	api, _ := region.EndpointByName("servers")
+	if api != "https://snet-dfw.servers.api.rackspacecloud.com/v2/775360/servers" {
+		t.Error("RegionByEndpoint: expected the ServiceNet URL; got", api)
+		return
+	}
+
+	_, err = RegionByEndpoint(id, identity.EndpointOpts{Region: "ord", Interface: identity.InternalInterface})
+	if !errors.Is(err, identity.ErrEndpointNotFound) {
+		t.Error("RegionByEndpoint: expected ErrEndpointNotFound for a region without ServiceNet; got", err)
+		return
+	}
+}
@@ -3,7 +3,9 @@
 package servers
 
 import (
+	"github.com/racker/gorax"
 	"net/http"
+	"time"
 )
 
 // A Region represents a geographical area with cloud computing resources.
@@ -23,3 +25,20 @@ type Region interface {
 	UseClient(*http.Client)
 	EndpointByName(string) (string, error)
 }
+
+// A ConfigurableRegion lets the caching, retries, logging, observers, timeouts and circuit breaking of its requests be configured,
+// as a gorax.RestClient's may be.
+// Every Region this package yields is one; to configure it, assert for the interface, e.g.,
+//
+//	region.(servers.ConfigurableRegion).SetRetryPolicy(gorax.DefaultRetryPolicy())
+//
+// It stands apart from Region so that implementations of Region outside this package need not provide these methods.
+type ConfigurableRegion interface {
+	Region
+	AddObserver(gorax.Observer)
+	SetCache(*gorax.Cache)
+	SetRetryPolicy(*gorax.RetryPolicy)
+	SetLogger(gorax.Logger, gorax.LogLevel)
+	SetTimeout(time.Duration)
+	UseCircuitBreaker(*gorax.CircuitBreaker)
+}
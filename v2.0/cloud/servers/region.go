@@ -3,46 +3,129 @@
 package servers
 
 import (
+	"context"
 	"fmt"
+	"github.com/racker/gorax"
 	"github.com/racker/gorax/v2.0/identity"
-	"github.com/racker/perigee"
 	"net/http"
+	"time"
 )
 
+// TokenRefreshMargin is how long before its token expires that a region asks its identity for a new one.
+// Only identities implementing identity.Reauthenticator can renew their tokens; others are used as they stand.
+var TokenRefreshMargin = 5 * time.Minute
+
 // A raxRegion represents a Rackspace-hosted region.
+// Its requests flow through a gorax.RestClient rooted at the region's compute endpoint,
+// so that retries, logging, caching and custom transports behave just as they do for the monitoring client.
 type raxRegion struct {
 	id            identity.Identity
 	entryEndpoint identity.EntryEndpoint
-	httpClient    *http.Client
-	token         string
+	url           string
+	client        *gorax.RestClient
+}
+
+// A tokenMiddleware stamps each of a region's requests with its identity's current authentication token.
+// If the identity can re-authenticate, the middleware renews the token shortly before it expires,
+// and again whenever the region rejects it, replaying the rejected request once with the new token.
+type tokenMiddleware struct {
+	region  *raxRegion
+	renewer identity.Renewer
+}
+
+// HandleRequest adds the identity's X-Auth-Token header to the request, first renewing the token if it's about to expire.
+// A failed renewal is reported only if the old token has expired outright; until then, the old token remains usable,
+// and further renewals wait for identity.RenewalBackoff to pass.
+func (m *tokenMiddleware) HandleRequest(req *gorax.RestRequest) (*gorax.RestRequest, error) {
+	return m.HandleRequestWithContext(context.Background(), req)
+}
+
+// HandleRequestWithContext behaves like HandleRequest, but gives up on renewing the token if the context ends first.
+func (m *tokenMiddleware) HandleRequestWithContext(ctx context.Context, req *gorax.RestRequest) (*gorax.RestRequest, error) {
+	id := m.region.id
+	if reauth, ok := id.(identity.Reauthenticator); ok {
+		if err := m.renewer.Renew(ctx, reauth, TokenRefreshMargin); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := id.Token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", token)
+	return req, nil
+}
+
+// HandleRoundTrip replays a request the region rejects as unauthorized, once, with a renewed token.
+// Concurrent requests rejected with the same token share a single renewal; see identity.Reauthenticator.
+func (m *tokenMiddleware) HandleRoundTrip(ctx context.Context, req *gorax.RestRequest, next gorax.RoundTripFunc) (*gorax.RestResponse, error) {
+	resp, err := next(ctx, req)
+	reauth, ok := m.region.id.(identity.Reauthenticator)
+	if !ok || !gorax.IsUnauthorized(err) {
+		return resp, err
+	}
+
+	if rerr := reauth.ReauthenticateWithContext(ctx, req.Header.Get("X-Auth-Token")); rerr != nil {
+		return resp, err
+	}
+	token, rerr := reauth.Token()
+	if rerr != nil {
+		return resp, err
+	}
+
+	replay := *req
+	replay.Header = req.Header.Clone()
+	replay.Header.Set("X-Auth-Token", token)
+	return next(ctx, &replay)
+}
+
+// perform issues a request against the region's compute endpoint on behalf of the named operation.
+// The body, if not nil, is sent as JSON; the response, if results is not nil, is decoded into it.
+// Any status code other than those expected yields a *gorax.APIError.
+func (r *raxRegion) perform(operation, method, path string, body interface{}, results interface{}, expected ...int) error {
+	restReq := &gorax.RestRequest{
+		Method:              method,
+		Path:                path,
+		ExpectedStatusCodes: expected,
+		Operation:           operation,
+	}
+	if body != nil {
+		restReq.Body = &gorax.JSONRequestBody{Object: body}
+	}
+
+	resp, err := r.client.PerformRequest(restReq)
+	if err != nil {
+		return err
+	}
+	if results == nil {
+		resp.Body.Close()
+		return nil
+	}
+
+	// Presume JSON of responses which don't say otherwise, as the compute API sometimes omits the header.
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	if resp.Header.Get("Content-Type") == "" {
+		resp.Header.Set("Content-Type", "application/json")
+	}
+	return resp.DeserializeBody(results)
 }
 
 // Flavors method provides a complete list of machine configurations (called flavors) available at the region.
 func (r *raxRegion) Flavors() ([]Flavor, error) {
 	var fs []Flavor
 
-	url, _ := r.EndpointByName("flavors")
-	err := perigee.Get(url, perigee.Options{
-		CustomClient: r.httpClient,
-		Results:      &struct{ Flavors *[]Flavor }{&fs},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
+	err := r.perform("servers.Flavors", "GET", "/flavors", nil, &struct{ Flavors *[]Flavor }{&fs}, http.StatusOK)
 	return fs, err
 }
 
 // Images method provides a complete list of images hosted at the region.
 func (r *raxRegion) Images() ([]Image, error) {
 	var is []Image
-	url, _ := r.EndpointByName("images")
-	err := perigee.Get(url, perigee.Options{
-		CustomClient: r.httpClient,
-		Results:      &struct{ Images *[]Image }{&is},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
+
+	err := r.perform("servers.Images", "GET", "/images", nil, &struct{ Images *[]Image }{&is}, http.StatusOK)
 	return is, err
 }
 
@@ -51,14 +134,7 @@ func (r *raxRegion) Images() ([]Image, error) {
 func (r *raxRegion) Servers() ([]Server, error) {
 	var ss []Server
 
-	url, _ := r.EndpointByName("servers/detail")
-	err := perigee.Get(url, perigee.Options{
-		CustomClient: r.httpClient,
-		Results:      &struct{ Servers *[]Server }{&ss},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
+	err := r.perform("servers.Servers", "GET", "/servers/detail", nil, &struct{ Servers *[]Server }{&ss}, http.StatusOK)
 	return ss, err
 }
 
@@ -74,20 +150,12 @@ func (r *raxRegion) Servers() ([]Server, error) {
 func (r *raxRegion) CreateServer(ns NewServer) (*NewServer, error) {
 	var s *NewServer
 
-	ep, err := r.EndpointByName("servers")
-	if err != nil {
-		return nil, err
-	}
-	err = perigee.Post(ep, perigee.Options{
-		ReqBody: &struct {
+	err := r.perform("servers.CreateServer", "POST", "/servers",
+		&struct {
 			Server *NewServer `json:"server"`
 		}{&ns},
-		Results: &struct{ Server **NewServer }{&s},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-		OkCodes: []int{202},
-	})
+		&struct{ Server **NewServer }{&s},
+		http.StatusAccepted)
 	return s, err
 }
 
@@ -96,29 +164,14 @@ func (r *raxRegion) CreateServer(ns NewServer) (*NewServer, error) {
 func (r *raxRegion) ServerInfoById(id string) (*Server, error) {
 	var s *Server
 
-	baseUrl, err := r.EndpointByName("servers")
-	serverUrl := fmt.Sprintf("%s/%s", baseUrl, id)
-	err = perigee.Get(serverUrl, perigee.Options{
-		Results: &struct{ Server **Server }{&s},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
+	err := r.perform("servers.ServerInfoById", "GET", fmt.Sprintf("/servers/%s", id), nil, &struct{ Server **Server }{&s}, http.StatusOK)
 	return s, err
 }
 
 // DeleteServerById requests that the server with the specified ID
 // be removed from your account.  The delete happens asynchronously.
 func (r *raxRegion) DeleteServerById(id string) error {
-	baseUrl, err := r.EndpointByName("servers")
-	serverUrl := fmt.Sprintf("%s/%s", baseUrl, id)
-	err = perigee.Delete(serverUrl, perigee.Options{
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-		OkCodes: []int{204},
-	})
-	return err
+	return r.perform("servers.DeleteServerById", "DELETE", fmt.Sprintf("/servers/%s", id), nil, nil, http.StatusNoContent)
 }
 
 // SetAdminPassword requests that the server with the specified ID
@@ -127,79 +180,49 @@ func (r *raxRegion) DeleteServerById(id string) error {
 // root user.  For Windows machines, the Administrator user will be
 // affected.
 func (r *raxRegion) SetAdminPassword(id string, pw string) error {
-	ep, err := r.EndpointByName("servers")
-	if err != nil {
-		return err
-	}
-	endpoint := fmt.Sprintf("%s/%s/action", ep, id)
-	err = perigee.Post(endpoint, perigee.Options{
-		ReqBody: &struct {
-			ChangePassword struct {
-				AdminPass string `json:"adminPass"`
-			} `json:"changePassword"`
-		}{
-			struct {
-				AdminPass string `json:"adminPass"`
-			}{pw},
-		},
-		OkCodes: []int{202},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
-	return err
+	return r.action("servers.SetAdminPassword", id, &struct {
+		ChangePassword struct {
+			AdminPass string `json:"adminPass"`
+		} `json:"changePassword"`
+	}{
+		struct {
+			AdminPass string `json:"adminPass"`
+		}{pw},
+	}, nil, http.StatusAccepted)
 }
 
 // RebootServer requests that the server with the specified ID be rebooted.
 // Two reboot mechanisms exist.
 //
-// - Hard.  This will physically power-cycle the unit.
-// - Soft.  This will attempt to use the server's software-based mechanisms to restart the machine.
-//          E.g., "shutdown -r now" on Linux.
-
+//   - Hard.  This will physically power-cycle the unit.
+//   - Soft.  This will attempt to use the server's software-based mechanisms to restart the machine.
+//     E.g., "shutdown -r now" on Linux.
 func (r *raxRegion) RebootServer(id string, isHard bool) error {
-	baseUrl, err := r.EndpointByName("servers")
-	serverUrl := fmt.Sprintf("%s/%s/action", baseUrl, id)
 	typ := "SOFT"
 	if isHard {
 		typ = "HARD"
 	}
-	err = perigee.Post(serverUrl, perigee.Options{
-		ReqBody: &struct {
-			Reboot struct {
-				Type string `json:"type"`
-			} `json:"reboot"`
-		}{
-			struct {
-				Type string `json:"type"`
-			}{typ},
-		},
-		OkCodes: []int{202},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
-	return err
+	return r.action("servers.RebootServer", id, &struct {
+		Reboot struct {
+			Type string `json:"type"`
+		} `json:"reboot"`
+	}{
+		struct {
+			Type string `json:"type"`
+		}{typ},
+	}, nil, http.StatusAccepted)
 }
 
 // RebuildServer removes all data on the server and replaces it with the specified image
 // on the specified flavor.
 func (r *raxRegion) RebuildServer(id string, ns NewServer) (*Server, error) {
 	var s *Server
-	baseUrl, err := r.EndpointByName("servers")
-	serverUrl := fmt.Sprintf("%s/%s/action", baseUrl, id)
-	err = perigee.Post(serverUrl, perigee.Options{
-		ReqBody: &struct {
-			Rebuild NewServer `json:"rebuild"`
-		}{Rebuild: ns},
-		Results: &struct {
-			Server **Server `json:"server"`
-		}{&s},
-		OkCodes: []int{202},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
+
+	err := r.action("servers.RebuildServer", id, &struct {
+		Rebuild NewServer `json:"rebuild"`
+	}{Rebuild: ns}, &struct {
+		Server **Server `json:"server"`
+	}{&s}, http.StatusAccepted)
 	return s, err
 }
 
@@ -209,56 +232,34 @@ func (r *raxRegion) RebuildServer(id string, ns NewServer) (*Server, error) {
 // to be confirmed even without an explicit confirmation after 24 hours from the initial
 // request.
 func (r *raxRegion) ResizeServer(id, name, flavor, diskConfig string) error {
-	baseUrl, err := r.EndpointByName("servers")
-	serverUrl := fmt.Sprintf("%s/%s/action", baseUrl, id)
 	rr := ResizeRequest{
 		Name:       name,
 		FlavorRef:  flavor,
 		DiskConfig: diskConfig,
 	}
-	err = perigee.Post(serverUrl, perigee.Options{
-		ReqBody: &struct {
-			Resize ResizeRequest `json:"resize"`
-		}{rr},
-		OkCodes: []int{202},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
-	return err
+	return r.action("servers.ResizeServer", id, &struct {
+		Resize ResizeRequest `json:"resize"`
+	}{rr}, nil, http.StatusAccepted)
 }
 
 // ConfirmResizeServer will acknowledge a server's resized configuration.
 func (r *raxRegion) ConfirmResizeServer(id string) error {
-	baseUrl, err := r.EndpointByName("servers")
-	serverUrl := fmt.Sprintf("%s/%s/action", baseUrl, id)
-	err = perigee.Post(serverUrl, perigee.Options{
-		ReqBody: &struct {
-			ConfirmResize *int `json:"confirmResize"`
-		}{nil},
-		OkCodes: []int{204},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
-	return err
+	return r.action("servers.ConfirmResizeServer", id, &struct {
+		ConfirmResize *int `json:"confirmResize"`
+	}{nil}, nil, http.StatusNoContent)
 }
 
 // RevertResizeServer will reject a server's resized configuration, thus
 // rolling back to the original server.
 func (r *raxRegion) RevertResizeServer(id string) error {
-	baseUrl, err := r.EndpointByName("servers")
-	serverUrl := fmt.Sprintf("%s/%s/action", baseUrl, id)
-	err = perigee.Post(serverUrl, perigee.Options{
-		ReqBody: &struct {
-			RevertResize *int `json:"revertResize"`
-		}{nil},
-		OkCodes: []int{204},
-		MoreHeaders: map[string]string{
-			"X-Auth-Token": r.token,
-		},
-	})
-	return err
+	return r.action("servers.RevertResizeServer", id, &struct {
+		RevertResize *int `json:"revertResize"`
+	}{nil}, nil, http.StatusNoContent)
+}
+
+// action posts the body to the action resource of the server with the given ID.
+func (r *raxRegion) action(operation, id string, body interface{}, results interface{}, expected ...int) error {
+	return r.perform(operation, "POST", fmt.Sprintf("/servers/%s/action", id), body, results, expected...)
 }
 
 // EndpointByName computes a resource URL, assuming a valid name.
@@ -268,14 +269,14 @@ func (r *raxRegion) RevertResizeServer(id string) error {
 // This method exists and is publicly available only to support testing.
 func (r *raxRegion) EndpointByName(name string) (string, error) {
 	var supportedEndpoint map[string]bool = map[string]bool{
-		"images":  true,
-		"flavors": true,
-		"servers": true,
+		"images":         true,
+		"flavors":        true,
+		"servers":        true,
 		"servers/detail": true,
 	}
 
 	if supportedEndpoint[name] {
-		api := fmt.Sprintf("%s/%s", r.entryEndpoint.PublicURL, name)
+		api := fmt.Sprintf("%s/%s", r.url, name)
 		return api, nil
 	}
 	return "", fmt.Errorf("Unsupported endpoint")
@@ -287,19 +288,63 @@ func (r *raxRegion) EndpointByName(name string) (string, error) {
 // choices on its own.  Customized transports are useful, however, if extra logging
 // is required, or if you're using unit tests to isolate and verify correct behavior.
 func (r *raxRegion) UseClient(cl *http.Client) {
-	r.httpClient = cl
+	r.client.UseClient(cl)
+}
+
+// SetCache makes the region client retain and revalidate the responses to its GET requests, such as those of Flavors() and Images(),
+// in the given cache.  See gorax.Cache for details; pass nil to stop caching.
+func (r *raxRegion) SetCache(c *gorax.Cache) {
+	r.client.SetCache(c)
+}
+
+// AddObserver arranges for the observer to be told about every request the region client subsequently makes.
+// Requests are labelled with the "servers" service, the region's name, and operations named after the client's methods, e.g., "servers.CreateServer".
+// See gorax.Observer for details.
+func (r *raxRegion) AddObserver(o gorax.Observer) {
+	r.client.AddObserver(o)
+}
+
+// SetRetryPolicy configures how the region client re-attempts requests that fail for transient reasons.
+// See gorax.RetryPolicy for details; pass nil to disable retries.
+func (r *raxRegion) SetRetryPolicy(policy *gorax.RetryPolicy) {
+	r.client.SetRetryPolicy(policy)
+}
+
+// SetLogger reports every exchange made by the region client to the given logger, at the given level of detail.
+func (r *raxRegion) SetLogger(logger gorax.Logger, level gorax.LogLevel) {
+	r.client.SetLogger(logger, level)
 }
 
-// makeRegionalClient creates a structure that implements the Region interface.
-func makeRegionalClient(id identity.Identity, e identity.EntryEndpoint) (Region, error) {
-	t, err := id.Token()
+// SetTimeout bounds how long any single request made through the region client may take.
+func (r *raxRegion) SetTimeout(timeout time.Duration) {
+	r.client.SetTimeout(timeout)
+}
+
+// UseCircuitBreaker makes the region client fail fast while its endpoint keeps failing; see gorax.CircuitBreaker.
+// Pass nil to remove a previously installed breaker.
+func (r *raxRegion) UseCircuitBreaker(breaker *gorax.CircuitBreaker) {
+	r.client.UseCircuitBreaker(breaker)
+}
+
+// makeRegionalClient creates a structure that implements the Region interface, reaching the endpoint through the given URL.
+func makeRegionalClient(id identity.Identity, e identity.EntryEndpoint, url string) (Region, error) {
+	_, err := id.Token()
 	if err != nil {
 		return nil, err
 	}
-	return &raxRegion{
+
+	client := gorax.MakeRestClient(url)
+	client.Service = "servers"
+	client.Region = e.Region
+
+	r := &raxRegion{
 		id:            id,
 		entryEndpoint: e,
-		token:         t,
-		httpClient:    &http.Client{},
-	}, nil
+		url:           url,
+		client:        client,
+	}
+	tm := &tokenMiddleware{region: r}
+	client.RequestMiddlewares = append(client.RequestMiddlewares, tm)
+	client.RoundTripMiddlewares = append(client.RoundTripMiddlewares, tm)
+	return r, nil
 }
@@ -3,157 +3,21 @@
 package servers
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/racker/gorax"
+	"github.com/racker/gorax/recorder"
 	"github.com/racker/gorax/v2.0/identity"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 const (
-	SUCCESSFUL_LOGIN_RESPONSE = `{
-	"access": {
-		"serviceCatalog": [{
-			"endpoints": [{
-				"publicURL": "https://ord.servers.api.rackspacecloud.com/v2/12345",
-				"region": "ORD",
-				"tenantId": "12345",
-				"versionId": "2",
-				"versionInfo": "https://ord.servers.api.rackspacecloud.com/v2",
-				"versionList": "https://ord.servers.api.rackspacecloud.com/"
-			},{
-				"publicURL": "https://dfw.servers.api.rackspacecloud.com/v2/12345",
-				"region": "DFW",
-				"tenantId": "12345",
-				"versionId": "2",
-				"versionInfo": "https://dfw.servers.api.rackspacecloud.com/v2",
-				"versionList": "https://dfw.servers.api.rackspacecloud.com/"
-			}],
-			"name": "cloudServersOpenStack",
-			"type": "compute"
-		},{
-			"endpoints": [{
-				"publicURL": "https://ord.databases.api.rackspacecloud.com/v1.0/12345",
-				"region": "ORD",
-				"tenantId": "12345"
-			}],
-			"name": "cloudDatabases",
-			"type": "rax:database"
-		}],
-		"token": {
-			"expires": "2012-04-13T13:15:00.000-05:00",
-			"id": "aaaaa-bbbbb-ccccc-dddd"
-		},
-		"user": {
-			"RAX-AUTH:defaultRegion": "DFW",
-			"id": "161418",
-			"name": "demoauthor",
-			"roles": [{
-				"description": "User Admin Role.",
-				"id": "3",
-				"name": "identity:user-admin"
-			}]
-		}
-	}
-}
-`
-	TWO_IMAGES = `{
-	"images": [
-		{
-			"OS-DCF:diskConfig": "AUTO", 
-			"created": "2012-10-13T16:53:56Z", 
-			"id": "a3a2c42f-575f-4381-9c6d-fcd3b7d07d28", 
-			"links": [
-				{
-					"href": "https://dfw.servers.api.rackspacecloud.com/v2/658405/images/a3a2c42f-575f-4381-9c6d-fcd3b7d07d28", 
-					"rel": "self"
-				}, 
-				{
-					"href": "https://dfw.servers.api.rackspacecloud.com/658405/images/a3a2c42f-575f-4381-9c6d-fcd3b7d07d28", 
-					"rel": "bookmark"
-				}, 
-				{
-					"href": "https://dfw.servers.api.rackspacecloud.com/658405/images/a3a2c42f-575f-4381-9c6d-fcd3b7d07d28", 
-					"rel": "alternate", 
-					"type": "application/vnd.openstack.image"
-				}
-			], 
-			"metadata": {
-				"arch": "x86-64", 
-				"auto_disk_config": "True", 
-				"com.rackspace__1__build_core": "1", 
-				"com.rackspace__1__build_managed": "0", 
-				"com.rackspace__1__build_rackconnect": "1", 
-				"com.rackspace__1__options": "0", 
-				"com.rackspace__1__visible_core": "1", 
-				"com.rackspace__1__visible_managed": "0", 
-				"com.rackspace__1__visible_rackconnect": "1", 
-				"image_type": "base", 
-				"org.openstack__1__architecture": "x64", 
-				"org.openstack__1__os_distro": "org.centos", 
-				"org.openstack__1__os_version": "5.8", 
-				"os_distro": "centos", 
-				"os_type": "linux", 
-				"os_version": "5.8", 
-				"rax_managed": "false", 
-				"rax_options": "0"
-			}, 
-			"minDisk": 10, 
-			"minRam": 256, 
-			"name": "CentOS 5.8", 
-			"progress": 100, 
-			"status": "ACTIVE", 
-			"updated": "2012-10-13T16:54:55Z"
-		}, 
-		{
-			"OS-DCF:diskConfig": "AUTO", 
-			"created": "2012-10-13T16:53:56Z", 
-			"id": "a3a2c42f-575f-4381-9c6d-fcd3b7d07d17", 
-			"links": [
-				{
-					"href": "https://dfw.servers.api.rackspacecloud.com/v2/658405/images/a3a2c42f-575f-4381-9c6d-fcd3b7d07d17", 
-					"rel": "self"
-				}, 
-				{
-					"href": "https://dfw.servers.api.rackspacecloud.com/658405/images/a3a2c42f-575f-4381-9c6d-fcd3b7d07d17", 
-					"rel": "bookmark"
-				}, 
-				{
-					"href": "https://dfw.servers.api.rackspacecloud.com/658405/images/a3a2c42f-575f-4381-9c6d-fcd3b7d07d17", 
-					"rel": "alternate", 
-					"type": "application/vnd.openstack.image"
-				}
-			], 
-			"metadata": {
-				"arch": "x86-64", 
-				"auto_disk_config": "True", 
-				"com.rackspace__1__build_core": "1", 
-				"com.rackspace__1__build_managed": "0", 
-				"com.rackspace__1__build_rackconnect": "1", 
-				"com.rackspace__1__options": "0", 
-				"com.rackspace__1__visible_core": "1", 
-				"com.rackspace__1__visible_managed": "0", 
-				"com.rackspace__1__visible_rackconnect": "1", 
-				"image_type": "base", 
-				"org.openstack__1__architecture": "x64", 
-				"org.openstack__1__os_distro": "org.centos", 
-				"org.openstack__1__os_version": "6.0", 
-				"os_distro": "centos", 
-				"os_type": "linux", 
-				"os_version": "6.0", 
-				"rax_managed": "false", 
-				"rax_options": "0"
-			}, 
-			"minDisk": 10, 
-			"minRam": 256, 
-			"name": "CentOS 6.0", 
-			"progress": 100, 
-			"status": "ACTIVE", 
-			"updated": "2012-10-13T16:54:55Z"
-		}
-	]
-}`
-
 	TWO_FLAVORS = `{
 	"flavors": [
 		{
@@ -197,76 +61,24 @@ const (
 }`
 )
 
-// testTransport is used to intercept traffic that would normally go out over a network connection.
-// For the purposes of this package, we're concerned with the following things:
-//
-// The response string substitutes for the server response.  Setting this field, we can control
-// what a test sees at any given time, allowing us to fake both error and successful conditions
-// in full isolation of any provided network.
-//
-// The seenXAuthToken field records whether or not an X-Auth-Token has been provided by the client.
+// tokenMatcher answers requests from a cassette only if they agree on the presence of an X-Auth-Token header.
 // Since we require an authenticated identity to access region-provided services,
-// this header must always be present.
-type testTransport struct {
-	response       string
-	seenXAuthToken bool
-}
+// the header must be present in every request made through a region's client.
+var tokenMatcher = recorder.Matcher{Method: true, Path: true, Query: true, Header: []string{"X-Auth-Token"}}
 
-// The RoundTrip method implements the net/http.RoundTripper interface.
-// It's here that we wrest control from the normal network stack and inject our own
-// responses to the net/http package.
-func (t *testTransport) RoundTrip(req *http.Request) (rsp *http.Response, err error) {
-	if req.Header.Get("X-Auth-Token") != "" {
-		t.seenXAuthToken = true
-	}
-
-	headers := make(http.Header)
-	body := ioutil.NopCloser(strings.NewReader(t.response))
-	rsp = &http.Response{
-		Status:           "200 OK",
-		StatusCode:       200,
-		Proto:            "HTTP/1.0",
-		ProtoMajor:       1,
-		ProtoMinor:       0,
-		Header:           headers,
-		Body:             body,
-		ContentLength:    -1,
-		TransferEncoding: nil,
-		Close:            true,
-		Trailer:          nil,
-		Request:          req,
-	}
-	return
-}
-
-// withTestTransport abstracts common set-up code for creating a custom transport.
-// The custom HTTP transport allows us to intercept normal HTTP interactions and
-// fake out all network activity as we see fit.
-func withTestTransport(r string, f func(c *http.Client, t *testTransport)) {
-	transport := &testTransport{
-		response: r,
-	}
-	client := &http.Client{
-		Transport: transport,
-	}
-	f(client, transport)
-}
-
-// withAuthentication abstracts common set-up code for authenticating an identity.
-func withAuthentication(c *http.Client, f func(e error, id identity.Identity)) {
-	id := identity.NewIdentity("unused", "fields", "")
-	id.UseClient(c)
-	err := id.Authenticate()
+// withCassette abstracts common set-up code for authenticating an identity and finding a known region.
+// Both replay the exchanges recorded in testdata/region.json, without any network activity.
+func withCassette(f func(err error, _ Region)) {
+	rec, err := recorder.New("testdata/region.json", recorder.Replay)
 	if err != nil {
 		f(err, nil)
 		return
 	}
-	f(nil, id)
-}
+	rec.Matcher = tokenMatcher
 
-// withRegion finds a known region and
-// configures the transport for the (anticipated) next step in the testing process.
-func withRegion(err error, id identity.Identity, cl *http.Client, t *testTransport, r string, f func(err error, _ Region)) {
+	id := identity.NewIdentity("demoauthor", "unused", "")
+	id.UseClient(rec.Client())
+	err = id.Authenticate()
 	if err != nil {
 		f(err, nil)
 		return
@@ -276,99 +88,347 @@ func withRegion(err error, id identity.Identity, cl *http.Client, t *testTranspo
 		f(err, nil)
 		return
 	}
-	region.UseClient(cl)
-	t.response = r
+	region.UseClient(rec.Client())
 	f(nil, region)
 }
 
 /****** Unit Tests ******/
 
 func TestEndpointByName(t *testing.T) {
-	withTestTransport(SUCCESSFUL_LOGIN_RESPONSE, func(client *http.Client, transport *testTransport) {
-		withAuthentication(client, func(err error, id identity.Identity) {
-			withRegion(err, id, client, transport, "", func(err error, region Region) {
-				if err != nil {
-					t.Error(err)
-					return
-				}
-				api, err := region.EndpointByName("images")
-				if err != nil {
-					t.Error(err)
-					return
-				}
-				if api != "https://dfw.servers.api.rackspacecloud.com/v2/12345/images" {
-					t.Error("Expected DFW cloud server API for images; got", api)
-					return
-				}
+	withCassette(func(err error, region Region) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		api, err := region.EndpointByName("images")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if api != "https://dfw.servers.api.rackspacecloud.com/v2/12345/images" {
+			t.Error("Expected DFW cloud server API for images; got", api)
+			return
+		}
 
-				api, err = region.EndpointByName("flavors")
-				if err != nil {
-					t.Error(err)
-					return
-				}
-				if api != "https://dfw.servers.api.rackspacecloud.com/v2/12345/flavors" {
-					t.Error("Expected DFW cloud server API for flavors; got", api)
-					return
-				}
+		api, err = region.EndpointByName("flavors")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if api != "https://dfw.servers.api.rackspacecloud.com/v2/12345/flavors" {
+			t.Error("Expected DFW cloud server API for flavors; got", api)
+			return
+		}
 
-				api, err = region.EndpointByName("servers")
-				if err != nil {
-					t.Error(err)
-					return
-				}
-				if api != "https://dfw.servers.api.rackspacecloud.com/v2/12345/servers" {
-					t.Error("Expected DFW cloud server API for servers; got", api)
-					return
-				}
-			})
-		})
+		api, err = region.EndpointByName("servers")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if api != "https://dfw.servers.api.rackspacecloud.com/v2/12345/servers" {
+			t.Error("Expected DFW cloud server API for servers; got", api)
+			return
+		}
 	})
 }
 
 func TestImages(t *testing.T) {
-	withTestTransport(SUCCESSFUL_LOGIN_RESPONSE, func(client *http.Client, transport *testTransport) {
-		withAuthentication(client, func(err error, id identity.Identity) {
-			withRegion(err, id, client, transport, TWO_IMAGES, func(err error, region Region) {
-				if err != nil {
-					t.Error(err)
-					return
-				}
-				imgs, err := region.Images()
-				if err != nil {
-					t.Error(err)
-					return
-				}
-				if len(imgs) != 2 {
-					t.Error("Expected 2 images; got", len(imgs))
-					return
-				}
-				if !transport.seenXAuthToken {
-					t.Error("Expected X-Auth-Token header to be sent")
-					return
-				}
-			})
-		})
+	withCassette(func(err error, region Region) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		imgs, err := region.Images()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(imgs) != 2 {
+			t.Error("Expected 2 images; got", len(imgs))
+			return
+		}
 	})
 }
 
 func TestFlavors(t *testing.T) {
-	withTestTransport(SUCCESSFUL_LOGIN_RESPONSE, func(client *http.Client, transport *testTransport) {
-		withAuthentication(client, func(err error, id identity.Identity) {
-			withRegion(err, id, client, transport, TWO_FLAVORS, func(err error, region Region) {
-				if err != nil {
-					t.Error(err)
-					return
-				}
-				flavors, err := region.Flavors()
-				if err != nil {
-					t.Error(err)
-					return
-				}
-				if len(flavors) != 2 {
-					t.Error("Expected 2 flavors; got", len(flavors))
-					return
-				}
-			})
-		})
+	withCassette(func(err error, region Region) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		flavors, err := region.Flavors()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(flavors) != 2 {
+			t.Error("Expected 2 flavors; got", len(flavors))
+			return
+		}
+	})
+}
+
+func TestRegionObservers(t *testing.T) {
+	withCassette(func(err error, region Region) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		metrics := gorax.NewMetricsObserver(nil)
+		region.(ConfigurableRegion).AddObserver(metrics)
+		region.(ConfigurableRegion).AddObserver(&gorax.TraceObserver{})
+
+		_, err = region.Flavors()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		stats := metrics.Stats()
+		if len(stats) != 1 {
+			t.Error("Expected one observed operation; got", len(stats))
+			return
+		}
+		s := stats[0]
+		if s.Service != "servers" || s.Operation != "servers.Flavors" || s.Region != "DFW" || s.Requests[200] != 1 {
+			t.Error("Expected a successful servers.Flavors request in DFW; got", s)
+			return
+		}
+	})
+}
+
+func TestServerActions(t *testing.T) {
+	withCassette(func(err error, region Region) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = region.RebootServer("abc", true)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = region.DeleteServerById("abc")
+		if !gorax.IsNotFound(err) {
+			t.Error("Expected a not-found error; got", err)
+			return
+		}
+	})
+}
+
+// The renewingIdCard structure substitutes for an identity able to re-authenticate.
+// Each renewal yields a token numbered one higher than the last.
+// Should failure be set, renewals fail with it instead.
+type renewingIdCard struct {
+	myIdCard2
+	lock     sync.Mutex
+	token    string
+	expires  string
+	renewals int
+	attempts int
+	failure  error
+}
+
+func (i *renewingIdCard) Token() (string, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.token, nil
+}
+
+func (i *renewingIdCard) Expires() (string, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.expires, nil
+}
+
+func (i *renewingIdCard) Reauthenticate(staleToken string) error {
+	return i.ReauthenticateWithContext(context.Background(), staleToken)
+}
+
+func (i *renewingIdCard) ReauthenticateWithContext(ctx context.Context, staleToken string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.token != staleToken {
+		return nil
+	}
+	i.attempts++
+	if i.failure != nil {
+		return i.failure
+	}
+	i.renewals++
+	i.token = fmt.Sprintf("token-%d", i.renewals)
+	i.expires = time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	return nil
+}
+
+// tokenCheckingTransport accepts only requests bearing the given token, rejecting the rest with 401 Unauthorized.
+type tokenCheckingTransport struct {
+	token string
+	lock  sync.Mutex
+	seen  []string
+}
+
+func (t *tokenCheckingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := req.Header.Get("X-Auth-Token")
+	t.lock.Lock()
+	t.seen = append(t.seen, token)
+	t.lock.Unlock()
+
+	status, body := http.StatusOK, TWO_FLAVORS
+	if token != t.token {
+		status, body = http.StatusUnauthorized, `{"unauthorized": {"code": 401, "message": "Token expired"}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestRegionRenewsRejectedToken(t *testing.T) {
+	id := &renewingIdCard{token: "token-0", expires: "2099-01-01T00:00:00Z"}
+	region, err := RegionByName(id, "dfw")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	transport := &tokenCheckingTransport{token: "token-1"}
+	region.UseClient(&http.Client{Transport: transport})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := region.Flavors()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if id.renewals != 1 {
+		t.Error("Expected concurrent rejections to share one renewal; got", id.renewals)
+		return
+	}
+
+	transport.token = "revoked"
+	transport.seen = nil
+	_, err = region.Flavors()
+	if !gorax.IsUnauthorized(err) {
+		t.Error("Expected a token rejected even after renewal to yield 401; got", err)
+		return
+	}
+	if len(transport.seen) != 2 {
+		t.Error("Expected a rejected request to be replayed only once; server saw", transport.seen)
+		return
+	}
+}
+
+func TestRegionRenewsExpiringToken(t *testing.T) {
+	id := &renewingIdCard{token: "token-0", expires: time.Now().Add(time.Minute).UTC().Format(time.RFC3339)}
+	region, err := RegionByName(id, "dfw")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	transport := &tokenCheckingTransport{token: "token-1"}
+	region.UseClient(&http.Client{Transport: transport})
+
+	_, err = region.Flavors()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(transport.seen) != 1 || transport.seen[0] != "token-1" {
+		t.Error("Expected the token to be renewed before it was sent; server saw", transport.seen)
+		return
+	}
+}
+
+func TestRegionBacksOffFailedRenewal(t *testing.T) {
+	id := &renewingIdCard{token: "token-0", expires: time.Now().Add(time.Minute).UTC().Format(time.RFC3339), failure: fmt.Errorf("identity service unavailable")}
+	region, err := RegionByName(id, "dfw")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	transport := &tokenCheckingTransport{token: "token-0"}
+	region.UseClient(&http.Client{Transport: transport})
+
+	for n := 0; n < 3; n++ {
+		if _, err := region.Flavors(); err != nil {
+			t.Error("Expected the old token to remain in use; got", err)
+			return
+		}
+	}
+	if id.attempts != 1 {
+		t.Error("Expected a failed renewal not to be retried at once; got", id.attempts, "attempts")
+		return
+	}
+}
+
+// roundTripFunc adapts a function into a net/http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRegionWithV3Identity(t *testing.T) {
+	keystone := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusCreated,
+			Header:     http.Header{"Content-Type": {"application/json"}, "X-Subject-Token": {"v3-token"}},
+			Body: ioutil.NopCloser(strings.NewReader(`{"token": {"expires_at": "2099-01-01T00:00:00.000000Z",
+				"project": {"id": "p1", "name": "ops"},
+				"catalog": [{"type": "compute", "name": "nova", "endpoints": [
+					{"interface": "public", "region_id": "RegionOne", "url": "https://nova.example.com/v2.1/p1"}]}]}}`)),
+			Request: req,
+		}, nil
 	})
+	id := identity.NewV3Identity("https://keystone.example.com/v3", identity.V3Credentials{UserId: "u1", Password: "pw", ProjectId: "p1"})
+	id.UseClient(&http.Client{Transport: keystone})
+	if err := id.Authenticate(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	region, err := RegionByName(id, "regionone")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	transport := &tokenCheckingTransport{token: "v3-token"}
+	region.UseClient(&http.Client{Transport: transport})
+	flavors, err := region.Flavors()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(flavors) != 2 {
+		t.Error("Expected 2 flavors; got", len(flavors))
+		return
+	}
+}
+
+func TestNewServerOmitsEmptyName(t *testing.T) {
+	data, err := json.Marshal(NewServer{ImageRef: "i1", FlavorRef: "2"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Contains(string(data), `"name"`) {
+		t.Error("Expected an empty name to be omitted; got", string(data))
+		return
+	}
 }
@@ -0,0 +1,200 @@
+// vim: ts=8 sw=8 noet ai
+
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// These constants name the interfaces through which a service may be reached.
+// On Rackspace, the internal interface is ServiceNet, which servers within the same region may use free of bandwidth charges.
+const (
+	PublicInterface   = "public"
+	InternalInterface = "internal"
+	AdminInterface    = "admin"
+)
+
+// ErrEndpointNotFound is matched, through errors.Is(), by every *EndpointNotFoundError.
+var ErrEndpointNotFound = errors.New("no matching endpoint in service catalog")
+
+// EndpointOpts selects an endpoint from a service catalog.
+//
+// The service is identified by Type (e.g., "compute"), by Name (e.g., "cloudServersOpenStack"), or both; either comparison ignores case.
+//
+// Region names are also compared without regard to case.
+// If Region is "", DefaultRegion applies instead; if that, too, is "", the service must offer just one region.
+// Endpoints which name no region, as for global services like Cloud Monitoring, serve every region.
+//
+// Interface chooses the public (the default), internal or admin URL; see PublicInterface and friends.
+// Version, if set, restricts the choice to endpoints of that API version, e.g., "2" or "v2".
+// It matches an endpoint's VersionId, or a version segment of its URL, such as "v2" or "v2.1".
+type EndpointOpts struct {
+	Type, Name            string
+	Region, DefaultRegion string
+	Interface             string
+	Version               string
+}
+
+// A ResolvedEndpoint is the outcome of resolving EndpointOpts against a catalog: the chosen service and endpoint, and the URL of the chosen interface.
+type ResolvedEndpoint struct {
+	Service  CatalogEntry
+	Endpoint EntryEndpoint
+	URL      string
+}
+
+// An EndpointNotFoundError reports that no endpoint in a catalog, or more than one, satisfies a set of EndpointOpts.
+// Choices describes the candidate endpoints the catalog does offer, for the benefit of whoever must correct the options.
+type EndpointNotFoundError struct {
+	Opts    EndpointOpts
+	Reason  string
+	Choices []string
+}
+
+func (e *EndpointNotFoundError) Error() string {
+	msg := fmt.Sprintf("%s: %s", ErrEndpointNotFound, e.Reason)
+	if len(e.Choices) == 0 {
+		return msg + "; the catalog offers no such service"
+	}
+	return msg + "; available: " + strings.Join(e.Choices, ", ")
+}
+
+// Is reports whether target is ErrEndpointNotFound.
+func (e *EndpointNotFoundError) Is(target error) bool {
+	return target == ErrEndpointNotFound
+}
+
+// A Catalog is a service catalog, as yielded by an Identity's ServiceCatalog() method.
+type Catalog []CatalogEntry
+
+// Resolve finds the one endpoint in the catalog satisfying opts.
+// If none does, or the region is ambiguous, it returns an *EndpointNotFoundError listing the catalog's candidates.
+func (c Catalog) Resolve(opts EndpointOpts) (*ResolvedEndpoint, error) {
+	if opts.Type == "" && opts.Name == "" {
+		return nil, errors.New("endpoint resolution requires a service type or name")
+	}
+	iface := opts.Interface
+	if iface == "" {
+		iface = PublicInterface
+	}
+	region := opts.Region
+	if region == "" {
+		region = opts.DefaultRegion
+	}
+
+	var matches []ResolvedEndpoint
+	var choices []string
+	regions := map[string]bool{}
+	for _, service := range c {
+		if (opts.Type != "" && !strings.EqualFold(service.Type, opts.Type)) || (opts.Name != "" && !strings.EqualFold(service.Name, opts.Name)) {
+			continue
+		}
+		for _, ep := range service.Endpoints {
+			url := ep.URL(iface)
+			if !matchesVersion(ep, url, opts.Version) {
+				continue
+			}
+			choices = append(choices, describeEndpoint(service, ep))
+			if url == "" {
+				continue
+			}
+			if region != "" && ep.Region != "" && !strings.EqualFold(ep.Region, region) {
+				continue
+			}
+			regions[strings.ToUpper(ep.Region)] = true
+			matches = append(matches, ResolvedEndpoint{service, ep, url})
+		}
+	}
+
+	switch {
+	case len(matches) == 1:
+		return &matches[0], nil
+	case len(matches) == 0 && region != "":
+		return nil, &EndpointNotFoundError{opts, fmt.Sprintf("no %s endpoint for %s in region %s", iface, describeService(opts), region), choices}
+	case len(matches) == 0:
+		return nil, &EndpointNotFoundError{opts, fmt.Sprintf("no %s endpoint for %s", iface, describeService(opts)), choices}
+	case region == "" && len(regions) > 1:
+		return nil, &EndpointNotFoundError{opts, fmt.Sprintf("%s is offered in several regions; choose one", describeService(opts)), choices}
+	}
+
+	// Several services match; prefer an endpoint specific to the region over a global one, and failing that, the catalog's first.
+	for i := range matches {
+		if matches[i].Endpoint.Region != "" {
+			return &matches[i], nil
+		}
+	}
+	return &matches[0], nil
+}
+
+// ResolveEndpoint resolves opts against the identity's service catalog.
+// Unless opts names a DefaultRegion, the identity's default region applies, if it has one; see DefaultRegion().
+func ResolveEndpoint(id Identity, opts EndpointOpts) (*ResolvedEndpoint, error) {
+	sc, err := id.ServiceCatalog()
+	if err != nil {
+		return nil, err
+	}
+	if opts.DefaultRegion == "" {
+		if d, ok := id.(interface {
+			DefaultRegion() string
+		}); ok {
+			opts.DefaultRegion = d.DefaultRegion()
+		}
+	}
+	return Catalog(sc).Resolve(opts)
+}
+
+// URL yields the endpoint's URL for the given interface, or "" if it has none.
+func (ep EntryEndpoint) URL(iface string) string {
+	switch iface {
+	case PublicInterface, "":
+		return ep.PublicURL
+	case InternalInterface:
+		return ep.InternalURL
+	case AdminInterface:
+		return ep.AdminURL
+	}
+	return ""
+}
+
+// matchesVersion reports whether the endpoint serves the given API version, if any.
+func matchesVersion(ep EntryEndpoint, url, version string) bool {
+	want := strings.TrimPrefix(strings.ToLower(version), "v")
+	if want == "" {
+		return true
+	}
+	if ep.VersionId != "" {
+		return strings.TrimPrefix(strings.ToLower(ep.VersionId), "v") == want
+	}
+	for _, segment := range strings.Split(strings.ToLower(url), "/") {
+		if segment == "v"+want || strings.HasPrefix(segment, "v"+want+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func describeService(opts EndpointOpts) string {
+	switch {
+	case opts.Type != "" && opts.Name != "":
+		return fmt.Sprintf("service %s (%s)", opts.Name, opts.Type)
+	case opts.Name != "":
+		return "service " + opts.Name
+	}
+	return "service type " + opts.Type
+}
+
+// describeEndpoint summarizes an endpoint for an EndpointNotFoundError, e.g., "cloudServersOpenStack (compute) in DFW: public/internal".
+func describeEndpoint(service CatalogEntry, ep EntryEndpoint) string {
+	region := ep.Region
+	if region == "" {
+		region = "all regions"
+	}
+	var ifaces []string
+	for _, iface := range []string{PublicInterface, InternalInterface, AdminInterface} {
+		if ep.URL(iface) != "" {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	return fmt.Sprintf("%s (%s) in %s: %s", service.Name, service.Type, region, strings.Join(ifaces, "/"))
+}
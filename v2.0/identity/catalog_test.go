@@ -0,0 +1,106 @@
+// vim: ts=8 sw=8 noet ai
+
+package identity
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+var testCatalog = Catalog{
+	{
+		Name: "cloudServers",
+		Type: "compute",
+		Endpoints: []EntryEndpoint{
+			{PublicURL: "https://servers.api.rackspacecloud.com/v1.0/12345", VersionId: "1.0"},
+		},
+	},
+	{
+		Name: "cloudServersOpenStack",
+		Type: "compute",
+		Endpoints: []EntryEndpoint{
+			{Region: "DFW", PublicURL: "https://dfw.servers.api.rackspacecloud.com/v2/12345", InternalURL: "https://snet-dfw.servers.api.rackspacecloud.com/v2/12345", VersionId: "2"},
+			{Region: "ORD", PublicURL: "https://ord.servers.api.rackspacecloud.com/v2/12345", VersionId: "2"},
+		},
+	},
+	{
+		Name: "cloudMonitoring",
+		Type: "rax:monitor",
+		Endpoints: []EntryEndpoint{
+			{PublicURL: "https://monitoring.api.rackspacecloud.com/v1.0/12345"},
+		},
+	},
+}
+
+func TestCatalogResolve(t *testing.T) {
+	cases := []struct {
+		opts     EndpointOpts
+		expected string
+	}{
+		{EndpointOpts{Type: "compute", Region: "dfw", Version: "2"}, "https://dfw.servers.api.rackspacecloud.com/v2/12345"},
+		{EndpointOpts{Name: "cloudServersOpenStack", DefaultRegion: "ORD"}, "https://ord.servers.api.rackspacecloud.com/v2/12345"},
+		{EndpointOpts{Type: "compute", Region: "DFW", Interface: InternalInterface}, "https://snet-dfw.servers.api.rackspacecloud.com/v2/12345"},
+		{EndpointOpts{Type: "compute", Version: "v1.0"}, "https://servers.api.rackspacecloud.com/v1.0/12345"},
+		{EndpointOpts{Type: "rax:monitor", Region: "LON"}, "https://monitoring.api.rackspacecloud.com/v1.0/12345"},
+	}
+	for _, c := range cases {
+		ep, err := testCatalog.Resolve(c.opts)
+		if err != nil {
+			t.Error("Resolve:", c.opts, err)
+			return
+		}
+		if ep.URL != c.expected {
+			t.Error("Resolve: expected", c.expected, "for", c.opts, "got:", ep.URL)
+			return
+		}
+	}
+}
+
+func TestCatalogResolveErrors(t *testing.T) {
+	_, err := testCatalog.Resolve(EndpointOpts{Type: "compute", Region: "ORD", Interface: InternalInterface, Version: "2"})
+	if !errors.Is(err, ErrEndpointNotFound) {
+		t.Error("Resolve: expected ErrEndpointNotFound; got", err)
+		return
+	}
+	if !strings.Contains(err.Error(), "cloudServersOpenStack (compute) in DFW: public/internal") {
+		t.Error("Resolve: expected the error to list the available endpoints; got", err)
+		return
+	}
+
+	_, err = testCatalog.Resolve(EndpointOpts{Type: "compute", Version: "2"})
+	if !errors.Is(err, ErrEndpointNotFound) || !strings.Contains(err.Error(), "several regions") {
+		t.Error("Resolve: expected an ambiguous region to be refused; got", err)
+		return
+	}
+
+	_, err = testCatalog.Resolve(EndpointOpts{Type: "object-store"})
+	if !errors.Is(err, ErrEndpointNotFound) {
+		t.Error("Resolve: expected ErrEndpointNotFound for a missing service; got", err)
+		return
+	}
+}
+
+func TestResolveEndpointDefaultRegion(t *testing.T) {
+	transport := &testTransport{
+		response: SUCCESSFUL_LOGIN_RESPONSE,
+	}
+	id := NewIdentity(USERNAME, PASSWORD, "")
+	id.UseClient(&http.Client{
+		Transport: transport,
+	})
+	if err := id.Authenticate(); err != nil {
+		t.Error("Auth:", err)
+		return
+	}
+	ep, err := ResolveEndpoint(id, EndpointOpts{Type: "compute"})
+	if err != nil {
+		t.Error("ResolveEndpoint:", err)
+		return
+	}
+	if ep.Endpoint.Region != "DFW" {
+		t.Error("ResolveEndpoint: expected the user's default region; got", ep.Endpoint.Region)
+		return
+	}
+}
@@ -3,10 +3,15 @@
 package identity
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/racker/gorax"
 	"github.com/racker/perigee"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -17,36 +22,76 @@ const (
 // The identity (lower-case i) structure records the username, password, and
 // region for the user's credentials.  In addition, it tracks whether or not
 // the user is authenticated.
+//
+// The lock guards the fruits of authentication, since a long-lived identity may be
+// re-authenticated while other goroutines use its token.  The refreshLock channel
+// admits one re-authentication at a time; see Reauthenticate().
 type identity struct {
 	username, password, region string
+	apiKey                     string
+	authEndpoint               string
 	isAuthenticated            bool
 	httpClient                 *http.Client
 	token, expires             string
 	tenantId, tenantName       string
 	access                     *AccessBody
+	tokenStore                 gorax.TokenStore
+	lock                       sync.RWMutex
+	refreshLock                chan struct{}
 }
 
 // NewIdentity creates a new set of papers to use for authentication against the Rackspace Identity service.
 // It takes a username and password as inputs.
 // Specify "" if you intend on specifying username or password later.
 // Consult with your cloud provider for your username and password.
+// To authenticate with an API key instead, use NewIdentityWithAPIKey.
 // The region parameter, if provided, specifies the geographical home for your account.
 // Specify "" for default region (currently US).
 func NewIdentity(userName, pw, reg string) *identity {
 	return &identity{
-		username:   userName,
-		password:   pw,
-		region:     strings.ToUpper(reg),
-		httpClient: &http.Client{},
+		username:    userName,
+		password:    pw,
+		region:      strings.ToUpper(reg),
+		httpClient:  &http.Client{Timeout: gorax.DefaultTimeout},
+		refreshLock: make(chan struct{}, 1),
 	}
 }
 
+// NewIdentityWithAPIKey creates a new set of papers to use for authentication against the Rackspace Identity service.
+// Unlike NewIdentity, it takes a username and API key as inputs, for accounts which may not, or should not, authenticate with passwords.
+// Your API key appears in the Cloud Control Panel, under your account settings.
+// The region parameter behaves as it does for NewIdentity.
+func NewIdentityWithAPIKey(userName, apiKey, reg string) *identity {
+	id := NewIdentity(userName, "", reg)
+	id.apiKey = apiKey
+	return id
+}
+
 // SetCredentials may be used to alter the current set of credentials,
 // provided the identity has not yet been authenticated.
+// The identity will authenticate with the given password, forgetting any API key it was given.
 func (id *identity) SetCredentials(userName, pw, reg string) {
+	id.lock.Lock()
+	defer id.lock.Unlock()
+
 	if !id.isAuthenticated {
 		id.username = userName
 		id.password = pw
+		id.apiKey = ""
+		id.region = strings.ToUpper(reg)
+	}
+}
+
+// SetAPIKeyCredentials behaves like SetCredentials, except that the identity will authenticate with the given API key,
+// forgetting any password it was given.
+func (id *identity) SetAPIKeyCredentials(userName, apiKey, reg string) {
+	id.lock.Lock()
+	defer id.lock.Unlock()
+
+	if !id.isAuthenticated {
+		id.username = userName
+		id.password = ""
+		id.apiKey = apiKey
 		id.region = strings.ToUpper(reg)
 	}
 }
@@ -63,6 +108,12 @@ func (id *identity) Password() string {
 	return id.password
 }
 
+// APIKey yields the identity's API key, or "" if it authenticates with a password.
+// This string is opaque to gorax.
+func (id *identity) APIKey() string {
+	return id.apiKey
+}
+
 // Region yields the supplied region.
 // The region returned will be in the customary all-uppercase notation.
 // E.g., if you invoked NewIdentity() with a region of "lon", then this method
@@ -76,7 +127,10 @@ func (id *identity) Region() string {
 // Token yields the authentication token.
 // If not authenticated, an error is returned.
 func (id *identity) Token() (string, error) {
-	if !id.IsAuthenticated() {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
 		return "", fmt.Errorf("Not authenticated")
 	}
 	return id.token, nil
@@ -85,7 +139,10 @@ func (id *identity) Token() (string, error) {
 // Expires yields the token's expiration timestamp in ISO8601 format.
 // If not authenticated, an error is returned.
 func (id *identity) Expires() (string, error) {
-	if !id.IsAuthenticated() {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
 		return "", fmt.Errorf("Not authentication")
 	}
 	return id.expires, nil
@@ -94,7 +151,10 @@ func (id *identity) Expires() (string, error) {
 // TenantId yields the tenant ID.
 // If not authenticated, an error is returned.
 func (id *identity) TenantId() (string, error) {
-	if !id.IsAuthenticated() {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
 		return "", fmt.Errorf("Not authenticated")
 	}
 	return id.tenantId, nil
@@ -103,7 +163,10 @@ func (id *identity) TenantId() (string, error) {
 // TenantName yields the tenant name.
 // If not authenticated, an error is returned.
 func (id *identity) TenantName() (string, error) {
-	if !id.IsAuthenticated() {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
 		return "", fmt.Errorf("Not authenticated")
 	}
 	return id.tenantName, nil
@@ -111,6 +174,11 @@ func (id *identity) TenantName() (string, error) {
 
 // AuthEndpoint yields which API endpoint will be used to perform the authentication.
 func (id *identity) AuthEndpoint() (ep string) {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+	if id.authEndpoint != "" {
+		return id.authEndpoint
+	}
 	ep = US_ENDPOINT
 	if id.region == "LON" {
 		ep = UK_ENDPOINT
@@ -118,10 +186,25 @@ func (id *identity) AuthEndpoint() (ep string) {
 	return
 }
 
+// SetAuthEndpoint makes the identity authenticate against an Identity v2.0 service other than Rackspace's,
+// located by its API's base URL, e.g., "https://keystone.example.com:5000/v2.0".
+// Pass "" to revert to the Rackspace endpoint for the identity's region.
+func (id *identity) SetAuthEndpoint(authURL string) {
+	id.lock.Lock()
+	defer id.lock.Unlock()
+	id.authEndpoint = ""
+	if authURL != "" {
+		id.authEndpoint = strings.TrimSuffix(strings.TrimSuffix(authURL, "/"), "/tokens") + "/tokens"
+	}
+}
+
 // IsAuthenticated reports on whether or not the credentials have been verified.
 // When a new identity is created, by default it remains unauthenticated.
 // Use the Authenticate() method to authenticate.
 func (id *identity) IsAuthenticated() bool {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
 	return id.isAuthenticated
 }
 
@@ -164,7 +247,7 @@ type CatalogEntry struct {
 // EntryEndpoint encapsulates how to get to the API of some service.
 type EntryEndpoint struct {
 	Region, TenantId                    string
-	PublicURL, InternalURL              string
+	PublicURL, InternalURL, AdminURL    string
 	VersionId, VersionInfo, VersionList string
 }
 
@@ -184,16 +267,35 @@ type Role struct {
 // ServiceCatalog yields the array of services available to the user.
 // An error is returned if not authenticated.
 func (id *identity) ServiceCatalog() ([]CatalogEntry, error) {
-	if !id.IsAuthenticated() {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
 		return nil, fmt.Errorf("Not authenticated")
 	}
 	return id.access.Access.ServiceCatalog, nil
 }
 
+// DefaultRegion yields the user's default region, as Rackspace reports it in the RAX-AUTH:defaultRegion attribute,
+// or "" if not authenticated or none was reported.
+// ResolveEndpoint() falls back upon it when no region is requested.
+func (id *identity) DefaultRegion() string {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
+		return ""
+	}
+	return id.access.Access.User.XRaxDefaultRegion
+}
+
 // Roles yields a slice (potentially zero-length) of roles.
 // An error is returned if not authenticated.
 func (id *identity) Roles() ([]Role, error) {
-	if !id.IsAuthenticated() {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
 		return nil, fmt.Errorf("Not authenticated")
 	}
 	return id.access.Access.User.Roles, nil
@@ -216,9 +318,98 @@ type PasswordCredentials struct {
 	Password string `json:"password"`
 }
 
+type APIKeyAuthContainer struct {
+	Auth APIKeyAuth `json:"auth"`
+}
+
+type APIKeyAuth struct {
+	APIKeyCredentials APIKeyCredentials `json:"RAX-KSKEY:apiKeyCredentials"`
+}
+
+type APIKeyCredentials struct {
+	Username string `json:"username"`
+	APIKey   string `json:"apiKey"`
+}
+
 // Authenticate attempts to verify this Identity object's credentials.
+// It may be called again at any time to obtain a fresh token; until it succeeds, the previous token remains in effect.
+//
+// If the identity has a token store, a token stored for the same user is used in preference to authenticating afresh;
+// see UseTokenStore().
 func (id *identity) Authenticate() error {
-	creds := &AuthContainer{
+	return id.authenticate(context.Background(), "")
+}
+
+// authenticate obtains a token from the token store, or failing that, from the Identity service.
+// A stored token matching staleToken is discarded rather than used.
+// The request to the Identity service is abandoned should the context end first.
+func (id *identity) authenticate(ctx context.Context, staleToken string) error {
+	id.lock.RLock()
+	creds := id.credentials()
+	store := id.tokenStore
+	id.lock.RUnlock()
+
+	access := id.storedAccess(store, staleToken)
+	if access == nil {
+		err := perigee.Post(id.AuthEndpoint(), perigee.Options{
+			CustomClient: id.contextClient(ctx),
+			ReqBody:      creds,
+			Results:      &access,
+		})
+		if err != nil {
+			return err
+		}
+		id.storeAccess(store, access)
+	}
+
+	id.lock.Lock()
+	defer id.lock.Unlock()
+
+	id.access = access
+	id.isAuthenticated = true
+	id.token = access.Access.Token.Id
+	id.expires = access.Access.Token.Expires
+	id.tenantId = access.Access.Token.Tenant.Id
+	id.tenantName = access.Access.Token.Tenant.Name
+	return nil
+}
+
+// contextClient yields a copy of the identity's HTTP client whose requests end along with the context,
+// since perigee takes no context of its own.
+func (id *identity) contextClient(ctx context.Context) *http.Client {
+	c := *id.httpClient
+	c.Transport = &contextTransport{ctx: ctx, base: c.Transport}
+	return &c
+}
+
+// A contextTransport issues each request through its base transport, bound to its context.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// credentials yields the body of the identity's authentication request.
+// An API key, if the identity has one, is preferred to its password.
+func (id *identity) credentials() interface{} {
+	if id.apiKey != "" {
+		return &APIKeyAuthContainer{
+			Auth: APIKeyAuth{
+				APIKeyCredentials: APIKeyCredentials{
+					Username: id.username,
+					APIKey:   id.apiKey,
+				},
+			},
+		}
+	}
+	return &AuthContainer{
 		Auth: Auth{
 			PasswordCredentials: PasswordCredentials{
 				Username: id.username,
@@ -226,22 +417,105 @@ func (id *identity) Authenticate() error {
 			},
 		},
 	}
+}
 
-	err := perigee.Post(id.AuthEndpoint(), perigee.Options{
-		CustomClient: id.httpClient,
-		ReqBody:      creds,
-		Results:      &id.access,
-	})
+// storedTokenMargin is how long a stored token must remain valid for an identity to adopt it.
+const storedTokenMargin = 5 * time.Minute
+
+// tokenStoreURL yields the URL by which the identity's tokens are stored.
+// It matches the auth URL a KeystoneAuthMiddleware uses, so the two may share tokens.
+func (id *identity) tokenStoreURL() string {
+	return strings.TrimSuffix(id.AuthEndpoint(), "/tokens")
+}
+
+// storedAccess yields the store's access record for the identity's user, if it has one valid for longer than storedTokenMargin.
+func (id *identity) storedAccess(store gorax.TokenStore, staleToken string) *AccessBody {
+	if store == nil {
+		return nil
+	}
+
+	stored, ok := store.Get(id.tokenStoreURL(), id.Username())
+	if !ok || time.Now().Add(storedTokenMargin).After(stored.Expires) {
+		return nil
+	}
+
+	access := &AccessBody{}
+	if err := json.Unmarshal(stored.Response, access); err != nil {
+		return nil
+	}
+	if access.Access.Token.Id == staleToken {
+		store.Delete(id.tokenStoreURL(), id.Username())
+		return nil
+	}
+	return access
+}
+
+// storeAccess records a newly obtained access record in the store, provided its expiry time can be understood.
+func (id *identity) storeAccess(store gorax.TokenStore, access *AccessBody) {
+	if store == nil {
+		return
+	}
+
+	expires, err := ParseExpires(access.Access.Token.Expires)
 	if err != nil {
-		return err
+		return
 	}
+	data, err := json.Marshal(access)
+	if err != nil {
+		return
+	}
+	store.Set(id.tokenStoreURL(), id.Username(), &gorax.StoredToken{
+		Response: data,
+		Expires:  expires,
+	})
+}
 
-	id.isAuthenticated = true
-	id.token = id.access.Access.Token.Id
-	id.expires = id.access.Access.Token.Expires
-	id.tenantId = id.access.Access.Token.Tenant.Id
-	id.tenantName = id.access.Access.Token.Tenant.Name
-	return nil
+// Reauthenticate replaces the identity's token with a fresh one, unless the token has already been replaced since staleToken was obtained.
+// Only one re-authentication proceeds at a time; goroutines which find their tokens rejected together thus cause a single
+// request to the Identity service, and all share its outcome.
+func (id *identity) Reauthenticate(staleToken string) error {
+	return id.ReauthenticateWithContext(context.Background(), staleToken)
+}
+
+// ReauthenticateWithContext behaves like Reauthenticate, but gives up if the context ends while waiting on the refresh lock or on the Identity service.
+func (id *identity) ReauthenticateWithContext(ctx context.Context, staleToken string) error {
+	select {
+	case id.refreshLock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() {
+		<-id.refreshLock
+	}()
+
+	id.lock.RLock()
+	replaced := id.isAuthenticated && id.token != staleToken
+	id.lock.RUnlock()
+
+	if replaced {
+		return nil
+	}
+	return id.authenticate(ctx, staleToken)
+}
+
+// ParseExpires interprets a token expiry timestamp, as yielded by an Identity's Expires() method.
+// The Identity service expresses these in ISO 8601 format, with or without fractional seconds;
+// a timestamp lacking a time zone is taken to be in UTC.
+func ParseExpires(expires string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, expires)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05", expires)
+}
+
+// UseTokenStore makes the identity share its tokens through the given store, with other identities and other processes
+// authenticating as the same user.  See gorax.TokenStore.
+// Short-lived programs, such as command-line tools run from cron, thus avoid requesting a new token on every invocation.
+func (id *identity) UseTokenStore(store gorax.TokenStore) {
+	id.lock.Lock()
+	defer id.lock.Unlock()
+	id.tokenStore = store
 }
 
 // UseClient configures the identity client to use a specific net/http client.
@@ -3,10 +3,13 @@
 package identity
 
 import (
+	"context"
+	"github.com/racker/gorax"
 	"io/ioutil"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -151,10 +154,15 @@ func TestNewIdentity(t *testing.T) {
 type testTransport struct {
 	response string
 	called   uint
+	request  string
 }
 
 func (t *testTransport) RoundTrip(req *http.Request) (rsp *http.Response, err error) {
 	t.called++
+	if req.Body != nil {
+		body, _ := ioutil.ReadAll(req.Body)
+		t.request = string(body)
+	}
 
 	headers := make(http.Header)
 	headers.Add("Content-Type", "application/xml; charset=UTF-8")
@@ -268,3 +276,168 @@ func TestAuthentication(t *testing.T) {
 		return
 	}
 }
+
+func TestReauthenticate(t *testing.T) {
+	transport := &testTransport{
+		response: SUCCESSFUL_LOGIN_RESPONSE,
+	}
+	id := NewIdentity(USERNAME, PASSWORD, "")
+	id.UseClient(&http.Client{
+		Transport: transport,
+	})
+	err := id.Reauthenticate("")
+	if err != nil {
+		t.Error("Reauth:", err)
+		return
+	}
+	if transport.called != 1 {
+		t.Error("Reauth: Expected an unauthenticated identity to authenticate")
+		return
+	}
+
+	err = id.Reauthenticate("some-older-token")
+	if err != nil {
+		t.Error("Reauth:", err)
+		return
+	}
+	if transport.called != 1 {
+		t.Error("Reauth: Expected a token already replaced to be kept; got", transport.called, "requests")
+		return
+	}
+
+	err = id.Reauthenticate(TOKEN)
+	if err != nil {
+		t.Error("Reauth:", err)
+		return
+	}
+	if transport.called != 2 {
+		t.Error("Reauth: Expected the current token to be replaced; got", transport.called, "requests")
+		return
+	}
+}
+
+func TestReauthenticateWithContext(t *testing.T) {
+	blocked := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	id := NewIdentity(USERNAME, PASSWORD, "")
+	id.UseClient(&http.Client{Transport: blocked})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := id.ReauthenticateWithContext(ctx, ""); err == nil {
+		t.Error("Reauth: Expected an unresponsive Identity service to be abandoned with the context")
+		return
+	}
+
+	id.refreshLock <- struct{}{}
+	defer func() { <-id.refreshLock }()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := id.ReauthenticateWithContext(ctx, ""); err != context.DeadlineExceeded {
+		t.Error("Reauth: Expected to give up waiting on another renewal; got", err)
+		return
+	}
+}
+
+// roundTripFunc adapts a function into a net/http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestParseExpires(t *testing.T) {
+	expected := time.Date(2012, 4, 13, 18, 15, 0, 0, time.UTC)
+	for _, s := range []string{EXPIRES, "2012-04-13T18:15:00Z", "2012-04-13T18:15:00"} {
+		exp, err := ParseExpires(s)
+		if err != nil {
+			t.Error("ParseExpires:", err)
+			return
+		}
+		if !exp.Equal(expected) {
+			t.Error("ParseExpires: expected", expected, "for", s, "got:", exp)
+			return
+		}
+	}
+
+	_, err := ParseExpires("tomorrow")
+	if err == nil {
+		t.Error("ParseExpires: expected an error for a malformed timestamp")
+		return
+	}
+}
+
+func TestAuthenticationWithTokenStore(t *testing.T) {
+	transport := &testTransport{
+		response: strings.Replace(SUCCESSFUL_LOGIN_RESPONSE, "2012-04-13", "2099-04-13", 1),
+	}
+	store := gorax.NewMemoryTokenStore()
+	for i := 0; i < 2; i++ {
+		id := NewIdentity(USERNAME, PASSWORD, "")
+		id.UseClient(&http.Client{
+			Transport: transport,
+		})
+		id.UseTokenStore(store)
+		err := id.Authenticate()
+		if err != nil {
+			t.Error("Auth:", err)
+			return
+		}
+		tok, _ := id.Token()
+		if tok != TOKEN {
+			t.Error("Auth: expected token", TOKEN, "got:", tok)
+			return
+		}
+	}
+	if transport.called != 1 {
+		t.Error("Auth: Expected the second identity to reuse the stored token; got", transport.called, "requests")
+		return
+	}
+	if _, ok := store.Get("https://identity.api.rackspacecloud.com/v2.0", USERNAME); !ok {
+		t.Error("Auth: Expected the token to be stored under the auth URL shared with Keystone middleware")
+		return
+	}
+}
+
+func TestAuthenticationWithAPIKey(t *testing.T) {
+	transport := &testTransport{
+		response: SUCCESSFUL_LOGIN_RESPONSE,
+	}
+	id := NewIdentityWithAPIKey(USERNAME, PASSWORD, "")
+	id.UseClient(&http.Client{
+		Transport: transport,
+	})
+	if id.APIKey() != PASSWORD || id.Password() != "" {
+		t.Error("NewIdentityWithAPIKey: expected API key, and no password, to be set")
+		return
+	}
+	err := id.Authenticate()
+	if err != nil {
+		t.Error("Auth:", err)
+		return
+	}
+	expected := `{"auth":{"RAX-KSKEY:apiKeyCredentials":{"username":"joe_user","apiKey":"joe_user_api_key_opaque_string"}}}`
+	if transport.request != expected {
+		t.Error("Auth: expected API key credentials", expected, "got:", transport.request)
+		return
+	}
+	tok, _ := id.Token()
+	if tok != TOKEN {
+		t.Error("Auth: Misparsed token: expected", TOKEN, "got:", tok)
+		return
+	}
+
+	id = NewIdentityWithAPIKey(USERNAME, PASSWORD, "")
+	id.SetCredentials(USERNAME, PASSWORD, "")
+	if id.APIKey() != "" {
+		t.Error("SetCredentials: expected the API key to be forgotten")
+		return
+	}
+	id.SetAPIKeyCredentials(USERNAME, PASSWORD, "lon")
+	if id.APIKey() != PASSWORD || id.Password() != "" || id.AuthEndpoint() != UK_ENDPOINT {
+		t.Error("SetAPIKeyCredentials: expected API key credentials for the UK")
+		return
+	}
+}
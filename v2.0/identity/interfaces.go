@@ -2,13 +2,17 @@
 
 package identity
 
+import "context"
+
 // The Identity interface encapsulates both the set of credentials used to
 // authenticate against the Rackspace Identity API, as well as the relevant
 // proof of authentication once acquired.
 type Identity interface {
 	SetCredentials(userName, password, reg string)
+	SetAPIKeyCredentials(userName, apiKey, reg string)
 	Username() string
 	Password() string
+	APIKey() string
 	Region() string
 	Token() (string, error)
 	Expires() (string, error)
@@ -20,3 +24,17 @@ type Identity interface {
 	Roles() ([]Role, error)
 	Authenticate() error
 }
+
+// A Reauthenticator is an Identity able to replace its token on demand, as when the token expires or is revoked.
+// Clients holding such an identity for a long time, like a servers.Region, use it to renew their access transparently.
+//
+// Reauthenticate(staleToken) obtains a new token, unless the identity's token already differs from staleToken,
+// in which case another goroutine has renewed it already.
+// ReauthenticateWithContext(ctx, staleToken) does likewise, but gives up once the context ends,
+// whether while waiting on another goroutine's renewal or on the Identity service itself.
+// Implementations must be safe for concurrent use.
+type Reauthenticator interface {
+	Identity
+	Reauthenticate(staleToken string) error
+	ReauthenticateWithContext(ctx context.Context, staleToken string) error
+}
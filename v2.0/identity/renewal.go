@@ -0,0 +1,70 @@
+// vim: ts=8 sw=8 noet ai
+
+package identity
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RenewalBackoff is how long a Renewer waits, after failing to renew a token, before it tries again.
+var RenewalBackoff = 30 * time.Second
+
+// A Renewer renews an identity's token shortly before it expires, on behalf of a client which stamps its requests with that token.
+// Should a renewal fail while the old token remains valid, the client carries on with the old token,
+// and the Renewer lets RenewalBackoff pass before trying again;
+// an outage of the Identity service thus doesn't cost every request a futile re-authentication.
+//
+// The zero value is ready to use.  A Renewer is safe for concurrent use.
+type Renewer struct {
+	lock     sync.Mutex
+	failedAt time.Time
+	failure  error
+}
+
+// Renew re-authenticates the identity if its token expires within the given margin.
+// A failed renewal is reported only if the old token has expired outright; until then, the old token remains usable.
+// Renewals abandoned because the context ended don't count as failures.
+func (r *Renewer) Renew(ctx context.Context, id Reauthenticator, margin time.Duration) error {
+	token, err := id.Token()
+	if err != nil {
+		return err
+	}
+	stamp, err := id.Expires()
+	if err != nil {
+		return nil
+	}
+	expires, err := ParseExpires(stamp)
+	if err != nil || !time.Now().Add(margin).After(expires) {
+		return nil
+	}
+
+	r.lock.Lock()
+	failedAt, failure := r.failedAt, r.failure
+	r.lock.Unlock()
+
+	if time.Since(failedAt) < RenewalBackoff {
+		if time.Now().After(expires) {
+			return failure
+		}
+		return nil
+	}
+
+	err = id.ReauthenticateWithContext(ctx, token)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	r.failedAt, r.failure = time.Time{}, nil
+	if err != nil {
+		r.failedAt, r.failure = time.Now(), err
+	}
+	r.lock.Unlock()
+
+	if err != nil && time.Now().After(expires) {
+		return err
+	}
+	return nil
+}
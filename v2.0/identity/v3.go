@@ -0,0 +1,528 @@
+// vim: ts=8 sw=8 noet ai
+
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/racker/gorax"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// SubjectTokenHeader names the response header in which Keystone v3 returns a newly issued token.
+const SubjectTokenHeader = "X-Subject-Token"
+
+// ErrAPIKeyUnsupported is returned when a Keystone v3 identity is asked to authenticate with an API key.
+// API keys are a Rackspace extension to Identity v2.0; use an application credential instead.
+var ErrAPIKeyUnsupported = errors.New("Keystone v3 does not support API key credentials")
+
+// V3Credentials describes how a Keystone v3 identity proves who it is, and the scope of the token it asks for.
+//
+// One authentication method applies, chosen in this order:
+//
+//   - Application credential, if ApplicationCredentialSecret is set.
+//     The credential is identified by ApplicationCredentialId, or by ApplicationCredentialName together with its owning user.
+//     Application credentials carry their own scope, so the scope fields are ignored.
+//   - Token, if Token is set.  This exchanges an existing token for one with the requested scope.
+//     Since the new token expires no later than the old one, such identities cannot re-authenticate indefinitely.
+//   - Password, otherwise.
+//
+// Users are identified by UserId, or by Username together with the user's domain (UserDomainId or UserDomainName).
+//
+// The token is scoped to a project, identified by ProjectId, or by ProjectName together with the project's domain;
+// failing that, to a domain, identified by DomainId or DomainName.
+// Without any scope, Keystone issues an unscoped token, which carries no service catalog.
+type V3Credentials struct {
+	UserId, Username, UserDomainId, UserDomainName                                  string
+	Password                                                                        string
+	ApplicationCredentialId, ApplicationCredentialName, ApplicationCredentialSecret string
+	Token                                                                           string
+	ProjectId, ProjectName, ProjectDomainId, ProjectDomainName                      string
+	DomainId, DomainName                                                            string
+}
+
+// The v3Identity structure is the Keystone v3 counterpart of the identity structure.
+// It authenticates through a gorax.RestClient, and presents the token's project as its tenant,
+// and its catalog in the same shape as an Identity v2.0 catalog, so that it may stand in wherever an Identity is expected.
+type v3Identity struct {
+	authURL         string
+	creds           V3Credentials
+	apiKey          string
+	region          string
+	client          *gorax.RestClient
+	isAuthenticated bool
+	token           string
+	details         *V3Token
+	lock            sync.RWMutex
+	refreshLock     chan struct{}
+}
+
+// NewV3Identity creates a set of papers to use for authentication against a Keystone v3 identity service.
+// The authURL locates the service's v3 API, e.g., "https://keystone.example.com:5000/v3".
+// Any options given configure how the identity reaches Keystone; see gorax.ClientOption.
+func NewV3Identity(authURL string, creds V3Credentials, opts ...gorax.ClientOption) *v3Identity {
+	client := gorax.MakeRestClient(strings.TrimSuffix(authURL, "/"), opts...)
+	client.Service = "identity"
+	return &v3Identity{
+		authURL:     client.BaseUrl,
+		creds:       creds,
+		client:      client,
+		refreshLock: make(chan struct{}, 1),
+	}
+}
+
+// SetCredentials may be used to alter the current set of credentials,
+// provided the identity has not yet been authenticated.
+// The identity will authenticate with the given user name and password, within the user's existing domain.
+func (id *v3Identity) SetCredentials(userName, pw, reg string) {
+	id.lock.Lock()
+	defer id.lock.Unlock()
+
+	if !id.isAuthenticated {
+		id.creds.UserId = ""
+		id.creds.Username = userName
+		id.creds.Password = pw
+		id.creds.ApplicationCredentialSecret = ""
+		id.creds.Token = ""
+		id.apiKey = ""
+		id.region = strings.ToUpper(reg)
+	}
+}
+
+// SetAPIKeyCredentials records the given credentials, forgetting any password, application credential or token the identity was given,
+// but Keystone v3 has no API keys; authentication will fail with ErrAPIKeyUnsupported.
+func (id *v3Identity) SetAPIKeyCredentials(userName, apiKey, reg string) {
+	id.lock.Lock()
+	defer id.lock.Unlock()
+
+	if !id.isAuthenticated {
+		id.creds.UserId = ""
+		id.creds.Username = userName
+		id.creds.Password = ""
+		id.creds.ApplicationCredentialSecret = ""
+		id.creds.Token = ""
+		id.apiKey = apiKey
+		id.region = strings.ToUpper(reg)
+	}
+}
+
+// SetRegion sets the region the identity reports as its own, without altering its credentials.
+func (id *v3Identity) SetRegion(reg string) {
+	id.lock.Lock()
+	defer id.lock.Unlock()
+	id.region = strings.ToUpper(reg)
+}
+
+// Username yields the name of the user the identity authenticates as.
+func (id *v3Identity) Username() string {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+	return id.creds.Username
+}
+
+// Password yields the identity's password, if it authenticates with one.
+func (id *v3Identity) Password() string {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+	return id.creds.Password
+}
+
+// APIKey yields the API key given to SetAPIKeyCredentials, if any.
+func (id *v3Identity) APIKey() string {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+	return id.apiKey
+}
+
+// Region yields the region given to SetCredentials, in uppercase, or "" if none was.
+// Keystone v3 services are not tied to a region, so it plays no part in authentication.
+func (id *v3Identity) Region() string {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+	return id.region
+}
+
+// Token yields the authentication token.
+// If not authenticated, an error is returned.
+func (id *v3Identity) Token() (string, error) {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
+		return "", fmt.Errorf("Not authenticated")
+	}
+	return id.token, nil
+}
+
+// Expires yields the token's expiration timestamp in ISO8601 format.
+// If not authenticated, an error is returned.
+func (id *v3Identity) Expires() (string, error) {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
+		return "", fmt.Errorf("Not authenticated")
+	}
+	return id.details.ExpiresAt, nil
+}
+
+// TenantId yields the ID of the project to which the token is scoped, or "" for domain-scoped and unscoped tokens.
+// If not authenticated, an error is returned.
+func (id *v3Identity) TenantId() (string, error) {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
+		return "", fmt.Errorf("Not authenticated")
+	}
+	if id.details.Project == nil {
+		return "", nil
+	}
+	return id.details.Project.Id, nil
+}
+
+// TenantName yields the name of the project to which the token is scoped, or "" for domain-scoped and unscoped tokens.
+// If not authenticated, an error is returned.
+func (id *v3Identity) TenantName() (string, error) {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
+		return "", fmt.Errorf("Not authenticated")
+	}
+	if id.details.Project == nil {
+		return "", nil
+	}
+	return id.details.Project.Name, nil
+}
+
+// AuthEndpoint yields which API endpoint will be used to perform the authentication.
+func (id *v3Identity) AuthEndpoint() string {
+	return id.authURL + "/auth/tokens"
+}
+
+// IsAuthenticated reports on whether or not the credentials have been verified.
+func (id *v3Identity) IsAuthenticated() bool {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+	return id.isAuthenticated
+}
+
+// ServiceCatalog yields the token's service catalog, rearranged to resemble an Identity v2.0 catalog.
+// Each of a service's regions yields one EntryEndpoint, whose PublicURL, InternalURL and AdminURL
+// hold the URLs of the public, internal and admin interfaces respectively.
+// An error is returned if not authenticated.
+func (id *v3Identity) ServiceCatalog() ([]CatalogEntry, error) {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
+		return nil, fmt.Errorf("Not authenticated")
+	}
+	tenantId := ""
+	if id.details.Project != nil {
+		tenantId = id.details.Project.Id
+	}
+	return v3Catalog(id.details.Catalog, tenantId), nil
+}
+
+// Roles yields the roles the user holds within the token's scope.
+// An error is returned if not authenticated.
+func (id *v3Identity) Roles() ([]Role, error) {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
+		return nil, fmt.Errorf("Not authenticated")
+	}
+	roles := make([]Role, len(id.details.Roles))
+	for i, r := range id.details.Roles {
+		roles[i] = Role{Id: r.Id, Name: r.Name}
+	}
+	return roles, nil
+}
+
+// Details yields the token exactly as Keystone described it, including its scope, user, and the native form of its catalog.
+// An error is returned if not authenticated.
+func (id *v3Identity) Details() (*V3Token, error) {
+	id.lock.RLock()
+	defer id.lock.RUnlock()
+
+	if !id.isAuthenticated {
+		return nil, fmt.Errorf("Not authenticated")
+	}
+	return id.details, nil
+}
+
+// Authenticate attempts to verify this identity's credentials, obtaining a token from Keystone.
+// It may be called again at any time to obtain a fresh token; until it succeeds, the previous token remains in effect.
+func (id *v3Identity) Authenticate() error {
+	return id.AuthenticateWithContext(context.Background())
+}
+
+// AuthenticateWithContext behaves like Authenticate, but abandons the request to Keystone should the context end first.
+func (id *v3Identity) AuthenticateWithContext(ctx context.Context) error {
+	id.lock.RLock()
+	creds, apiKey := id.creds, id.apiKey
+	id.lock.RUnlock()
+
+	if apiKey != "" && creds.Password == "" && creds.ApplicationCredentialSecret == "" && creds.Token == "" {
+		return ErrAPIKeyUnsupported
+	}
+
+	resp, err := id.client.PerformRequestWithContext(ctx, &gorax.RestRequest{
+		Method:              "POST",
+		Path:                "/auth/tokens",
+		Body:                &gorax.JSONRequestBody{Object: v3AuthRequest(creds)},
+		ExpectedStatusCodes: []int{http.StatusCreated},
+		Operation:           "identity.Authenticate",
+	})
+	if err != nil {
+		return err
+	}
+
+	body := &V3TokenBody{}
+	err = resp.DeserializeBody(body)
+	if err != nil {
+		return err
+	}
+	token := resp.Header.Get(SubjectTokenHeader)
+	if token == "" {
+		return fmt.Errorf("Keystone response lacks an %s header", SubjectTokenHeader)
+	}
+
+	id.lock.Lock()
+	defer id.lock.Unlock()
+
+	id.isAuthenticated = true
+	id.token = token
+	id.details = &body.Token
+	return nil
+}
+
+// Reauthenticate replaces the identity's token with a fresh one, unless the token has already been replaced since staleToken was obtained.
+// Only one re-authentication proceeds at a time; see the Reauthenticator interface.
+func (id *v3Identity) Reauthenticate(staleToken string) error {
+	return id.ReauthenticateWithContext(context.Background(), staleToken)
+}
+
+// ReauthenticateWithContext behaves like Reauthenticate, but gives up if the context ends while waiting on the refresh lock or on Keystone.
+func (id *v3Identity) ReauthenticateWithContext(ctx context.Context, staleToken string) error {
+	select {
+	case id.refreshLock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() {
+		<-id.refreshLock
+	}()
+
+	id.lock.RLock()
+	replaced := id.isAuthenticated && id.token != staleToken
+	id.lock.RUnlock()
+
+	if replaced {
+		return nil
+	}
+	return id.AuthenticateWithContext(ctx)
+}
+
+// UseClient configures the identity to reach Keystone through a specific net/http client.
+func (id *v3Identity) UseClient(c *http.Client) {
+	id.client.UseClient(c)
+}
+
+// V3TokenBody encapsulates the body of a Keystone v3 token response.
+// You'll probably rarely use this record directly, unless you intend on marshalling or unmarshalling
+// Identity v3 JSON records yourself.
+type V3TokenBody struct {
+	Token V3Token `json:"token"`
+}
+
+// V3Token describes a Keystone v3 token: when it expires, whom it represents, and what it grants access to.
+// Project is nil unless the token is project-scoped, and Domain nil unless it is domain-scoped.
+type V3Token struct {
+	ExpiresAt string           `json:"expires_at"`
+	IssuedAt  string           `json:"issued_at"`
+	Methods   []string         `json:"methods"`
+	User      V3User           `json:"user"`
+	Project   *V3Project       `json:"project"`
+	Domain    *V3Domain        `json:"domain"`
+	Roles     []V3Role         `json:"roles"`
+	Catalog   []V3CatalogEntry `json:"catalog"`
+}
+
+// V3User identifies the user a token represents.
+type V3User struct {
+	Id     string   `json:"id"`
+	Name   string   `json:"name"`
+	Domain V3Domain `json:"domain"`
+}
+
+// V3Project identifies the project to which a token is scoped.
+type V3Project struct {
+	Id     string   `json:"id"`
+	Name   string   `json:"name"`
+	Domain V3Domain `json:"domain"`
+}
+
+// V3Domain identifies a domain, which owns users and projects.
+type V3Domain struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// V3Role identifies a role assigned to the user within the token's scope.
+type V3Role struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// V3CatalogEntry describes a service in a Keystone v3 catalog.
+type V3CatalogEntry struct {
+	Id        string       `json:"id"`
+	Type      string       `json:"type"`
+	Name      string       `json:"name"`
+	Endpoints []V3Endpoint `json:"endpoints"`
+}
+
+// V3Endpoint describes one interface of a service in one region.
+// Interface is "public", "internal" or "admin".
+type V3Endpoint struct {
+	Id        string `json:"id"`
+	Interface string `json:"interface"`
+	Region    string `json:"region"`
+	RegionId  string `json:"region_id"`
+	URL       string `json:"url"`
+}
+
+// v3Catalog rearranges a v3 catalog into v2.0 form, with one endpoint per region holding the URL of each interface.
+// Regions appear in the order Keystone first lists them.
+func v3Catalog(catalog []V3CatalogEntry, tenantId string) []CatalogEntry {
+	entries := make([]CatalogEntry, 0, len(catalog))
+	for _, service := range catalog {
+		entry := CatalogEntry{Name: service.Name, Type: service.Type}
+		byRegion := map[string]int{}
+		for _, ep := range service.Endpoints {
+			region := ep.RegionId
+			if region == "" {
+				region = ep.Region
+			}
+			i, ok := byRegion[region]
+			if !ok {
+				i = len(entry.Endpoints)
+				byRegion[region] = i
+				entry.Endpoints = append(entry.Endpoints, EntryEndpoint{Region: region, TenantId: tenantId})
+			}
+			switch ep.Interface {
+			case "public":
+				entry.Endpoints[i].PublicURL = ep.URL
+			case "internal":
+				entry.Endpoints[i].InternalURL = ep.URL
+			case "admin":
+				entry.Endpoints[i].AdminURL = ep.URL
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// These types describe the body of a v3 authentication request.
+// Fields left empty are omitted, as Keystone rejects, e.g., a user with both an empty ID and a name.
+
+type v3AuthContainer struct {
+	Auth v3Auth `json:"auth"`
+}
+
+type v3Auth struct {
+	Identity v3AuthIdentity `json:"identity"`
+	Scope    *v3Scope       `json:"scope,omitempty"`
+}
+
+type v3AuthIdentity struct {
+	Methods               []string                  `json:"methods"`
+	Password              *v3PasswordMethod         `json:"password,omitempty"`
+	Token                 *v3TokenMethod            `json:"token,omitempty"`
+	ApplicationCredential *v3ApplicationCredentials `json:"application_credential,omitempty"`
+}
+
+type v3PasswordMethod struct {
+	User v3UserRef `json:"user"`
+}
+
+type v3TokenMethod struct {
+	Id string `json:"id"`
+}
+
+type v3ApplicationCredentials struct {
+	Id     string     `json:"id,omitempty"`
+	Name   string     `json:"name,omitempty"`
+	Secret string     `json:"secret"`
+	User   *v3UserRef `json:"user,omitempty"`
+}
+
+type v3UserRef struct {
+	Id       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Domain   *v3Ref `json:"domain,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type v3Ref struct {
+	Id     string `json:"id,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Domain *v3Ref `json:"domain,omitempty"`
+}
+
+type v3Scope struct {
+	Project *v3Ref `json:"project,omitempty"`
+	Domain  *v3Ref `json:"domain,omitempty"`
+}
+
+// ref yields a reference to the entity with the given ID or name, the latter qualified by the given domain; or nil, given neither.
+func ref(id, name string, domain *v3Ref) *v3Ref {
+	if id != "" {
+		return &v3Ref{Id: id}
+	}
+	if name != "" {
+		return &v3Ref{Name: name, Domain: domain}
+	}
+	return nil
+}
+
+// v3AuthRequest yields the body of the request Keystone v3 requires to authenticate with the given credentials.
+func v3AuthRequest(c V3Credentials) *v3AuthContainer {
+	user := &v3UserRef{Id: c.UserId}
+	if c.UserId == "" {
+		user.Name = c.Username
+		user.Domain = ref(c.UserDomainId, c.UserDomainName, nil)
+	}
+
+	req := &v3AuthContainer{}
+	switch {
+	case c.ApplicationCredentialSecret != "":
+		ac := &v3ApplicationCredentials{Id: c.ApplicationCredentialId, Secret: c.ApplicationCredentialSecret}
+		if c.ApplicationCredentialId == "" {
+			ac.Name = c.ApplicationCredentialName
+			ac.User = user
+		}
+		req.Auth.Identity = v3AuthIdentity{Methods: []string{"application_credential"}, ApplicationCredential: ac}
+		return req
+	case c.Token != "":
+		req.Auth.Identity = v3AuthIdentity{Methods: []string{"token"}, Token: &v3TokenMethod{c.Token}}
+	default:
+		user.Password = c.Password
+		req.Auth.Identity = v3AuthIdentity{Methods: []string{"password"}, Password: &v3PasswordMethod{*user}}
+	}
+
+	if project := ref(c.ProjectId, c.ProjectName, ref(c.ProjectDomainId, c.ProjectDomainName, nil)); project != nil {
+		req.Auth.Scope = &v3Scope{Project: project}
+	} else if domain := ref(c.DomainId, c.DomainName, nil); domain != nil {
+		req.Auth.Scope = &v3Scope{Domain: domain}
+	}
+	return req
+}
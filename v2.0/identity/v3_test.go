@@ -0,0 +1,181 @@
+// vim: ts=8 sw=8 noet ai
+
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const V3_TOKEN_RESPONSE = `{
+	"token": {
+		"methods": ["password"],
+		"expires_at": "2099-11-06T15:32:17.893769Z",
+		"issued_at": "2099-11-05T15:32:17.893769Z",
+		"user": {"id": "u1", "name": "joe_user", "domain": {"id": "default", "name": "Default"}},
+		"project": {"id": "p1", "name": "ops", "domain": {"id": "default", "name": "Default"}},
+		"roles": [{"id": "r1", "name": "member"}],
+		"catalog": [{
+			"id": "s1",
+			"type": "compute",
+			"name": "nova",
+			"endpoints": [
+				{"id": "e1", "interface": "public", "region": "RegionOne", "region_id": "RegionOne", "url": "https://nova.example.com/v2.1/p1"},
+				{"id": "e2", "interface": "internal", "region": "RegionOne", "region_id": "RegionOne", "url": "http://nova.internal:8774/v2.1/p1"},
+				{"id": "e3", "interface": "admin", "region": "RegionOne", "region_id": "RegionOne", "url": "http://nova.admin:8774/v2.1/p1"},
+				{"id": "e4", "interface": "public", "region": "RegionTwo", "region_id": "RegionTwo", "url": "https://nova2.example.com/v2.1/p1"}
+			]
+		}]
+	}
+}`
+
+// v3Transport plays Keystone v3, issuing the given token and recording the last request body it saw.
+type v3Transport struct {
+	token   string
+	request string
+}
+
+func (t *v3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := ioutil.ReadAll(req.Body)
+	t.request = string(body)
+
+	status, response := http.StatusCreated, V3_TOKEN_RESPONSE
+	if req.URL.Path != "/v3/auth/tokens" {
+		status, response = http.StatusNotFound, `{"error": {"code": 404}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}, SubjectTokenHeader: {t.token}},
+		Body:       ioutil.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func TestV3Authentication(t *testing.T) {
+	transport := &v3Transport{token: "v3-token"}
+	id := NewV3Identity("https://keystone.example.com/v3/", V3Credentials{
+		Username:          USERNAME,
+		UserDomainName:    "Default",
+		Password:          PASSWORD,
+		ProjectName:       "ops",
+		ProjectDomainName: "Default",
+	})
+	id.UseClient(&http.Client{Transport: transport})
+	if id.AuthEndpoint() != "https://keystone.example.com/v3/auth/tokens" {
+		t.Error("V3: unexpected auth endpoint", id.AuthEndpoint())
+		return
+	}
+
+	err := id.Authenticate()
+	if err != nil {
+		t.Error("V3:", err)
+		return
+	}
+	expected := `{"auth":{"identity":{"methods":["password"],"password":{"user":{"name":"joe_user","domain":{"name":"Default"},"password":"joe_user_api_key_opaque_string"}}},"scope":{"project":{"name":"ops","domain":{"name":"Default"}}}}}`
+	if transport.request != expected {
+		t.Error("V3: expected request", expected, "got:", transport.request)
+		return
+	}
+
+	tok, _ := id.Token()
+	tenantId, _ := id.TenantId()
+	tenantName, _ := id.TenantName()
+	if tok != "v3-token" || tenantId != "p1" || tenantName != "ops" {
+		t.Error("V3: expected token, tenant ID and name from the response; got", tok, tenantId, tenantName)
+		return
+	}
+	exp, _ := id.Expires()
+	if _, err := ParseExpires(exp); err != nil {
+		t.Error("V3:", err)
+		return
+	}
+	roles, _ := id.Roles()
+	if len(roles) != 1 || roles[0].Name != "member" {
+		t.Error("V3: expected the member role; got", roles)
+		return
+	}
+
+	sc, _ := id.ServiceCatalog()
+	if len(sc) != 1 || sc[0].Type != "compute" || len(sc[0].Endpoints) != 2 {
+		t.Error("V3: expected one compute service in two regions; got", sc)
+		return
+	}
+	ep := sc[0].Endpoints[0]
+	if ep.Region != "RegionOne" || ep.TenantId != "p1" || ep.PublicURL != "https://nova.example.com/v2.1/p1" ||
+		ep.InternalURL != "http://nova.internal:8774/v2.1/p1" || ep.AdminURL != "http://nova.admin:8774/v2.1/p1" {
+		t.Error("V3: misconverted endpoint", ep)
+		return
+	}
+}
+
+func TestV3AuthRequests(t *testing.T) {
+	cases := []struct {
+		creds    V3Credentials
+		expected string
+	}{
+		{
+			V3Credentials{UserId: "u1", Password: "pw", DomainId: "d1"},
+			`{"auth":{"identity":{"methods":["password"],"password":{"user":{"id":"u1","password":"pw"}}},"scope":{"domain":{"id":"d1"}}}}`,
+		},
+		{
+			V3Credentials{Token: "old", ProjectId: "p1"},
+			`{"auth":{"identity":{"methods":["token"],"token":{"id":"old"}},"scope":{"project":{"id":"p1"}}}}`,
+		},
+		{
+			V3Credentials{ApplicationCredentialId: "ac1", ApplicationCredentialSecret: "s", ProjectId: "ignored"},
+			`{"auth":{"identity":{"methods":["application_credential"],"application_credential":{"id":"ac1","secret":"s"}}}}`,
+		},
+		{
+			V3Credentials{ApplicationCredentialName: "cron", ApplicationCredentialSecret: "s", Username: "joe", UserDomainId: "default"},
+			`{"auth":{"identity":{"methods":["application_credential"],"application_credential":{"name":"cron","secret":"s","user":{"name":"joe","domain":{"id":"default"}}}}}}`,
+		},
+	}
+	for _, c := range cases {
+		data, err := json.Marshal(v3AuthRequest(c.creds))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(data) != c.expected {
+			t.Error("V3: expected request", c.expected, "got:", string(data))
+			return
+		}
+	}
+}
+
+func TestV3RejectsAPIKeys(t *testing.T) {
+	id := NewV3Identity("https://keystone.example.com/v3", V3Credentials{})
+	id.SetAPIKeyCredentials(USERNAME, PASSWORD, "")
+	if err := id.Authenticate(); err != ErrAPIKeyUnsupported {
+		t.Error("V3: expected API keys to be refused; got", err)
+		return
+	}
+	id = NewV3Identity("https://keystone.example.com/v3", V3Credentials{})
+	id.UseClient(&http.Client{Transport: &v3Transport{token: "v3-token"}})
+	id.SetCredentials(USERNAME, PASSWORD, "")
+	id.SetAPIKeyCredentials(USERNAME, "key", "")
+	if err := id.Authenticate(); err != ErrAPIKeyUnsupported {
+		t.Error("V3: expected an API key to displace the password given before it; got", err)
+		return
+	}
+}
+
+func TestV3AuthenticateWithContext(t *testing.T) {
+	id := NewV3Identity("https://keystone.example.com/v3", V3Credentials{UserId: "u1", Password: PASSWORD})
+	id.UseClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := id.AuthenticateWithContext(ctx); err == nil || id.IsAuthenticated() {
+		t.Error("V3: expected an unresponsive Keystone to be abandoned with the context; got", err)
+		return
+	}
+}